@@ -0,0 +1,108 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/awslabs/ssosync/internal"
+
+	"github.com/spf13/cobra"
+)
+
+// planOut is the file the plan is written to
+var planOut string
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes a sync would make in AWS SSO",
+	Long: `Computes the differences between Google Workspace and AWS SSO and
+prints the users, groups and group members that a sync would create,
+update or delete, without making any change in AWS SSO.
+
+With --out the plan is also saved as JSON, so it can be reviewed and
+applied later with 'ssosync apply'.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := runContext(cmd.Context())
+		defer cancel()
+
+		plan, err := internal.DoPlan(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		if planOut != "" {
+			if err := writePlan(plan, planOut); err != nil {
+				return err
+			}
+		}
+
+		return plan.Print(cmd.OutOrStdout())
+	},
+}
+
+// writePlan saves the plan to the file given, the plan is written to a
+// temporary file renamed over it so a failed write keeps the previous one
+func writePlan(plan *internal.Plan, name string) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", name, err)
+	}
+
+	if err := plan.Write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply PLAN_FILE",
+	Short: "Apply a plan saved with 'ssosync plan --out'",
+	Long: `Applies the changes of a plan saved with 'ssosync plan --out'.
+
+Before making any change, the plan is checked against the current state of
+AWS SSO, if the users, groups or group members changed since the plan was
+created the plan is refused and nothing is applied.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := runContext(cmd.Context())
+		defer cancel()
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", args[0], err)
+		}
+		defer f.Close()
+
+		plan, err := internal.ReadPlan(f)
+		if err != nil {
+			return err
+		}
+
+		return internal.DoApply(ctx, cfg, plan)
+	},
+}
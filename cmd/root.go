@@ -19,7 +19,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/awslabs/ssosync/internal"
 	"github.com/awslabs/ssosync/internal/config"
@@ -42,6 +45,10 @@ var (
 
 var cfg *config.Config
 
+// exitDeletionLimit is the exit code used when the sync is aborted because
+// of the deletion limits, so it can be told apart from other failures
+const exitDeletionLimit = 3
+
 var rootCmd = &cobra.Command{
 	Version: "dev",
 	Use:     "ssosync",
@@ -50,7 +57,7 @@ var rootCmd = &cobra.Command{
 Apps (Google Workspace) users to AWS Single Sign-on (AWS SSO)
 Complete documentation is available at https://github.com/awslabs/ssosync`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx, cancel := context.WithCancel(context.Background())
+		ctx, cancel := runContext(cmd.Context())
 		defer cancel()
 
 		err := internal.DoSync(ctx, cfg)
@@ -62,15 +69,51 @@ Complete documentation is available at https://github.com/awslabs/ssosync`,
 	},
 }
 
+// lambdaMargin is the time kept before the Lambda deadline to persist
+// the datastore once the run is cancelled
+const lambdaMargin = 10 * time.Second
+
+// runContext returns the context of a run. It is cancelled on SIGINT or
+// SIGTERM, after --timeout and before the deadline of the Lambda invocation,
+// the sync stops cleanly and persists the datastore.
+func runContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	cancels := []context.CancelFunc{stop}
+
+	if deadline, ok := parent.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-lambdaMargin))
+		cancels = append(cancels, cancel)
+	}
+
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		cancels = append(cancels, cancel)
+	}
+
+	return ctx, func() {
+		for i := len(cancels) - 1; i >= 0; i-- {
+			cancels[i]()
+		}
+	}
+}
+
 // Execute is the entry point of the command. If we are
 // running inside of AWS Lambda, we use the Lambda
 // execution path.
 func Execute() {
 	if cfg.IsLambda {
-		lambda.Start(rootCmd.Execute)
+		lambda.Start(func(ctx context.Context) error {
+			return rootCmd.ExecuteContext(ctx)
+		})
 	}
 
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Is(err, internal.ErrDeletionLimit) {
+			log.Error(err)
+			os.Exit(exitDeletionLimit)
+		}
 		log.Fatal(err)
 	}
 }
@@ -83,6 +126,20 @@ func init() {
 	// initialize cobra
 	cobra.OnInitialize(initConfig)
 	addFlags(rootCmd, cfg)
+	addSyncFlags(planCmd, cfg)
+	planCmd.Flags().StringVarP(&planOut, "out", "o", "", "write the plan as JSON to this file, to be used with 'ssosync apply'")
+	rootCmd.AddCommand(planCmd)
+	addSyncFlags(applyCmd, cfg)
+	addForceFlag(applyCmd, cfg)
+	rootCmd.AddCommand(applyCmd)
+	addSyncFlags(watchCmd, cfg)
+	addForceFlag(watchCmd, cfg)
+	addIncrementalFlags(watchCmd, cfg)
+	watchCmd.Flags().BoolVarP(&cfg.DryRun, "dry-run", "", config.DefaultDryRun, "compute and log the changes without applying them to AWS SSO")
+	watchCmd.Flags().DurationVarP(&cfg.IncrementalInterval, "incremental-interval", "", config.DefaultIncrementalInterval, "duration between two syncs of the changes")
+	watchCmd.Flags().StringVarP(&cfg.NotificationAddress, "notification-address", "", "", "HTTPS URL Google Workspace pushes the admin activities to, it must reach --listen-address, NOTE: only works with --incremental 'push'")
+	watchCmd.Flags().StringVarP(&cfg.ListenAddress, "listen-address", "", config.DefaultListenAddress, "address the admin activities pushed by Google Workspace are received on")
+	rootCmd.AddCommand(watchCmd)
 
 	rootCmd.SetVersionTemplate(fmt.Sprintf("%s, commit %s, built at %s by %s\n", version, commit, date, builtBy))
 
@@ -102,18 +159,53 @@ func initConfig() {
 		"google_credentials",
 		"scim_access_token",
 		"scim_endpoint",
+		"scim_page_size",
+		"scim_requests_per_second",
+		"scim_burst",
+		"concurrency",
 		"log_level",
 		"log_format",
 		"ignore_users",
 		"ignore_groups",
 		"include_groups",
+		"protected_users",
+		"protected_groups",
+		"user_filter",
+		"group_filter",
+		"user_attributes",
 		"user_match",
 		"group_match",
+		"group_name_template",
+		"group_name_rewrites",
 		"sync_method",
+		"nested_groups",
+		"prefetch",
+		"org_units",
+		"org_units_recursive",
+		"org_unit_groups",
 		"datastore_type",
 		"datastore_prefix",
 		"datastore_user_name",
 		"datastore_group_name",
+		"datastore_group_id_obj",
+		"datastore_state_obj",
+		"incremental",
+		"full_sync_interval",
+		"incremental_interval",
+		"notification_address",
+		"listen_address",
+		"dry_run",
+		"max_deletions",
+		"max_deletions_percent",
+		"force",
+		"manage_unowned",
+		"deleted_users",
+		"suspended_users",
+		"archived_users",
+		"removed_users",
+		"deletion_grace_period",
+		"deprovision_action",
+		"timeout",
 	}
 
 	for _, e := range appEnvVars {
@@ -181,24 +273,74 @@ func configLambda() {
 }
 
 func addFlags(cmd *cobra.Command, cfg *config.Config) {
-	rootCmd.PersistentFlags().StringVarP(&cfg.GoogleCredentials, "google-admin", "a", config.DefaultGoogleCredentials, "path to find credentials file for Google Workspace")
-	rootCmd.PersistentFlags().BoolVarP(&cfg.Debug, "debug", "d", config.DefaultDebug, "enable verbose / debug logging")
-	rootCmd.PersistentFlags().StringVarP(&cfg.LogFormat, "log-format", "", config.DefaultLogFormat, "log format")
-	rootCmd.PersistentFlags().StringVarP(&cfg.LogLevel, "log-level", "", config.DefaultLogLevel, "log level")
-	rootCmd.Flags().StringVarP(&cfg.SCIMAccessToken, "access-token", "t", "", "AWS SSO SCIM API Access Token")
-	rootCmd.Flags().StringVarP(&cfg.SCIMEndpoint, "endpoint", "e", "", "AWS SSO SCIM API Endpoint")
-	rootCmd.Flags().StringVarP(&cfg.GoogleCredentials, "google-credentials", "c", config.DefaultGoogleCredentials, "path to Google Workspace credentials file")
-	rootCmd.Flags().StringVarP(&cfg.GoogleAdmin, "google-admin", "u", "", "Google Workspace admin user email")
-	rootCmd.Flags().StringSliceVar(&cfg.IgnoreUsers, "ignore-users", []string{}, "ignores these Google Workspace users")
-	rootCmd.Flags().StringSliceVar(&cfg.IgnoreGroups, "ignore-groups", []string{}, "ignores these Google Workspace groups")
-	rootCmd.Flags().StringSliceVar(&cfg.IncludeGroups, "include-groups", []string{}, "include only these Google Workspace groups, NOTE: only works when --sync-method 'users_groups'")
-	rootCmd.Flags().StringVarP(&cfg.UserMatch, "user-match", "m", "", "Google Workspace Users filter query parameter, example: 'name:John* email:admin*', see: https://developers.google.com/admin-sdk/directory/v1/guides/search-users")
-	rootCmd.Flags().StringSliceVarP(&cfg.GroupMatch, "group-match", "g", []string{""}, "Google Workspace Groups filter query parameter, example: 'name:Admin* email:aws-*', see: https://developers.google.com/admin-sdk/directory/v1/guides/search-groups (You can specify this flag multiple times for OR clause)")
-	rootCmd.Flags().StringVarP(&cfg.SyncMethod, "sync-method", "s", config.DefaultSyncMethod, "Sync method to use (users_groups|groups)")
-	rootCmd.Flags().StringVarP(&cfg.DatastoreType, "datastore-type", "D", config.DefaultDatastoreType, "Datastore type")
-	rootCmd.Flags().StringVarP(&cfg.DatastorePrefix, "datastore-prefix", "p", config.DefaultDatastorePrefix, "Datastore prefix or bucket")
-	rootCmd.Flags().StringVarP(&cfg.DatastoreUserObj, "datastore-user-obj", "", config.DefaultDatastoreUserObj, "Datastore object name for storing users")
-	rootCmd.Flags().StringVarP(&cfg.DatastoreGroupObj, "datastore-group-obj", "", config.DefaultDatastoreGroupObj, "Datastore object name for storing groups")
+	cmd.PersistentFlags().StringVarP(&cfg.GoogleCredentials, "google-admin", "a", config.DefaultGoogleCredentials, "path to find credentials file for Google Workspace")
+	cmd.PersistentFlags().BoolVarP(&cfg.Debug, "debug", "d", config.DefaultDebug, "enable verbose / debug logging")
+	cmd.PersistentFlags().StringVarP(&cfg.LogFormat, "log-format", "", config.DefaultLogFormat, "log format")
+	cmd.PersistentFlags().StringVarP(&cfg.LogLevel, "log-level", "", config.DefaultLogLevel, "log level")
+	cmd.Flags().BoolVarP(&cfg.DryRun, "dry-run", "", config.DefaultDryRun, "compute and log the changes without applying them to AWS SSO")
+	addSyncFlags(cmd, cfg)
+	addForceFlag(cmd, cfg)
+	addIncrementalFlags(cmd, cfg)
+}
+
+// addIncrementalFlags adds the flags syncing only the changes since the last
+// run, used by the commands running a sync.
+func addIncrementalFlags(cmd *cobra.Command, cfg *config.Config) {
+	cmd.Flags().StringVarP(&cfg.Incremental, "incremental", "", config.DefaultIncremental, "only sync the Google Workspace users and groups changed since the last sync, from the admin activities (none|poll|push), push only works with 'ssosync watch', NOTE: only works when --sync-method 'groups'")
+	cmd.Flags().DurationVarP(&cfg.FullSyncInterval, "full-sync-interval", "", config.DefaultFullSyncInterval, "run a full sync instead of an incremental one when the last one is older than this duration, 0 means only the first time")
+}
+
+// addForceFlag adds the flag to apply changes exceeding the deletion limits,
+// only used by the commands making changes in AWS SSO.
+func addForceFlag(cmd *cobra.Command, cfg *config.Config) {
+	cmd.Flags().BoolVarP(&cfg.Force, "force", "", false, "apply the changes even when --max-deletions or --max-deletions-percent are exceeded")
+}
+
+// addSyncFlags adds the flags needed to connect to Google Workspace and AWS SSO
+// and to select what is synced, these are shared by every command that runs a sync.
+func addSyncFlags(cmd *cobra.Command, cfg *config.Config) {
+	cmd.Flags().StringVarP(&cfg.SCIMAccessToken, "access-token", "t", "", "AWS SSO SCIM API Access Token")
+	cmd.Flags().StringVarP(&cfg.SCIMEndpoint, "endpoint", "e", "", "AWS SSO SCIM API Endpoint")
+	cmd.Flags().IntVarP(&cfg.Concurrency, "concurrency", "", config.DefaultConcurrency, "number of AWS SSO and Google Workspace calls made at the same time")
+	cmd.Flags().DurationVarP(&cfg.Timeout, "timeout", "", config.DefaultTimeout, "cancel the sync after this duration, e.g. 10m, 0 means no timeout")
+	cmd.Flags().IntVarP(&cfg.SCIMPageSize, "page-size", "", config.DefaultSCIMPageSize, "number of users or groups requested in each page when listing them from AWS SSO")
+	cmd.Flags().Float64VarP(&cfg.SCIMRequestsPerSecond, "requests-per-second", "", config.DefaultSCIMRequestsPerSecond, "maximum number of requests per second sent to AWS SSO, 0 means no limit")
+	cmd.Flags().IntVarP(&cfg.SCIMBurst, "burst", "", config.DefaultSCIMBurst, "number of requests sent at once to AWS SSO before --requests-per-second applies")
+	cmd.Flags().StringVarP(&cfg.GoogleCredentials, "google-credentials", "c", config.DefaultGoogleCredentials, "path to Google Workspace credentials file")
+	cmd.Flags().StringVarP(&cfg.GoogleAdmin, "google-admin", "u", "", "Google Workspace admin user email")
+	cmd.Flags().StringSliceVar(&cfg.IgnoreUsers, "ignore-users", []string{}, "ignores these Google Workspace users, as emails, globs like '*@example.com' or regular expressions like '/^admin-.*/'")
+	cmd.Flags().StringSliceVar(&cfg.IgnoreGroups, "ignore-groups", []string{}, "ignores these Google Workspace groups, as emails, globs or regular expressions")
+	cmd.Flags().StringSliceVar(&cfg.IncludeGroups, "include-groups", []string{}, "include only these Google Workspace groups, as emails, globs or regular expressions, NOTE: only works when --sync-method 'users_groups'")
+	cmd.Flags().StringSliceVar(&cfg.ProtectedUsers, "protected-users", []string{}, "never delete, deactivate or remove from groups these AWS SSO users, as emails, globs or regular expressions")
+	cmd.Flags().StringVar(&cfg.UserFilter, "user-filter", "", "only sync the google users selected by this expression, e.g. \"orgUnitPath.startsWith('/Engineering')\"")
+	cmd.Flags().StringVar(&cfg.GroupFilter, "group-filter", "", "only sync the google groups selected by this expression, e.g. \"directMembersCount < 500\"")
+	cmd.Flags().StringSliceVar(&cfg.ProtectedGroups, "protected-groups", []string{}, "never delete these AWS SSO groups, as names, globs or regular expressions")
+	cmd.Flags().StringSliceVar(&cfg.UserAttributes, "user-attributes", []string{}, "SCIM attributes synced from the Google Workspace users, as attribute or attribute=source, example: 'title,manager,department=organizations[work].department'")
+	cmd.Flags().StringVarP(&cfg.UserMatch, "user-match", "m", "", "Google Workspace Users filter query parameter, example: 'name:John* email:admin*', see: https://developers.google.com/admin-sdk/directory/v1/guides/search-users")
+	cmd.Flags().StringSliceVarP(&cfg.GroupMatch, "group-match", "g", []string{""}, "Google Workspace Groups filter query parameter, example: 'name:Admin* email:aws-*', see: https://developers.google.com/admin-sdk/directory/v1/guides/search-groups (You can specify this flag multiple times for OR clause)")
+	cmd.Flags().StringVarP(&cfg.GroupNameTemplate, "group-name-template", "", "", "template of the AWS SSO group names, example: 'aws-{{ .EmailLocalPart | lower }}', the Google Workspace group name or email is used by default, see --sync-method")
+	cmd.Flags().StringSliceVar(&cfg.GroupNameRewrites, "group-name-rewrites", []string{}, "regexp=replacement rewrites applied in order to the AWS SSO group names, example: '^aws-=,-admins$=-Admins', ' and ' is then replaced by ' & ' as AWS SSO can't parse it")
+	cmd.Flags().StringVarP(&cfg.SyncMethod, "sync-method", "s", config.DefaultSyncMethod, "Sync method to use (users_groups|groups|orgunits)")
+	cmd.Flags().StringVarP(&cfg.NestedGroups, "nested-groups", "", config.DefaultNestedGroups, "how the groups nested in the Google Workspace groups are synced (flatten|mirror), flatten adds their users to the groups they are nested in, mirror syncs them as AWS SSO groups of their own")
+	cmd.Flags().StringVarP(&cfg.Prefetch, "prefetch", "", config.DefaultPrefetch, "list the Google Workspace users, or all the users, groups and group members, once instead of requesting the members of the groups one by one (none|users|all)")
+	cmd.Flags().StringSliceVar(&cfg.OrgUnits, "org-units", []string{}, "paths of the Google Workspace org units synced, example: '/Engineering,/Sales', NOTE: only works when --sync-method 'orgunits'")
+	cmd.Flags().BoolVarP(&cfg.OrgUnitsRecursive, "org-units-recursive", "", config.DefaultOrgUnitsRecursive, "also sync the org units below --org-units")
+	cmd.Flags().BoolVarP(&cfg.OrgUnitGroups, "org-unit-groups", "", false, "create an AWS SSO group per org unit with its users as members")
+	cmd.Flags().StringVarP(&cfg.DatastoreType, "datastore-type", "D", config.DefaultDatastoreType, "Datastore type")
+	cmd.Flags().StringVarP(&cfg.DatastorePrefix, "datastore-prefix", "p", config.DefaultDatastorePrefix, "Datastore prefix or bucket")
+	cmd.Flags().StringVarP(&cfg.DatastoreUserObj, "datastore-user-obj", "", config.DefaultDatastoreUserObj, "Datastore object name for storing users")
+	cmd.Flags().StringVarP(&cfg.DatastoreGroupObj, "datastore-group-obj", "", config.DefaultDatastoreGroupObj, "Datastore object name for storing groups")
+	cmd.Flags().StringVarP(&cfg.DatastoreGroupIDObj, "datastore-group-id-obj", "", config.DefaultDatastoreGroupIDObj, "Datastore object name for storing the AWS ids of the Google groups")
+	cmd.Flags().StringVarP(&cfg.DatastoreStateObj, "datastore-state-obj", "", config.DefaultDatastoreStateObj, "Datastore object name for storing the state kept between syncs, like the incremental checkpoint")
+	cmd.Flags().StringVarP(&cfg.DeletedUsers, "deleted-users", "", config.DefaultDeletedUsers, "what happens to the AWS SSO users of the Google Workspace users deleted (delete|deactivate|keep)")
+	cmd.Flags().StringVarP(&cfg.SuspendedUsers, "suspended-users", "", config.DefaultSuspendedUsers, "what happens to the AWS SSO users of the Google Workspace users suspended (delete|deactivate|keep)")
+	cmd.Flags().StringVarP(&cfg.ArchivedUsers, "archived-users", "", config.DefaultArchivedUsers, "what happens to the AWS SSO users of the Google Workspace users archived (delete|deactivate|keep)")
+	cmd.Flags().StringVarP(&cfg.RemovedUsers, "removed-users", "", config.DefaultRemovedUsers, "what happens to the AWS SSO users of the Google Workspace users not in any group or org unit synced anymore (delete|deactivate|keep), NOTE: only works when --sync-method 'groups' or 'orgunits'")
+	cmd.Flags().DurationVarP(&cfg.DeletionGracePeriod, "deletion-grace-period", "", config.DefaultDeletionGracePeriod, "keep the AWS SSO users to delete for this duration after they are first found to delete, e.g. 72h, 0 deletes them right away, with --deprovision-action deactivate the users deactivated are deleted after this duration, 0 never deletes them")
+	cmd.Flags().StringVarP(&cfg.DeprovisionAction, "deprovision-action", "", config.DefaultDeprovisionAction, "what is done with the AWS SSO users to delete (delete|deactivate), deactivate sets them inactive and deletes them once --deletion-grace-period elapsed")
+	cmd.Flags().BoolVarP(&cfg.ManageUnowned, "manage-unowned", "", false, "also delete and remove from groups the AWS SSO users and groups not created by ssosync, and take over the ones matched by email or name")
+	cmd.Flags().IntVarP(&cfg.MaxDeletions, "max-deletions", "", config.DefaultMaxDeletions, "abort the sync when more than this number of users, groups or group members would be deleted, 0 means no limit")
+	cmd.Flags().IntVarP(&cfg.MaxDeletionsPercent, "max-deletions-percent", "", config.DefaultMaxDeletionsPercent, "abort the sync when more than this percentage of the existing users, groups or group members would be deleted, 0 means no limit")
 }
 
 func logConfig(cfg *config.Config) {
@@ -0,0 +1,44 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/awslabs/ssosync/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Keep syncing the changes made in Google Workspace to AWS SSO",
+	Long: `Runs until interrupted and syncs the Google Workspace users and groups
+changed since the last sync every --incremental-interval, as found in the
+admin activities of the Reports API.
+
+With --incremental poll the activities are listed at each sync, with
+--incremental push Google Workspace posts them to --notification-address as
+they happen. A full sync is run every --full-sync-interval.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// --timeout bounds a single run, the watch only stops on a signal
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return internal.DoWatch(ctx, cfg)
+	},
+}
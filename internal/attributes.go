@@ -0,0 +1,409 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// ErrUserAttribute is returned when an entry of --user-attributes is invalid
+var ErrUserAttribute = errors.New("invalid user attribute")
+
+// userAttribute is a SCIM attribute of users that can be synced from google
+type userAttribute struct {
+	// source is the path of the attribute in the google user used when
+	// none is given, empty when google has no field for it
+	source string
+	set    func(u *aws.User, v interface{})
+	get    func(u *aws.User) interface{}
+}
+
+// scimUserAttributes are the SCIM attributes that can be synced, by name
+var scimUserAttributes = map[string]userAttribute{
+	"displayName":       coreAttribute("name.fullName", func(u *aws.User) *string { return &u.DisplayName }),
+	"nickName":          coreAttribute("", func(u *aws.User) *string { return &u.NickName }),
+	"title":             coreAttribute("organizations.title", func(u *aws.User) *string { return &u.Title }),
+	"preferredLanguage": coreAttribute("languages.languageCode", func(u *aws.User) *string { return &u.PreferredLanguage }),
+	"locale":            coreAttribute("languages.languageCode", func(u *aws.User) *string { return &u.Locale }),
+	"timezone":          coreAttribute("", func(u *aws.User) *string { return &u.Timezone }),
+	"phoneNumbers":      {source: "phones", set: setPhoneNumber, get: getPhoneNumbers},
+	"addresses":         {source: "addresses", set: setAddress, get: getAddresses},
+	"employeeNumber":    enterpriseAttribute("externalIds[organization].value", func(e *aws.EnterpriseUser) *string { return &e.EmployeeNumber }),
+	"costCenter":        enterpriseAttribute("organizations.costCenter", func(e *aws.EnterpriseUser) *string { return &e.CostCenter }),
+	"organization":      enterpriseAttribute("organizations.name", func(e *aws.EnterpriseUser) *string { return &e.Organization }),
+	"division":          enterpriseAttribute("", func(e *aws.EnterpriseUser) *string { return &e.Division }),
+	"department":        enterpriseAttribute("organizations.department", func(e *aws.EnterpriseUser) *string { return &e.Department }),
+	"manager":           {source: "relations[manager].value", set: setManager, get: getManager},
+}
+
+// userMapping lists the SCIM attributes synced from google users and
+// where each one is read from, it is empty unless --user-attributes is set
+type userMapping []mappedAttribute
+
+// mappedAttribute is a SCIM attribute read from a path of the google user
+type mappedAttribute struct {
+	name string
+	path []pathElement
+	userAttribute
+}
+
+// pathElement is a field of a source path, with the type of the element
+// used when the field is a list
+type pathElement struct {
+	key      string
+	selector string
+}
+
+// parseUserMapping parses the entries of --user-attributes, either the name
+// of a SCIM attribute read from its default source or attribute=source
+func parseUserMapping(entries []string) (userMapping, error) {
+	var m userMapping
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		name, source := entry, ""
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, source = entry[:i], strings.TrimSpace(entry[i+1:])
+		}
+		name = strings.TrimSpace(name)
+
+		a, ok := scimUserAttributes[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown attribute %q", ErrUserAttribute, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: %s is mapped more than once", ErrUserAttribute, name)
+		}
+		seen[name] = true
+
+		if source == "" {
+			source = a.source
+		}
+		if source == "" {
+			return nil, fmt.Errorf("%w: %s has no default source, use %s=<source>", ErrUserAttribute, name, name)
+		}
+
+		path, err := parseSourcePath(source)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrUserAttribute, name, err)
+		}
+		m = append(m, mappedAttribute{name: name, path: path, userAttribute: a})
+	}
+	return m, nil
+}
+
+// parseSourcePath parses a path of the google user like name.fullName or
+// relations[manager].value
+func parseSourcePath(source string) ([]pathElement, error) {
+	var path []pathElement
+	for _, part := range strings.Split(source, ".") {
+		e := pathElement{key: part}
+		if i := strings.Index(part, "["); i >= 0 {
+			if !strings.HasSuffix(part, "]") {
+				return nil, fmt.Errorf("invalid source %q", source)
+			}
+			e.key, e.selector = part[:i], part[i+1:len(part)-1]
+		}
+		if e.key == "" {
+			return nil, fmt.Errorf("invalid source %q", source)
+		}
+		path = append(path, e)
+	}
+	return path, nil
+}
+
+// customSchemas returns the google custom schemas the mapped attributes
+// are read from, they must be requested with the users
+func (m userMapping) customSchemas() []string {
+	var schemas []string
+	seen := make(map[string]bool)
+	for _, a := range m {
+		if len(a.path) < 2 || a.path[0].key != "customSchemas" || seen[a.path[1].key] {
+			continue
+		}
+		seen[a.path[1].key] = true
+		schemas = append(schemas, a.path[1].key)
+	}
+	sort.Strings(schemas)
+	return schemas
+}
+
+// apply sets the mapped attributes of the AWS user from the google user
+func (m userMapping) apply(awsUser *aws.User, u *admin.User) {
+	if len(m) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(u)
+	if err != nil {
+		log.WithField("email", u.PrimaryEmail).WithError(err).Warn("cannot read the attributes of the google user")
+		return
+	}
+	var values interface{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		log.WithField("email", u.PrimaryEmail).WithError(err).Warn("cannot read the attributes of the google user")
+		return
+	}
+
+	for _, a := range m {
+		a.set(awsUser, lookup(values, a.path))
+	}
+}
+
+// changed reports whether any mapped attribute of the AWS user differs
+// from the one of the desired user
+func (m userMapping) changed(awsUser *aws.User, desired *aws.User) bool {
+	for _, a := range m {
+		if !reflect.DeepEqual(a.get(awsUser), a.get(desired)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveManager replaces the email of the manager of the user, as read
+// from google, by the id of its AWS user. The manager is dropped when it
+// has no AWS user yet, it is set by the next sync.
+func resolveManager(u *aws.User, managerID func(email string) (string, error)) error {
+	if u.Enterprise == nil || u.Enterprise.Manager == nil {
+		return nil
+	}
+
+	id, err := managerID(u.Enterprise.Manager.Value)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		log.WithFields(log.Fields{
+			"email":   u.Username,
+			"manager": u.Enterprise.Manager.Value,
+		}).Debug("manager not found in aws, skipping it")
+		u.Enterprise.Manager = nil
+		return nil
+	}
+	u.Enterprise.Manager.Value = id
+	return nil
+}
+
+// lookup returns the value at path in v, the decoded JSON of a google user.
+// When a field is a list the element of the type selected is used, or
+// without selector the primary element, lists ending the path are
+// returned whole.
+func lookup(v interface{}, path []pathElement) interface{} {
+	for i, e := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[e.key]
+		if l, ok := v.([]interface{}); ok && (e.selector != "" || i < len(path)-1) {
+			v = selectElement(l, e.selector)
+		}
+	}
+	return v
+}
+
+// selectElement returns the element of the list whose type or custom type
+// is the selector, without selector it is the primary element or else the
+// first one
+func selectElement(l []interface{}, selector string) interface{} {
+	if selector != "" {
+		for _, el := range l {
+			if m, ok := el.(map[string]interface{}); ok && (m["type"] == selector || m["customType"] == selector) {
+				return el
+			}
+		}
+		return nil
+	}
+
+	for _, el := range l {
+		if m, ok := el.(map[string]interface{}); ok && m["primary"] == true {
+			return el
+		}
+	}
+	if len(l) > 0 {
+		return l[0]
+	}
+	return nil
+}
+
+// stringValue returns the value read from google as a string, from lists
+// the primary element is used and from objects their value field
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		return stringValue(selectElement(v, ""))
+	case map[string]interface{}:
+		return stringValue(v["value"])
+	}
+	return ""
+}
+
+// objectValue returns the value read from google as an object, from lists
+// the primary element is used
+func objectValue(v interface{}) map[string]interface{} {
+	if l, ok := v.([]interface{}); ok {
+		v = selectElement(l, "")
+	}
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// coreAttribute returns a string attribute of the core user schema, empty
+// values are not sent so they are removed from the AWS user
+func coreAttribute(source string, field func(u *aws.User) *string) userAttribute {
+	return userAttribute{
+		source: source,
+		set: func(u *aws.User, v interface{}) {
+			if s := stringValue(v); s != "" {
+				*field(u) = s
+			}
+		},
+		get: func(u *aws.User) interface{} {
+			return *field(u)
+		},
+	}
+}
+
+// enterpriseAttribute returns a string attribute of the enterprise
+// extension, the extension is only added to users with a value
+func enterpriseAttribute(source string, field func(e *aws.EnterpriseUser) *string) userAttribute {
+	return userAttribute{
+		source: source,
+		set: func(u *aws.User, v interface{}) {
+			if s := stringValue(v); s != "" {
+				*field(u.EnterpriseExtension()) = s
+			}
+		},
+		get: func(u *aws.User) interface{} {
+			if u.Enterprise == nil {
+				return ""
+			}
+			return *field(u.Enterprise)
+		},
+	}
+}
+
+// setManager keeps the email of the manager read from google, it is
+// replaced by the id of its AWS user with resolveManager
+func setManager(u *aws.User, v interface{}) {
+	if s := stringValue(v); s != "" {
+		u.EnterpriseExtension().Manager = &aws.UserManager{Value: s}
+	}
+}
+
+func getManager(u *aws.User) interface{} {
+	if u.Enterprise == nil || u.Enterprise.Manager == nil {
+		return ""
+	}
+	return u.Enterprise.Manager.Value
+}
+
+// phoneTypes maps the types of google phones to the SCIM ones, other types
+// are sent as other
+var phoneTypes = map[string]string{
+	"work":        "work",
+	"home":        "home",
+	"mobile":      "mobile",
+	"work_mobile": "mobile",
+	"home_fax":    "fax",
+	"work_fax":    "fax",
+	"other_fax":   "fax",
+	"pager":       "pager",
+	"work_pager":  "pager",
+}
+
+// setPhoneNumber sets the primary google phone of the user, AWS SSO keeps
+// a single phone number per user
+func setPhoneNumber(u *aws.User, v interface{}) {
+	phone := objectValue(v)
+	number := stringValue(phone["value"])
+	if number == "" {
+		return
+	}
+
+	t, ok := phoneTypes[stringValue(phone["type"])]
+	if !ok {
+		t = "other"
+	}
+	u.PhoneNumbers = []aws.UserPhoneNumber{{Value: number, Type: t, Primary: true}}
+}
+
+// getPhoneNumbers returns the phone numbers without their primary flag,
+// which AWS SSO does not always return
+func getPhoneNumbers(u *aws.User) interface{} {
+	var phones []aws.UserPhoneNumber
+	for _, p := range u.PhoneNumbers {
+		p.Primary = false
+		phones = append(phones, p)
+	}
+	return phones
+}
+
+// setAddress sets the primary google address of the user, AWS SSO keeps
+// a single address per user
+func setAddress(u *aws.User, v interface{}) {
+	address := objectValue(v)
+	a := aws.UserAddress{
+		Type:          stringValue(address["type"]),
+		Formatted:     stringValue(address["formatted"]),
+		StreetAddress: stringValue(address["streetAddress"]),
+		Locality:      stringValue(address["locality"]),
+		Region:        stringValue(address["region"]),
+		PostalCode:    stringValue(address["postalCode"]),
+		Country:       stringValue(address["countryCode"]),
+		Primary:       true,
+	}
+	if a.Type != "home" && a.Type != "other" {
+		a.Type = "work"
+	}
+	if a == (aws.UserAddress{Type: a.Type, Primary: true}) {
+		return
+	}
+	u.Addresses = []aws.UserAddress{a}
+}
+
+// getAddresses returns the addresses without their primary flag, leaving
+// out the empty work address every user is created with
+func getAddresses(u *aws.User) interface{} {
+	var addresses []aws.UserAddress
+	for _, a := range u.Addresses {
+		a.Primary = false
+		if a == (aws.UserAddress{Type: a.Type}) {
+			continue
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// newAttributesUser returns a google user with every attribute mapped by default
+func newAttributesUser() *admin.User {
+	return &admin.User{
+		Id:           "guser-1",
+		PrimaryEmail: "user-1@email.com",
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1", FullName: "Name One"},
+		Organizations: []interface{}{
+			map[string]interface{}{"name": "Old Corp", "title": "Intern"},
+			map[string]interface{}{"name": "Corp", "title": "Engineer", "department": "R&D", "costCenter": "42", "primary": true},
+		},
+		Phones: []interface{}{
+			map[string]interface{}{"value": "+1 555 0100", "type": "work_mobile"},
+		},
+		Addresses: []interface{}{
+			map[string]interface{}{"type": "work", "streetAddress": "1 Main St", "locality": "Springfield", "postalCode": "12345", "countryCode": "US"},
+		},
+		Languages: []interface{}{
+			map[string]interface{}{"languageCode": "en-GB"},
+		},
+		ExternalIds: []interface{}{
+			map[string]interface{}{"value": "E-1", "type": "organization"},
+		},
+		Relations: []interface{}{
+			map[string]interface{}{"value": "boss@email.com", "type": "manager"},
+		},
+	}
+}
+
+func TestParseUserMapping(t *testing.T) {
+	m, err := parseUserMapping([]string{"title", "nickName=name.givenName", " ", "department = organizations[work].department"})
+	assert.NoError(t, err)
+	assert.Len(t, m, 3)
+	assert.Equal(t, []pathElement{{key: "organizations", selector: "work"}, {key: "department"}}, m[2].path)
+
+	m, err = parseUserMapping(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, m)
+
+	for _, entries := range [][]string{
+		{"unknown"},
+		{"title", "title"},
+		{"timezone"},
+		{"title=organizations[work.title"},
+		{"title=organizations..title"},
+	} {
+		_, err := parseUserMapping(entries)
+		assert.True(t, errors.Is(err, ErrUserAttribute), "%v", entries)
+	}
+}
+
+func TestUserMapping_Apply(t *testing.T) {
+	var entries []string
+	for name, a := range scimUserAttributes {
+		if a.source != "" {
+			entries = append(entries, name)
+		}
+	}
+	m, err := parseUserMapping(append(entries, "timezone=customSchemas.Location.tz"))
+	assert.NoError(t, err)
+
+	u := newAttributesUser()
+	awsUser := newAWSUser(u)
+	m.apply(awsUser, u)
+
+	assert.Equal(t, "Name One", awsUser.DisplayName)
+	assert.Equal(t, "Engineer", awsUser.Title)
+	assert.Equal(t, "en-GB", awsUser.PreferredLanguage)
+	assert.Equal(t, "en-GB", awsUser.Locale)
+	assert.Empty(t, awsUser.Timezone)
+	assert.Equal(t, []aws.UserPhoneNumber{{Value: "+1 555 0100", Type: "mobile", Primary: true}}, awsUser.PhoneNumbers)
+	assert.Equal(t, []aws.UserAddress{{Type: "work", StreetAddress: "1 Main St", Locality: "Springfield", PostalCode: "12345", Country: "US", Primary: true}}, awsUser.Addresses)
+	assert.Equal(t, &aws.EnterpriseUser{
+		EmployeeNumber: "E-1",
+		CostCenter:     "42",
+		Organization:   "Corp",
+		Department:     "R&D",
+		Manager:        &aws.UserManager{Value: "boss@email.com"},
+	}, awsUser.Enterprise)
+	assert.Contains(t, awsUser.Schemas, aws.EnterpriseUserSchema)
+
+	// without values the user keeps the attributes it is created with
+	empty := &admin.User{PrimaryEmail: "user-2@email.com", Name: &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"}}
+	awsUser = newAWSUser(empty)
+	m.apply(awsUser, empty)
+	assert.Equal(t, newAWSUser(empty), awsUser)
+}
+
+func TestResolveManager(t *testing.T) {
+	ids := func(email string) (string, error) {
+		if email == "boss@email.com" {
+			return "aws-boss", nil
+		}
+		return "", nil
+	}
+
+	u := aws.NewUser("name-1", "lastname-1", "user-1@email.com", true)
+	u.EnterpriseExtension().Manager = &aws.UserManager{Value: "boss@email.com"}
+	assert.NoError(t, resolveManager(u, ids))
+	assert.Equal(t, "aws-boss", u.Enterprise.Manager.Value)
+
+	u.Enterprise.Manager = &aws.UserManager{Value: "unknown@email.com"}
+	assert.NoError(t, resolveManager(u, ids))
+	assert.Nil(t, u.Enterprise.Manager)
+
+	errFind := errors.New("find failed")
+	u.Enterprise.Manager = &aws.UserManager{Value: "boss@email.com"}
+	assert.Equal(t, errFind, resolveManager(u, func(string) (string, error) { return "", errFind }))
+}
+
+func TestGetUserOperationsAttributes(t *testing.T) {
+	m, err := parseUserMapping([]string{"title", "phoneNumbers", "addresses", "department", "manager"})
+	assert.NoError(t, err)
+
+	u := newAttributesUser()
+	boss := aws.NewUser("boss", "lastname", "boss@email.com", true)
+	boss.ID = "aws-boss"
+
+	// the AWS user as returned by AWS SSO once synced, without primary flags
+	synced := updatedAWSUser("aws-1", u)
+	m.apply(synced, u)
+	synced.Enterprise.Manager.Value = "aws-boss"
+	synced.PhoneNumbers[0].Primary = false
+	synced.Addresses[0].Primary = false
+
+	_, _, update, equals := getUserOperations([]*aws.User{synced, boss}, []*admin.User{u}, m)
+	assert.Empty(t, update)
+	assert.Equal(t, []*aws.User{synced}, equals)
+
+	// a change of any mapped attribute updates the user
+	u.Organizations.([]interface{})[1].(map[string]interface{})["department"] = "Sales"
+	_, _, update, equals = getUserOperations([]*aws.User{synced, boss}, []*admin.User{u}, m)
+	assert.Empty(t, equals)
+	if assert.Len(t, update, 1) {
+		assert.Equal(t, "Sales", update[0].Enterprise.Department)
+		assert.Equal(t, "aws-boss", update[0].Enterprise.Manager.Value)
+	}
+
+	// attributes not mapped are not compared
+	_, _, update, _ = getUserOperations([]*aws.User{synced, boss}, []*admin.User{u}, nil)
+	assert.Empty(t, update)
+}
+
+func TestUserMapping_CustomSchemas(t *testing.T) {
+	m, err := parseUserMapping([]string{
+		"title",
+		"costCenter=customSchemas.Employment.costCenter",
+		"division=customSchemas.Employment.projects[work]",
+		"employeeNumber=customSchemas.HR.id",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Employment", "HR"}, m.customSchemas())
+
+	u := newAttributesUser()
+	u.CustomSchemas = map[string]googleapi.RawMessage{
+		"Employment": googleapi.RawMessage(`{"costCenter":"CC-7","projects":[{"type":"home","value":"Garden"},{"type":"work","value":"Gnomes"}]}`),
+		"HR":         googleapi.RawMessage(`{"id":1234}`),
+	}
+	awsUser := newAWSUser(u)
+	m.apply(awsUser, u)
+	assert.Equal(t, &aws.EnterpriseUser{CostCenter: "CC-7", Division: "Gnomes", EmployeeNumber: "1234"}, awsUser.Enterprise)
+
+	// the custom schema fields are compared like any other attribute
+	synced := updatedAWSUser("aws-1", u)
+	m.apply(synced, u)
+	_, _, update, _ := getUserOperations([]*aws.User{synced}, []*admin.User{u}, m)
+	assert.Empty(t, update)
+
+	u.CustomSchemas["Employment"] = googleapi.RawMessage(`{"costCenter":"CC-8"}`)
+	_, _, update, _ = getUserOperations([]*aws.User{synced}, []*admin.User{u}, m)
+	if assert.Len(t, update, 1) {
+		assert.Equal(t, &aws.EnterpriseUser{CostCenter: "CC-8", EmployeeNumber: "1234"}, update[0].Enterprise)
+	}
+
+	m, _ = parseUserMapping([]string{"title"})
+	assert.Empty(t, m.customSchemas())
+}
@@ -16,6 +16,7 @@ package aws
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,6 +24,8 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/awslabs/ssosync/internal/datastore"
 
@@ -35,6 +38,9 @@ var (
 	ErrNoGroupsFound     = errors.New("no groups found")
 	ErrUserNotSpecified  = errors.New("user not specified")
 	ErrGroupNotSpecified = errors.New("group not specified")
+	// ErrMembersNotListed is returned when the endpoint does not return the
+	// members of a group, the membership has to be checked with IsUserInGroup
+	ErrMembersNotListed = errors.New("group members not listed")
 )
 
 // OperationType handle patch operations for add/remove
@@ -46,31 +52,45 @@ const (
 
 	// OperationRemove is the remove operation for a patch
 	OperationRemove = "remove"
+
+	// OperationReplace is the replace operation for a patch
+	OperationReplace = "replace"
 )
 
 // Client represents an interface of methods used
 // to communicate with AWS SSO
 type Client interface {
-	AddUserToGroup(*User, *Group) error
-	CreateGroup(*Group) (*Group, error)
-	CreateUser(*User) (*User, error)
-	DeleteGroup(*Group) error
-	DeleteUser(*User) error
-	FindGroupByDisplayName(string) (*Group, error)
-	FindUserByEmail(string) (*User, error)
-	FindUserByID(string) (*User, error)
-	GetUsers() ([]*User, error)
-	GetGroupMembers(*Group) ([]*User, error)
-	IsUserInGroup(*User, *Group) (bool, error)
-	GetGroups() ([]*Group, error)
-	UpdateUser(*User) (*User, error)
-	RemoveUserFromGroup(*User, *Group) error
+	AddUserToGroup(context.Context, *User, *Group) error
+	AddUsersToGroup(context.Context, []*User, *Group) error
+	CreateGroup(context.Context, *Group) (*Group, error)
+	CreateUser(context.Context, *User) (*User, error)
+	DeleteGroup(context.Context, *Group) error
+	DeleteUser(context.Context, *User) error
+	FindGroupByDisplayName(context.Context, string) (*Group, error)
+	FindGroupByExternalID(context.Context, string) (*Group, error)
+	FindGroupByID(context.Context, string) (*Group, error)
+	FindUserByEmail(context.Context, string) (*User, error)
+	FindUserByExternalID(context.Context, string) (*User, error)
+	FindUserByID(context.Context, string) (*User, error)
+	GetUsers(context.Context) ([]*User, error)
+	GetGroupMembers(context.Context, *Group) ([]*User, error)
+	GetGroupMemberIDs(context.Context, *Group) ([]string, error)
+	IsUserInGroup(context.Context, *User, *Group) (bool, error)
+	GetGroups(context.Context) ([]*Group, error)
+	UpdateGroup(context.Context, *Group) (*Group, error)
+	UpdateUser(context.Context, *User) (*User, error)
+	RemoveUserFromGroup(context.Context, *User, *Group) error
+	RemoveUsersFromGroup(context.Context, []*User, *Group) error
 }
 
 type client struct {
 	httpClient  HttpClient
 	endpointURL *url.URL
 	bearerToken string
+	pageSize    int
+	maxMembers  int
+	maxRetries  int
+	limiter     *rateLimiter
 	datastore   datastore.Datastore
 }
 
@@ -82,87 +102,119 @@ func NewClient(c HttpClient, config *Config, ds datastore.Datastore) (Client, er
 	if err != nil {
 		return nil, err
 	}
+	pageSize := config.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	maxMembers := config.MembersPerRequest
+	if maxMembers <= 0 {
+		maxMembers = DefaultMembersPerRequest
+	}
 	return &client{
 		httpClient:  c,
 		endpointURL: u,
 		bearerToken: config.Token,
+		pageSize:    pageSize,
+		maxMembers:  maxMembers,
+		maxRetries:  DefaultMaxRetries,
+		limiter:     newRateLimiter(config.RequestsPerSecond, config.Burst),
 		datastore:   ds,
 	}, nil
 }
 
 // sendRequestWithBody will send the body given to the url/method combination
 // with the right Bearer token as well as the correct content type for SCIM.
-func (c *client) sendRequestWithBody(method string, url string, body interface{}) (response []byte, err error) {
+func (c *client) sendRequestWithBody(ctx context.Context, method string, url string, body interface{}) (response []byte, err error) {
 	// Convert the body to JSON
 	d, err := json.Marshal(body)
 	if err != nil {
 		return
 	}
 
-	// Create a request with our body of JSON
-	r, err := http.NewRequest(method, url, bytes.NewBuffer(d))
-	if err != nil {
-		return
-	}
-
 	log.WithFields(log.Fields{"url": url, "method": method})
 
-	// Set the content-type and authorization headers
-	r.Header.Set("Content-Type", "application/scim+json")
-	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.bearerToken))
+	return c.do(ctx, func() (*http.Request, error) {
+		// Create a request with our body of JSON
+		r, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(d))
+		if err != nil {
+			return nil, err
+		}
 
-	// Call the URL
-	resp, err := c.httpClient.Do(r)
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
+		// Set the content-type and authorization headers
+		r.Header.Set("Content-Type", "application/scim+json")
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.bearerToken))
+		return r, nil
+	})
+}
 
-	// Read the body back from the response
-	response, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
+func (c *client) sendRequest(ctx context.Context, method string, url string) (response []byte, err error) {
+	log := log.WithFields(log.Fields{"url": url, "method": method})
+
+	response, err = c.do(ctx, func() (*http.Request, error) {
+		r, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, err
+		}
 
-	// If we get a non-2xx status code, raise that via an error
-	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
-		err = fmt.Errorf("status of http response was %d", resp.StatusCode)
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.bearerToken))
+		return r, nil
+	})
+	if err != nil {
+		log.Error(err)
 	}
 
 	return
 }
 
-func (c *client) sendRequest(method string, url string) (response []byte, err error) {
-	r, err := http.NewRequest(method, url, nil)
-	if err != nil {
-		return
-	}
+// do sends the request built by newRequest once the rate limiter allows it.
+// A throttled request (429 or 503) is sent again after the delay asked by
+// its Retry-After header, every other request of the client waits as well.
+func (c *client) do(ctx context.Context, newRequest func() (*http.Request, error)) (response []byte, err error) {
+	for attempt := 0; ; attempt++ {
+		r, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
 
-	log := log.WithFields(log.Fields{"url": url, "method": method})
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
 
-	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.bearerToken))
+		resp, err := c.httpClient.Do(r)
+		if err != nil {
+			return nil, err
+		}
 
-	resp, err := c.httpClient.Do(r)
-	if err != nil {
-		return
-	}
+		response, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
 
-	defer resp.Body.Close()
-	response, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			if attempt < c.maxRetries {
+				delay, ok := retryAfter(resp, c.limiter.now())
+				if !ok {
+					// no Retry-After, back off exponentially from one second
+					delay = time.Second << attempt
+				}
+				log.WithFields(log.Fields{"url": r.URL.String(), "status": resp.StatusCode, "delay": delay}).Warn("request throttled, retrying")
+				c.limiter.pause(delay)
+				continue
+			}
+		}
 
-	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
-		log.Errorf("sendRequest recieved an %d status code", resp.StatusCode)
-		err = fmt.Errorf("status of http response was %d", resp.StatusCode)
-	}
+		// If we get a non-2xx status code, raise that via an error
+		if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
+			return response, newSCIMError(resp.StatusCode, response)
+		}
 
-	return
+		return response, nil
+	}
 }
 
 // IsUserInGroup will determine if user (u) is in group (g)
-func (c *client) IsUserInGroup(u *User, g *Group) (bool, error) {
+func (c *client) IsUserInGroup(ctx context.Context, u *User, g *Group) (bool, error) {
 	if g == nil {
 		return false, ErrGroupNotSpecified
 	}
@@ -183,7 +235,7 @@ func (c *client) IsUserInGroup(u *User, g *Group) (bool, error) {
 	q.Add("filter", filter)
 
 	startURL.RawQuery = q.Encode()
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
+	resp, err := c.sendRequest(ctx, http.MethodGet, startURL.String())
 	if err != nil {
 		log.WithFields(log.Fields{"user": u.Username, "group": g.DisplayName}).Error(string(resp))
 		return false, err
@@ -198,28 +250,60 @@ func (c *client) IsUserInGroup(u *User, g *Group) (bool, error) {
 	return r.TotalResults > 0, nil
 }
 
-func (c *client) groupChangeOperation(op OperationType, u *User, g *Group) error {
+// GetGroupMemberIDs returns the ids of the users that are members of
+// group (g), read from the members attribute of GET /Groups/{id}. It
+// returns ErrMembersNotListed when the endpoint leaves the attribute out.
+func (c *client) GetGroupMemberIDs(ctx context.Context, g *Group) ([]string, error) {
 	if g == nil {
-		return ErrGroupNotSpecified
+		return nil, ErrGroupNotSpecified
 	}
 
-	if u == nil {
-		return ErrUserNotSpecified
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return nil, err
 	}
 
-	log.WithFields(log.Fields{"operations": op, "user": u.Username, "group": g.DisplayName}).Debug("Group Change")
+	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Groups/%s", g.ID))
 
-	gc := &GroupMemberChange{
-		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
-		Operations: []GroupMemberChangeOperation{
-			{
-				Operation: string(op),
-				Path:      "members",
-				Members: []GroupMemberChangeMember{
-					{Value: u.ID},
-				},
-			},
-		},
+	resp, err := c.sendRequest(ctx, http.MethodGet, startURL.String())
+	if err != nil {
+		log.WithFields(log.Fields{"group": g.DisplayName}).Error(string(resp))
+		return nil, err
+	}
+
+	// members is a pointer to tell an empty group apart from
+	// an endpoint that does not return the attribute
+	var r struct {
+		Members *[]GroupMember `json:"members"`
+	}
+	err = json.Unmarshal(resp, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Members == nil {
+		return nil, ErrMembersNotListed
+	}
+
+	ids := make([]string, 0, len(*r.Members))
+	for _, m := range *r.Members {
+		ids = append(ids, m.Value)
+	}
+
+	return ids, nil
+}
+
+// groupChangeOperation adds or removes the users to or from the group, packing
+// as many of them in each PATCH request as the endpoint allows
+func (c *client) groupChangeOperation(ctx context.Context, op OperationType, users []*User, g *Group) error {
+	if g == nil {
+		return ErrGroupNotSpecified
+	}
+
+	for _, u := range users {
+		if u == nil {
+			return ErrUserNotSpecified
+		}
 	}
 
 	startURL, err := url.Parse(c.endpointURL.String())
@@ -229,34 +313,84 @@ func (c *client) groupChangeOperation(op OperationType, u *User, g *Group) error
 
 	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Groups/%s", g.ID))
 
-	resp, err := c.sendRequestWithBody(http.MethodPatch, startURL.String(), *gc)
-	if err != nil {
-		log.WithFields(log.Fields{"operations": op, "user": u.Username, "group": g.DisplayName}).Error(string(resp))
-		return err
+	for start := 0; start < len(users); start += c.maxMembers {
+		end := start + c.maxMembers
+		if end > len(users) {
+			end = len(users)
+		}
+
+		members := make([]GroupMemberChangeMember, 0, end-start)
+		for _, u := range users[start:end] {
+			members = append(members, GroupMemberChangeMember{Value: u.ID})
+		}
+
+		log := log.WithFields(log.Fields{"operations": op, "users": len(members), "group": g.DisplayName})
+		if len(members) == 1 {
+			log = log.WithField("user", users[start].Username)
+		}
+		log.Debug("Group Change")
+
+		gc := &GroupMemberChange{
+			Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+			Operations: []GroupMemberChangeOperation{
+				{
+					Operation: string(op),
+					Path:      "members",
+					Members:   members,
+				},
+			},
+		}
+
+		resp, err := c.sendRequestWithBody(ctx, http.MethodPatch, startURL.String(), *gc)
+		if err != nil {
+			log.Error(string(resp))
+			return err
+		}
+		log.Debug(string(resp))
 	}
-	log.WithFields(log.Fields{"operations": op, "user": u.Username, "group": g.DisplayName}).Debug(string(resp))
 
 	return nil
 }
 
 // AddUserToGroup will add the user specified to the group specified
-func (c *client) AddUserToGroup(u *User, g *Group) error {
-	return c.groupChangeOperation(OperationAdd, u, g)
+func (c *client) AddUserToGroup(ctx context.Context, u *User, g *Group) error {
+	return c.groupChangeOperation(ctx, OperationAdd, []*User{u}, g)
+}
+
+// AddUsersToGroup will add the users specified to the group specified
+func (c *client) AddUsersToGroup(ctx context.Context, users []*User, g *Group) error {
+	return c.groupChangeOperation(ctx, OperationAdd, users, g)
 }
 
 // RemoveUserFromGroup will remove the user specified from the group specified
-func (c *client) RemoveUserFromGroup(u *User, g *Group) error {
-	return c.groupChangeOperation(OperationRemove, u, g)
+func (c *client) RemoveUserFromGroup(ctx context.Context, u *User, g *Group) error {
+	return c.groupChangeOperation(ctx, OperationRemove, []*User{u}, g)
+}
+
+// RemoveUsersFromGroup will remove the users specified from the group specified
+func (c *client) RemoveUsersFromGroup(ctx context.Context, users []*User, g *Group) error {
+	return c.groupChangeOperation(ctx, OperationRemove, users, g)
 }
 
 // FindUserByEmail will find the user by the email address specified
-func (c *client) FindUserByEmail(email string) (*User, error) {
+func (c *client) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	return c.findUser(ctx, "userName", email)
+}
+
+// FindUserByExternalID will find the user by the external id specified,
+// the immutable id of the user in Google
+func (c *client) FindUserByExternalID(ctx context.Context, id string) (*User, error) {
+	return c.findUser(ctx, "externalId", id)
+}
+
+// findUser will find the user whose attribute equals value
+func (c *client) findUser(ctx context.Context, attribute string, value string) (*User, error) {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
 	}
 
-	filter := fmt.Sprintf("userName eq \"%s\"", email)
+	filter := fmt.Sprintf("%s eq \"%s\"", attribute, value)
 
 	startURL.Path = path.Join(startURL.Path, "/Users")
 	q := startURL.Query()
@@ -264,9 +398,9 @@ func (c *client) FindUserByEmail(email string) (*User, error) {
 
 	startURL.RawQuery = q.Encode()
 
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
+	resp, err := c.sendRequest(ctx, http.MethodGet, startURL.String())
 	if err != nil {
-		log.WithFields(log.Fields{"email": email}).Error(string(resp))
+		log.WithFields(log.Fields{attribute: value}).Error(string(resp))
 		return nil, err
 	}
 
@@ -283,8 +417,8 @@ func (c *client) FindUserByEmail(email string) (*User, error) {
 	return &r.Resources[0], nil
 }
 
-// FindUserByID will find the user by the email address specified
-func (c *client) FindUserByID(id string) (*User, error) {
+// FindUserByID will find the user by the id specified
+func (c *client) FindUserByID(ctx context.Context, id string) (*User, error) {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
@@ -292,33 +426,78 @@ func (c *client) FindUserByID(id string) (*User, error) {
 
 	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Users/%s", id))
 
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
+	resp, err := c.sendRequest(ctx, http.MethodGet, startURL.String())
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		log.WithFields(log.Fields{"id": id}).Error(string(resp))
 		return nil, err
 	}
 
-	var r UserFilterResults
-	err = json.Unmarshal(resp, &r)
+	var u User
+	err = json.Unmarshal(resp, &u)
 	if err != nil {
 		return nil, err
 	}
 
-	if r.TotalResults != 1 {
+	if u.ID == "" {
 		return nil, ErrUserNotFound
 	}
 
-	return &r.Resources[0], nil
+	return &u, nil
 }
 
 // FindGroupByDisplayName will find the group by its displayname.
-func (c *client) FindGroupByDisplayName(name string) (*Group, error) {
+func (c *client) FindGroupByDisplayName(ctx context.Context, name string) (*Group, error) {
+	return c.findGroup(ctx, "displayName", name)
+}
+
+// FindGroupByExternalID will find the group by its external id, the
+// immutable id of the group in Google
+func (c *client) FindGroupByExternalID(ctx context.Context, id string) (*Group, error) {
+	return c.findGroup(ctx, "externalId", id)
+}
+
+// FindGroupByID will find the group by the id specified
+func (c *client) FindGroupByID(ctx context.Context, id string) (*Group, error) {
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Groups/%s", id))
+
+	resp, err := c.sendRequest(ctx, http.MethodGet, startURL.String())
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"id": id}).Error(string(resp))
+		return nil, err
+	}
+
+	var g Group
+	err = json.Unmarshal(resp, &g)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.ID == "" {
+		return nil, ErrGroupNotFound
+	}
+
+	return &g, nil
+}
+
+// findGroup will find the group whose attribute equals value
+func (c *client) findGroup(ctx context.Context, attribute string, value string) (*Group, error) {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
 	}
 
-	filter := fmt.Sprintf("displayName eq \"%s\"", name)
+	filter := fmt.Sprintf("%s eq \"%s\"", attribute, value)
 
 	startURL.Path = path.Join(startURL.Path, "/Groups")
 	q := startURL.Query()
@@ -326,9 +505,9 @@ func (c *client) FindGroupByDisplayName(name string) (*Group, error) {
 
 	startURL.RawQuery = q.Encode()
 
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
+	resp, err := c.sendRequest(ctx, http.MethodGet, startURL.String())
 	if err != nil {
-		log.WithFields(log.Fields{"name": name}).Error(string(resp))
+		log.WithFields(log.Fields{attribute: value}).Error(string(resp))
 		return nil, err
 	}
 
@@ -346,7 +525,7 @@ func (c *client) FindGroupByDisplayName(name string) (*Group, error) {
 }
 
 // CreateUser will create the user specified
-func (c *client) CreateUser(u *User) (*User, error) {
+func (c *client) CreateUser(ctx context.Context, u *User) (*User, error) {
 	//
 	// add the user and save the list
 	// we do this first so that if there is a failure but the user
@@ -375,7 +554,7 @@ func (c *client) CreateUser(u *User) (*User, error) {
 	}
 
 	startURL.Path = path.Join(startURL.Path, "/Users")
-	resp, err := c.sendRequestWithBody(http.MethodPost, startURL.String(), *u)
+	resp, err := c.sendRequestWithBody(ctx, http.MethodPost, startURL.String(), *u)
 	if err != nil {
 		log.WithFields(log.Fields{"user": u.Username}).Error(string(resp))
 		return nil, err
@@ -387,14 +566,14 @@ func (c *client) CreateUser(u *User) (*User, error) {
 		return nil, err
 	}
 	if newUser.ID == "" {
-		return c.FindUserByEmail(u.Username)
+		return c.FindUserByEmail(ctx, u.Username)
 	}
 
 	return &newUser, nil
 }
 
 // UpdateUser will update/replace the user specified
-func (c *client) UpdateUser(u *User) (*User, error) {
+func (c *client) UpdateUser(ctx context.Context, u *User) (*User, error) {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
@@ -406,7 +585,7 @@ func (c *client) UpdateUser(u *User) (*User, error) {
 	}
 
 	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Users/%s", u.ID))
-	resp, err := c.sendRequestWithBody(http.MethodPut, startURL.String(), *u)
+	resp, err := c.sendRequestWithBody(ctx, http.MethodPut, startURL.String(), *u)
 	if err != nil {
 		log.WithFields(log.Fields{"user": u.Username}).Error(string(resp))
 		return nil, err
@@ -418,14 +597,14 @@ func (c *client) UpdateUser(u *User) (*User, error) {
 		return nil, err
 	}
 	if newUser.ID == "" {
-		return c.FindUserByEmail(u.Username)
+		return c.FindUserByEmail(ctx, u.Username)
 	}
 
 	return &newUser, nil
 }
 
 // DeleteUser will remove the current user from the directory
-func (c *client) DeleteUser(u *User) error {
+func (c *client) DeleteUser(ctx context.Context, u *User) error {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return err
@@ -436,7 +615,7 @@ func (c *client) DeleteUser(u *User) error {
 	}
 
 	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Users/%s", u.ID))
-	resp, err := c.sendRequest(http.MethodDelete, startURL.String())
+	resp, err := c.sendRequest(ctx, http.MethodDelete, startURL.String())
 	if err != nil {
 		log.WithFields(log.Fields{"user": u.Username}).Error(string(resp))
 		return err
@@ -460,7 +639,7 @@ func (c *client) DeleteUser(u *User) error {
 }
 
 // CreateGroup will create a group given
-func (c *client) CreateGroup(g *Group) (*Group, error) {
+func (c *client) CreateGroup(ctx context.Context, g *Group) (*Group, error) {
 	//
 	// add the group and save the list
 	// we do this first so that if there is a failure but the group
@@ -489,7 +668,7 @@ func (c *client) CreateGroup(g *Group) (*Group, error) {
 	}
 
 	startURL.Path = path.Join(startURL.Path, "/Groups")
-	resp, err := c.sendRequestWithBody(http.MethodPost, startURL.String(), *g)
+	resp, err := c.sendRequestWithBody(ctx, http.MethodPost, startURL.String(), *g)
 	if err != nil {
 		log.WithFields(log.Fields{"group": g.DisplayName}).Error(string(resp))
 		return nil, err
@@ -504,8 +683,55 @@ func (c *client) CreateGroup(g *Group) (*Group, error) {
 	return &newGroup, nil
 }
 
+// UpdateGroup will replace the display name and the external id of the
+// group specified, its members are left as they are
+func (c *client) UpdateGroup(ctx context.Context, g *Group) (*Group, error) {
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if g == nil {
+		return nil, ErrGroupNotSpecified
+	}
+
+	operations := []PatchOperation{
+		{Operation: OperationReplace, Path: "displayName", Value: g.DisplayName},
+	}
+	if g.ExternalID != "" {
+		operations = append(operations, PatchOperation{Operation: OperationReplace, Path: "externalId", Value: g.ExternalID})
+	}
+
+	patch := &Patch{
+		Schemas:    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: operations,
+	}
+
+	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Groups/%s", g.ID))
+	resp, err := c.sendRequestWithBody(ctx, http.MethodPatch, startURL.String(), *patch)
+	if err != nil {
+		log.WithFields(log.Fields{"group": g.DisplayName}).Error(string(resp))
+		return nil, err
+	}
+
+	// the old name is removed from the datastore by the next GetGroups
+	err = c.datastore.AddGroup(g.DisplayName)
+	if err != nil {
+		log.WithFields(log.Fields{"group": g.DisplayName}).Errorf("UpdateGroup failed to add group to datastore: %s", err)
+		return nil, err
+	}
+	err = c.datastore.Store()
+	if err != nil {
+		log.WithFields(log.Fields{"group": g.DisplayName}).Errorf("UpdateGroup failed to persist datastore: %s", err)
+		return nil, err
+	}
+
+	updated := *g
+	return &updated, nil
+}
+
 // DeleteGroup will delete the group specified
-func (c *client) DeleteGroup(g *Group) error {
+func (c *client) DeleteGroup(ctx context.Context, g *Group) error {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return err
@@ -516,7 +742,7 @@ func (c *client) DeleteGroup(g *Group) error {
 	}
 
 	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Groups/%s", g.ID))
-	_, err = c.sendRequest(http.MethodDelete, startURL.String())
+	_, err = c.sendRequest(ctx, http.MethodDelete, startURL.String())
 	if err != nil {
 		return err
 	}
@@ -535,72 +761,45 @@ func (c *client) DeleteGroup(g *Group) error {
 	return nil
 }
 
-// GetGroups will return existing groups
-func (c *client) GetGroups() ([]*Group, error) {
-	// we have to use an external datastore to track users and groups because the aws api
-	// will only return the first 50 users and has no pagination options!
-	groupNames, err := c.datastore.GetGroups()
-	if err != nil {
-		return nil, err
-	}
-
-	knownGroupNames := make(map[string]bool)
+// GetGroups will return existing groups, reading every page of the
+// SCIM listing. The datastore only keeps the groups created by ssosync,
+// the ones that don't exist anymore are removed from it.
+func (c *client) GetGroups(ctx context.Context) ([]*Group, error) {
+	groups := make([]*Group, 0)
 
-	// fetch each group from AWS skipping any groups that do not exist
-	groups := make([]*Group, 0, len(groupNames))
-	for _, name := range groupNames {
-		log := log.WithFields(log.Fields{"group": name})
-		log.Info("checking if group exists in AWS")
-		group, err := c.FindGroupByDisplayName(name)
-		if err == ErrGroupNotFound {
-			err = c.datastore.DeleteGroup(name)
-			if err != nil {
-				log.Warning("GetGroups failed to remove group from datastore")
-			} else {
-				log.Info("GetGroups removed non-existent group from list")
-			}
-			continue
-		}
-		if err != nil {
-			log.WithError(err).Error("GetGroups failed to find group!")
-			return nil, err
+	err := c.listResources(ctx, "/Groups", func(resource json.RawMessage) error {
+		var g Group
+		if err := json.Unmarshal(resource, &g); err != nil {
+			return err
 		}
-		groups = append(groups, group)
-		knownGroupNames[group.DisplayName] = true
-	}
-
-	startURL, err := url.Parse(c.endpointURL.String())
+		groups = append(groups, &g)
+		return nil
+	})
 	if err != nil {
+		log.WithError(err).Error("Failed to get groups from AWS")
 		return nil, err
 	}
 
-	startURL.Path = path.Join(startURL.Path, "/Groups")
-
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
-	if err != nil {
-		log.Error(string(resp))
-		return nil, err
+	knownGroupNames := make(map[string]bool)
+	for _, group := range groups {
+		knownGroupNames[group.DisplayName] = true
 	}
 
-	var r GroupFilterResults
-	err = json.Unmarshal(resp, &r)
+	// remove the groups that do not exist anymore from the datastore
+	groupNames, err := c.datastore.GetGroups()
 	if err != nil {
 		return nil, err
 	}
-
-	for i := range r.Resources {
-		group := &r.Resources[i]
-		log := log.WithFields(log.Fields{"group": group.DisplayName})
-
-		if ok1, ok2 := knownGroupNames[group.DisplayName]; !ok1 || !ok2 {
-			groups = append(groups, group)
-			knownGroupNames[group.DisplayName] = true
-			err = c.datastore.AddGroup(group.DisplayName)
-			if err != nil {
-				log.Warning("GetGroups failed to add group to datastore")
-			} else {
-				log.Info("GetGroups added pre-existent group to datastore")
-			}
+	for _, name := range groupNames {
+		if knownGroupNames[name] {
+			continue
+		}
+		log := log.WithFields(log.Fields{"group": name})
+		err = c.datastore.DeleteGroup(name)
+		if err != nil {
+			log.Warning("GetGroups failed to remove group from datastore")
+		} else {
+			log.Info("GetGroups removed non-existent group from list")
 		}
 	}
 
@@ -608,7 +807,7 @@ func (c *client) GetGroups() ([]*Group, error) {
 }
 
 // GetGroupMembers will return existing groups
-func (c *client) GetGroupMembers(g *Group) ([]*User, error) {
+func (c *client) GetGroupMembers(ctx context.Context, g *Group) ([]*User, error) {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
@@ -626,7 +825,7 @@ func (c *client) GetGroupMembers(g *Group) ([]*User, error) {
 
 	startURL.RawQuery = q.Encode()
 
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
+	resp, err := c.sendRequest(ctx, http.MethodGet, startURL.String())
 	if err != nil {
 		log.WithFields(log.Fields{"group": g.DisplayName}).Error(string(resp))
 		return nil, err
@@ -640,9 +839,9 @@ func (c *client) GetGroupMembers(g *Group) ([]*User, error) {
 
 	var users = make([]*User, 0)
 	for _, res := range r.Resources {
-		for _, uID := range res.Members { // NOTE: Not Implemented Yet https://docs.aws.amazon.com/singlesignon/latest/developerguide/listgroups.html
+		for _, m := range res.Members { // NOTE: Not Implemented Yet https://docs.aws.amazon.com/singlesignon/latest/developerguide/listgroups.html
 
-			user, err := c.FindUserByID(uID)
+			user, err := c.FindUserByID(ctx, m.Value)
 			if err != nil {
 				return nil, err
 			}
@@ -653,73 +852,114 @@ func (c *client) GetGroupMembers(g *Group) ([]*User, error) {
 	return users, nil
 }
 
-// GetUsers will return existing users
-func (c *client) GetUsers() ([]*User, error) {
-	// we have to use an external datastore to track users and groups because the aws api
-	// will only return the first 50 users and has no pagination options!
-	userNames, err := c.datastore.GetUsers()
+// GetUsers will return existing users, reading every page of the
+// SCIM listing. The datastore only keeps the users created by ssosync,
+// the ones that don't exist anymore are removed from it.
+func (c *client) GetUsers(ctx context.Context) ([]*User, error) {
+	users := make([]*User, 0)
+
+	err := c.listResources(ctx, "/Users", func(resource json.RawMessage) error {
+		var u User
+		if err := json.Unmarshal(resource, &u); err != nil {
+			return err
+		}
+		users = append(users, &u)
+		return nil
+	})
 	if err != nil {
+		log.WithError(err).Error("Failed to get users from AWS")
 		return nil, err
 	}
 
 	knownUserNames := make(map[string]bool)
+	for _, user := range users {
+		knownUserNames[user.Username] = true
+	}
 
-	// fetch each user from AWS skipping any users that do not exist
-	users := make([]*User, 0, len(userNames))
+	// remove the users that do not exist anymore from the datastore
+	userNames, err := c.datastore.GetUsers()
+	if err != nil {
+		return nil, err
+	}
 	for _, name := range userNames {
-		userLog := log.WithFields(log.Fields{"user": name})
-		userLog.Info("Checking if user exists in AWS")
-		user, err := c.FindUserByEmail(name)
-		if err == ErrUserNotFound {
-			err = c.datastore.DeleteUser(name)
-			if err != nil {
-				userLog.Error("Failed to remove user from datastore")
-			} else {
-				userLog.Info("Removed non-existent user from list")
-			}
+		if knownUserNames[name] {
 			continue
 		}
+		userLog := log.WithFields(log.Fields{"user": name})
+		err = c.datastore.DeleteUser(name)
 		if err != nil {
-			userLog.WithError(err).Error("Failed to look up user on AWS")
-			return nil, err
+			userLog.Error("Failed to remove user from datastore")
+		} else {
+			userLog.Info("Removed non-existent user from list")
 		}
-		users = append(users, user)
-		knownUserNames[user.Username] = true
 	}
 
+	return users, nil
+}
+
+// listResources reads every page of a SCIM listing, following startIndex
+// until totalResults resources are read. add is called with each resource,
+// once per id: a page without new resources ends the listing, so an
+// endpoint ignoring startIndex can't loop forever or repeat resources.
+func (c *client) listResources(ctx context.Context, resource string, add func(json.RawMessage) error) error {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
-		return nil, err
+		return err
 	}
-	startURL.Path = path.Join(startURL.Path, "/Users")
+	startURL.Path = path.Join(startURL.Path, resource)
 
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
-	if err != nil {
-		log.WithError(err).Error("Failed to get users from AWS")
-		return nil, err
-	}
+	seen := make(map[string]struct{})
+	read := 0
+	startIndex := 1
+	for {
+		q := startURL.Query()
+		q.Set("startIndex", strconv.Itoa(startIndex))
+		q.Set("count", strconv.Itoa(c.pageSize))
+		startURL.RawQuery = q.Encode()
 
-	var r UserFilterResults
-	err = json.Unmarshal(resp, &r)
-	if err != nil {
-		return nil, err
-	}
+		log.WithFields(log.Fields{"resource": resource, "startIndex": startIndex}).Debug("listing page")
+		resp, err := c.sendRequest(ctx, http.MethodGet, startURL.String())
+		if err != nil {
+			log.Error(string(resp))
+			return err
+		}
 
-	for i := range r.Resources {
-		user := &r.Resources[i]
-		userLog := log.WithFields(log.Fields{"user": user.Username})
+		var r listResponse
+		err = json.Unmarshal(resp, &r)
+		if err != nil {
+			return err
+		}
 
-		if ok1, ok2 := knownUserNames[user.Username]; !ok1 || !ok2 {
-			users = append(users, user)
-			knownUserNames[user.Username] = true
-			err = c.datastore.AddUser(user.Username)
-			if err != nil {
-				userLog.Warning("GetUsers failed to add user to datastore")
-			} else {
-				userLog.Info("GetUsers added pre-existent user to datastore")
+		added := 0
+		for _, raw := range r.Resources {
+			var id struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(raw, &id); err != nil {
+				return err
+			}
+			if _, ok := seen[id.ID]; ok {
+				continue
+			}
+			seen[id.ID] = struct{}{}
+			added++
+			if err := add(raw); err != nil {
+				return err
 			}
 		}
-	}
 
-	return users, nil
+		itemsPerPage := r.ItemsPerPage
+		if itemsPerPage == 0 {
+			itemsPerPage = len(r.Resources)
+		}
+
+		read += itemsPerPage
+		if added == 0 && itemsPerPage > 0 {
+			log.WithFields(log.Fields{"resource": resource, "startIndex": startIndex}).Warn("the page only repeats resources already listed, stopping the listing")
+		}
+		if added == 0 || read >= r.TotalResults {
+			return nil
+		}
+		startIndex += itemsPerPage
+	}
 }
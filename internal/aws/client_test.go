@@ -16,6 +16,7 @@ package aws
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -96,7 +97,7 @@ func TestSendRequestBadUrl(t *testing.T) {
 	assert.NoError(t, err)
 	cc := c.(*client)
 
-	r, err := cc.sendRequest(http.MethodGet, ":foo")
+	r, err := cc.sendRequest(context.Background(), http.MethodGet, ":foo")
 	assert.Error(t, err)
 	assert.Nil(t, r)
 }
@@ -127,7 +128,7 @@ func TestSendRequestBadStatusCode(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	_, err = cc.sendRequest(http.MethodGet, "https://scim.example.com/")
+	_, err = cc.sendRequest(context.Background(), http.MethodGet, "https://scim.example.com/")
 	assert.Error(t, err)
 }
 
@@ -162,7 +163,7 @@ func TestSendRequestCheckAuthHeader(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	_, err = cc.sendRequest(http.MethodGet, "https://scim.example.com/")
+	_, err = cc.sendRequest(context.Background(), http.MethodGet, "https://scim.example.com/")
 	assert.NoError(t, err)
 }
 
@@ -199,7 +200,7 @@ func TestSendRequestWithBodyCheckHeaders(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	_, err = cc.sendRequestWithBody(http.MethodPost, "https://scim.example.com/", &User{})
+	_, err = cc.sendRequestWithBody(context.Background(), http.MethodPost, "https://scim.example.com/", &User{})
 	assert.NoError(t, err)
 }
 
@@ -223,12 +224,12 @@ func TestClient_IsUserInGroup(t *testing.T) {
 	}
 
 	// Test nil User
-	v, err := c.IsUserInGroup(nil, testGroup)
+	v, err := c.IsUserInGroup(context.Background(), nil, testGroup)
 	assert.False(t, v)
 	assert.Error(t, err)
 
 	// Test nil Group
-	v, err = c.IsUserInGroup(testUser, nil)
+	v, err = c.IsUserInGroup(context.Background(), testUser, nil)
 	assert.False(t, v)
 	assert.Error(t, err)
 
@@ -254,7 +255,7 @@ func TestClient_IsUserInGroup(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	v, err = c.IsUserInGroup(testUser, testGroup)
+	v, err = c.IsUserInGroup(context.Background(), testUser, testGroup)
 	assert.False(t, v)
 	assert.Error(t, err)
 
@@ -270,7 +271,7 @@ func TestClient_IsUserInGroup(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(falseResult)},
 	}, nil)
 
-	v, err = c.IsUserInGroup(testUser, testGroup)
+	v, err = c.IsUserInGroup(context.Background(), testUser, testGroup)
 	assert.False(t, v)
 	assert.NoError(t, err)
 
@@ -286,11 +287,74 @@ func TestClient_IsUserInGroup(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(trueResult)},
 	}, nil)
 
-	v, err = c.IsUserInGroup(testUser, testGroup)
+	v, err = c.IsUserInGroup(context.Background(), testUser, testGroup)
 	assert.True(t, v)
 	assert.NoError(t, err)
 }
 
+func TestClient_GetGroupMemberIDs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	}, datastore.NewNullDatastore())
+	assert.NoError(t, err)
+
+	// Test nil Group
+	_, err = c.GetGroupMemberIDs(context.Background(), nil)
+	assert.Error(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Groups/groupId")
+
+	req := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    calledURL,
+			Method: http.MethodGet,
+		},
+	}
+
+	testGroup := &Group{
+		ID:          "groupId",
+		DisplayName: "test-group",
+	}
+
+	// Members listed
+	x.EXPECT().Do(&req).MaxTimes(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBufferString(`{"id":"groupId","displayName":"test-group","members":[{"value":"user-1"},{"value":"user-2","display":"user-2@email.com"}]}`)},
+	}, nil)
+
+	ids, err := c.GetGroupMemberIDs(context.Background(), testGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user-1", "user-2"}, ids)
+
+	// Empty group
+	x.EXPECT().Do(&req).MaxTimes(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBufferString(`{"id":"groupId","displayName":"test-group","members":[]}`)},
+	}, nil)
+
+	ids, err = c.GetGroupMemberIDs(context.Background(), testGroup)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+
+	// Members not returned
+	x.EXPECT().Do(&req).MaxTimes(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBufferString(`{"id":"groupId","displayName":"test-group"}`)},
+	}, nil)
+
+	_, err = c.GetGroupMemberIDs(context.Background(), testGroup)
+	assert.ErrorIs(t, err, ErrMembersNotListed)
+}
+
 func TestClient_FindUserByEmail(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -326,7 +390,7 @@ func TestClient_FindUserByEmail(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	u, err := c.FindUserByEmail("test@example.com")
+	u, err := c.FindUserByEmail(context.Background(), "test@example.com")
 	assert.Nil(t, u)
 	assert.Error(t, err)
 
@@ -342,7 +406,7 @@ func TestClient_FindUserByEmail(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(falseResult)},
 	}, nil)
 
-	u, err = c.FindUserByEmail("test@example.com")
+	u, err = c.FindUserByEmail(context.Background(), "test@example.com")
 	assert.Nil(t, u)
 	assert.Error(t, err)
 
@@ -362,7 +426,7 @@ func TestClient_FindUserByEmail(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(trueResult)},
 	}, nil)
 
-	u, err = c.FindUserByEmail("test@example.com")
+	u, err = c.FindUserByEmail(context.Background(), "test@example.com")
 	assert.NotNil(t, u)
 	assert.NoError(t, err)
 }
@@ -402,7 +466,7 @@ func TestClient_FindGroupByDisplayName(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	u, err := c.FindGroupByDisplayName("testGroup")
+	u, err := c.FindGroupByDisplayName(context.Background(), "testGroup")
 	assert.Nil(t, u)
 	assert.Error(t, err)
 
@@ -418,7 +482,7 @@ func TestClient_FindGroupByDisplayName(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(falseResult)},
 	}, nil)
 
-	u, err = c.FindGroupByDisplayName("testGroup")
+	u, err = c.FindGroupByDisplayName(context.Background(), "testGroup")
 	assert.Nil(t, u)
 	assert.Error(t, err)
 
@@ -438,7 +502,7 @@ func TestClient_FindGroupByDisplayName(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(trueResult)},
 	}, nil)
 
-	u, err = c.FindGroupByDisplayName("testGroup")
+	u, err = c.FindGroupByDisplayName(context.Background(), "testGroup")
 	assert.NotNil(t, u)
 	assert.NoError(t, err)
 }
@@ -474,11 +538,11 @@ func TestClient_DeleteGroup(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	err = c.DeleteGroup(g)
+	err = c.DeleteGroup(context.Background(), g)
 	assert.NoError(t, err)
 
 	// Test no group specified
-	err = c.DeleteGroup(nil)
+	err = c.DeleteGroup(context.Background(), nil)
 	assert.Error(t, err)
 }
 
@@ -513,11 +577,11 @@ func TestClient_DeleteUser(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	err = c.DeleteUser(u)
+	err = c.DeleteUser(context.Background(), u)
 	assert.NoError(t, err)
 
 	// Test no group specified
-	err = c.DeleteUser(nil)
+	err = c.DeleteUser(context.Background(), nil)
 	assert.Error(t, err)
 }
 
@@ -557,7 +621,7 @@ func TestClient_CreateUser(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(response)},
 	}, nil)
 
-	r, err := c.CreateUser(nu)
+	r, err := c.CreateUser(context.Background(), nu)
 	assert.NotNil(t, r)
 	assert.NoError(t, err)
 
@@ -602,7 +666,7 @@ func TestClient_UpdateUser(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(response)},
 	}, nil)
 
-	r, err := c.UpdateUser(nu)
+	r, err := c.UpdateUser(context.Background(), nu)
 	assert.NotNil(t, r)
 	assert.NoError(t, err)
 
@@ -647,7 +711,7 @@ func TestClient_CreateGroup(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(response)},
 	}, nil)
 
-	r, err := c.CreateGroup(ng)
+	r, err := c.CreateGroup(context.Background(), ng)
 	assert.NotNil(t, r)
 	assert.NoError(t, err)
 
@@ -656,6 +720,135 @@ func TestClient_CreateGroup(t *testing.T) {
 	}
 }
 
+func TestClient_UpdateGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	}, datastore.NewNullDatastore())
+	assert.NoError(t, err)
+
+	g := NewGroup("Renamed")
+	g.ID = "groupId"
+	g.ExternalID = "google-id"
+
+	calledURL, _ := url.Parse("https://scim.example.com/Groups/groupId")
+
+	req := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    calledURL,
+			Method: http.MethodPatch,
+		},
+		body: "{\"schemas\":[\"urn:ietf:params:scim:api:messages:2.0:PatchOp\"],\"Operations\":[{\"op\":\"replace\",\"path\":\"displayName\",\"value\":\"Renamed\"},{\"op\":\"replace\",\"path\":\"externalId\",\"value\":\"google-id\"}]}",
+	}
+
+	x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+		Status:     "No Content",
+		StatusCode: 204,
+		Body:       nopCloser{bytes.NewBufferString("")},
+	}, nil)
+
+	updated, err := c.UpdateGroup(context.Background(), g)
+	assert.NoError(t, err)
+	assert.Equal(t, g, updated)
+
+	_, err = c.UpdateGroup(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestClient_FindGroupByID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	}, datastore.NewNullDatastore())
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Groups/groupId")
+
+	req := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    calledURL,
+			Method: http.MethodGet,
+		},
+	}
+
+	x.EXPECT().Do(&req).MaxTimes(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBufferString("{\"id\":\"groupId\",\"displayName\":\"Group\"}")},
+	}, nil)
+
+	g, err := c.FindGroupByID(context.Background(), "groupId")
+	assert.NoError(t, err)
+	assert.Equal(t, "Group", g.DisplayName)
+
+	x.EXPECT().Do(&req).MaxTimes(1).Return(&http.Response{
+		Status:     "Not Found",
+		StatusCode: 404,
+		Body:       nopCloser{bytes.NewBufferString("")},
+	}, nil)
+
+	g, err = c.FindGroupByID(context.Background(), "groupId")
+	assert.Nil(t, g)
+	assert.Equal(t, ErrGroupNotFound, err)
+}
+
+func TestClient_FindUserByExternalID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	}, datastore.NewNullDatastore())
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Users")
+
+	q := calledURL.Query()
+	q.Add("filter", "externalId eq \"google-id\"")
+
+	calledURL.RawQuery = q.Encode()
+
+	req := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    calledURL,
+			Method: http.MethodGet,
+		},
+	}
+
+	r := &UserFilterResults{
+		TotalResults: 1,
+		Resources: []User{
+			{
+				ExternalID: "google-id",
+				Username:   "test@example.com",
+			},
+		},
+	}
+	result, _ := json.Marshal(r)
+	x.EXPECT().Do(&req).MaxTimes(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBuffer(result)},
+	}, nil)
+
+	u, err := c.FindUserByExternalID(context.Background(), "google-id")
+	assert.NoError(t, err)
+	assert.Equal(t, "test@example.com", u.Username)
+}
+
 func TestClient_AddUserToGroup(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -692,13 +885,68 @@ func TestClient_AddUserToGroup(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	err = c.AddUserToGroup(u, g)
+	err = c.AddUserToGroup(context.Background(), u, g)
+	assert.NoError(t, err)
+
+	err = c.RemoveUserFromGroup(context.Background(), nil, g)
+	assert.Error(t, err)
+
+	err = c.RemoveUserFromGroup(context.Background(), u, nil)
+	assert.Error(t, err)
+}
+
+func TestClient_AddUsersToGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint:          "https://scim.example.com/",
+		Token:             "bearerToken",
+		MembersPerRequest: 2,
+	}, datastore.NewNullDatastore())
 	assert.NoError(t, err)
 
-	err = c.RemoveUserFromGroup(nil, g)
+	g := &Group{
+		ID: "groupId",
+	}
+
+	users := []*User{{ID: "user-1"}, {ID: "user-2"}, {ID: "user-3"}}
+
+	calledURL, _ := url.Parse("https://scim.example.com/Groups/groupId")
+
+	// the members are sent in chunks of MembersPerRequest
+	for _, body := range []string{
+		"{\"schemas\":[\"urn:ietf:params:scim:api:messages:2.0:PatchOp\"],\"Operations\":[{\"op\":\"add\",\"path\":\"members\",\"value\":[{\"value\":\"user-1\"},{\"value\":\"user-2\"}]}]}",
+		"{\"schemas\":[\"urn:ietf:params:scim:api:messages:2.0:PatchOp\"],\"Operations\":[{\"op\":\"add\",\"path\":\"members\",\"value\":[{\"value\":\"user-3\"}]}]}",
+	} {
+		req := httpReqMatcher{
+			httpReq: &http.Request{
+				URL:    calledURL,
+				Method: http.MethodPatch,
+			},
+			body: body,
+		}
+
+		x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+			Status:     "OK",
+			StatusCode: 200,
+			Body:       nopCloser{bytes.NewBufferString("")},
+		}, nil)
+	}
+
+	err = c.AddUsersToGroup(context.Background(), users, g)
+	assert.NoError(t, err)
+
+	// nothing to send
+	err = c.RemoveUsersFromGroup(context.Background(), []*User{}, g)
+	assert.NoError(t, err)
+
+	err = c.RemoveUsersFromGroup(context.Background(), []*User{users[0], nil}, g)
 	assert.Error(t, err)
 
-	err = c.RemoveUserFromGroup(u, nil)
+	err = c.AddUsersToGroup(context.Background(), users, nil)
 	assert.Error(t, err)
 }
 
@@ -738,12 +986,151 @@ func TestClient_RemoveUserFromGroup(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	err = c.RemoveUserFromGroup(u, g)
+	err = c.RemoveUserFromGroup(context.Background(), u, g)
 	assert.NoError(t, err)
 
-	err = c.RemoveUserFromGroup(nil, g)
+	err = c.RemoveUserFromGroup(context.Background(), nil, g)
 	assert.Error(t, err)
 
-	err = c.RemoveUserFromGroup(u, nil)
+	err = c.RemoveUserFromGroup(context.Background(), u, nil)
 	assert.Error(t, err)
 }
+
+func TestClient_GetUsers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	ds := datastore.NewNullDatastore()
+	assert.NoError(t, ds.AddUser("deleted@example.com"))
+	assert.NoError(t, ds.AddUser("user-1@example.com"))
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+		PageSize: 2,
+	}, ds)
+	assert.NoError(t, err)
+
+	pages := []UserFilterResults{
+		{
+			TotalResults: 3,
+			ItemsPerPage: 2,
+			StartIndex:   1,
+			Resources:    []User{{ID: "1", Username: "user-1@example.com"}, {ID: "2", Username: "user-2@example.com"}},
+		},
+		{
+			TotalResults: 3,
+			ItemsPerPage: 1,
+			StartIndex:   3,
+			Resources:    []User{{ID: "3", Username: "user-3@example.com"}},
+		},
+	}
+
+	for _, page := range pages {
+		calledURL, _ := url.Parse(fmt.Sprintf("https://scim.example.com/Users?count=2&startIndex=%d", page.StartIndex))
+		req := httpReqMatcher{
+			httpReq: &http.Request{
+				URL:    calledURL,
+				Method: http.MethodGet,
+			},
+		}
+
+		response, _ := json.Marshal(page)
+		x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+			Status:     "OK",
+			StatusCode: 200,
+			Body:       nopCloser{bytes.NewBuffer(response)},
+		}, nil)
+	}
+
+	users, err := c.GetUsers(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, users, 3)
+
+	names, err := ds.GetUsers()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"user-1@example.com"}, names)
+}
+
+func TestClient_GetUsersIgnoredStartIndex(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+		PageSize: 2,
+	}, datastore.NewNullDatastore())
+	assert.NoError(t, err)
+
+	// the endpoint returns the first page whatever the startIndex, the
+	// second request only repeats it and ends the listing
+	response, _ := json.Marshal(&UserFilterResults{
+		TotalResults: 5,
+		ItemsPerPage: 2,
+		StartIndex:   1,
+		Resources:    []User{{ID: "1", Username: "user-1@example.com"}, {ID: "2", Username: "user-2@example.com"}},
+	})
+	x.EXPECT().Do(gomock.Any()).Times(2).DoAndReturn(func(*http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     "OK",
+			StatusCode: 200,
+			Body:       nopCloser{bytes.NewBuffer(response)},
+		}, nil
+	})
+
+	users, err := c.GetUsers(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, "1", users[0].ID)
+		assert.Equal(t, "2", users[1].ID)
+	}
+}
+
+func TestClient_GetGroups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	ds := datastore.NewNullDatastore()
+	assert.NoError(t, ds.AddGroup("deleted"))
+	assert.NoError(t, ds.AddGroup("group-1"))
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	}, ds)
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse(fmt.Sprintf("https://scim.example.com/Groups?count=%d&startIndex=1", DefaultPageSize))
+	req := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    calledURL,
+			Method: http.MethodGet,
+		},
+	}
+
+	// an endpoint without itemsPerPage, the number of resources is used
+	response, _ := json.Marshal(&GroupFilterResults{
+		TotalResults: 1,
+		Resources:    []Group{{ID: "1", DisplayName: "group-1"}},
+	})
+	x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBuffer(response)},
+	}, nil)
+
+	groups, err := c.GetGroups(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+
+	names, err := ds.GetGroups()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group-1"}, names)
+}
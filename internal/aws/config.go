@@ -16,10 +16,29 @@ package aws
 
 import "github.com/BurntSushi/toml"
 
+// DefaultPageSize is the number of users or groups requested in each page
+// when listing them
+const DefaultPageSize = 50
+
+// DefaultMembersPerRequest is the number of members added to or removed
+// from a group in a single PATCH request
+const DefaultMembersPerRequest = 100
+
+// DefaultMaxRetries is the number of times a throttled request is sent again
+const DefaultMaxRetries = 5
+
 // Config specifes the configuration needed for AWS SSO SCIM
 type Config struct {
 	Endpoint string
 	Token    string
+	PageSize int
+
+	MembersPerRequest int
+
+	// RequestsPerSecond and Burst limit the requests sent to the endpoint,
+	// a RequestsPerSecond of 0 does not limit them
+	RequestsPerSecond float64
+	Burst             int
 }
 
 // ReadConfigFromFile will read a TOML file into the Config Struct
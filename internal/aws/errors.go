@@ -0,0 +1,110 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errorSchema is the schema of the SCIM error responses
+const errorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+var (
+	// ErrNotFound is wrapped by a SCIMError when the resource does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is wrapped by a SCIMError when the resource already exists
+	ErrConflict = errors.New("conflict")
+	// ErrThrottled is wrapped by a SCIMError when there are too many requests
+	ErrThrottled = errors.New("throttled")
+	// ErrServer is wrapped by a SCIMError when the endpoint failed or is unavailable
+	ErrServer = errors.New("server error")
+)
+
+// SCIMError is a failed response of the SCIM endpoint, use errors.Is with
+// ErrNotFound, ErrConflict, ErrThrottled or ErrServer to tell them apart
+type SCIMError struct {
+	// Status is the http status code of the response
+	Status int
+	// ScimType is the SCIM detail error keyword, e.g. uniqueness
+	ScimType string
+	// Detail is the human readable message of the endpoint
+	Detail string
+}
+
+// newSCIMError builds the error of a response with the given status code,
+// the body is read as a SCIM error when it has the error schema
+func newSCIMError(status int, body []byte) *SCIMError {
+	e := &SCIMError{Status: status}
+
+	var r struct {
+		Schemas  []string        `json:"schemas"`
+		Status   json.RawMessage `json:"status"`
+		ScimType string          `json:"scimType"`
+		Detail   string          `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return e
+	}
+
+	isError := false
+	for _, s := range r.Schemas {
+		if s == errorSchema {
+			isError = true
+		}
+	}
+	if !isError {
+		return e
+	}
+
+	// the status is a string in the RFC, but some endpoints send a number
+	if s, err := strconv.Atoi(strings.Trim(string(r.Status), `"`)); err == nil && s != 0 {
+		e.Status = s
+	}
+	e.ScimType = r.ScimType
+	e.Detail = r.Detail
+
+	return e
+}
+
+func (e *SCIMError) Error() string {
+	msg := fmt.Sprintf("status of http response was %d", e.Status)
+	if e.ScimType != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.ScimType)
+	}
+	if e.Detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Detail)
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error matching the status
+func (e *SCIMError) Unwrap() error {
+	switch {
+	case e.Status == http.StatusNotFound:
+		return ErrNotFound
+	case e.Status == http.StatusConflict:
+		return ErrConflict
+	case e.Status == http.StatusTooManyRequests:
+		return ErrThrottled
+	case e.Status >= http.StatusInternalServerError:
+		return ErrServer
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSCIMError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    *SCIMError
+		wantMsg string
+		wantErr error
+	}{
+		{
+			name:    "conflict",
+			status:  409,
+			body:    `{"schemas":["urn:ietf:params:scim:api:messages:2.0:Error"],"status":"409","scimType":"uniqueness","detail":"Duplicate userName"}`,
+			want:    &SCIMError{Status: 409, ScimType: "uniqueness", Detail: "Duplicate userName"},
+			wantMsg: "status of http response was 409 (uniqueness): Duplicate userName",
+			wantErr: ErrConflict,
+		},
+		{
+			name:    "numeric status",
+			status:  404,
+			body:    `{"schemas":["urn:ietf:params:scim:api:messages:2.0:Error"],"status":404,"detail":"Resource not found"}`,
+			want:    &SCIMError{Status: 404, Detail: "Resource not found"},
+			wantMsg: "status of http response was 404: Resource not found",
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "throttled",
+			status:  429,
+			body:    `{"schemas":["urn:ietf:params:scim:api:messages:2.0:Error"],"status":"429","detail":"Rate exceeded"}`,
+			want:    &SCIMError{Status: 429, Detail: "Rate exceeded"},
+			wantMsg: "status of http response was 429: Rate exceeded",
+			wantErr: ErrThrottled,
+		},
+		{
+			name:    "not a scim error",
+			status:  502,
+			body:    `<html>Bad Gateway</html>`,
+			want:    &SCIMError{Status: 502},
+			wantMsg: "status of http response was 502",
+			wantErr: ErrServer,
+		},
+		{
+			name:    "other schema",
+			status:  400,
+			body:    `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"detail":"ignored"}`,
+			want:    &SCIMError{Status: 400},
+			wantMsg: "status of http response was 400",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newSCIMError(tt.status, []byte(tt.body))
+			assert.Equal(t, tt.want, got)
+			assert.EqualError(t, got, tt.wantMsg)
+
+			var err error = got
+			for _, sentinel := range []error{ErrNotFound, ErrConflict, ErrThrottled, ErrServer} {
+				assert.Equal(t, sentinel == tt.wantErr, errors.Is(err, sentinel), sentinel.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/awslabs/ssosync/internal/aws/mock"
+	"github.com/awslabs/ssosync/internal/datastore"
+)
+
+// fakeClock makes a rate limiter sleep by moving the time forward
+func fakeClock(l *rateLimiter) *[]time.Duration {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sleeps := make([]time.Duration, 0)
+	l.now = func() time.Time { return now }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+		return nil
+	}
+	return &sleeps
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	sleeps := fakeClock(l)
+
+	// the burst is sent at once, then 2 requests per second
+	for i := 0; i < 5; i++ {
+		l.wait(context.Background())
+	}
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, *sleeps)
+
+	// a pause holds back the next request
+	*sleeps = (*sleeps)[:0]
+	l.pause(3 * time.Second)
+	l.wait(context.Background())
+	assert.Equal(t, []time.Duration{3 * time.Second}, *sleeps)
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := newRateLimiter(0, 0)
+	sleeps := fakeClock(l)
+
+	for i := 0; i < 100; i++ {
+		l.wait(context.Background())
+	}
+	assert.Empty(t, *sleeps)
+
+	l.pause(time.Second)
+	l.wait(context.Background())
+	assert.Equal(t, []time.Duration{time.Second}, *sleeps)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+		wantOk bool
+	}{
+		{"none", "", 0, false},
+		{"seconds", "7", 7 * time.Second, true},
+		{"date", now.Add(time.Minute).Format(http.TimeFormat), time.Minute, true},
+		{"past date", now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"invalid", "soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got, ok := retryAfter(resp, now)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantOk, ok)
+		})
+	}
+}
+
+func TestSendRequestThrottled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewMockIHttpClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	}, datastore.NewNullDatastore())
+	assert.NoError(t, err)
+	cc := c.(*client)
+	sleeps := fakeClock(cc.limiter)
+
+	calledURL, _ := url.Parse("https://scim.example.com/")
+
+	req := httpReqMatcher{httpReq: &http.Request{
+		URL:    calledURL,
+		Method: http.MethodGet,
+	}}
+
+	throttled := func(status int, retryAfter string) *http.Response {
+		resp := &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       nopCloser{bytes.NewBufferString("")},
+		}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+
+	gomock.InOrder(
+		x.EXPECT().Do(&req).Return(throttled(http.StatusTooManyRequests, "2"), nil),
+		x.EXPECT().Do(&req).Return(throttled(http.StatusServiceUnavailable, ""), nil),
+		x.EXPECT().Do(&req).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Body:       nopCloser{bytes.NewBufferString("ok")},
+		}, nil),
+	)
+
+	r, err := cc.sendRequest(context.Background(), http.MethodGet, "https://scim.example.com/")
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(r))
+	assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second}, *sleeps)
+
+	// a request still throttled after the last retry fails
+	x.EXPECT().Do(&req).Times(DefaultMaxRetries+1).Return(throttled(http.StatusTooManyRequests, "1"), nil)
+
+	_, err = cc.sendRequest(context.Background(), http.MethodGet, "https://scim.example.com/")
+	assert.EqualError(t, err, "status of http response was 429")
+	assert.True(t, errors.Is(err, ErrThrottled))
+}
+
+func TestRateLimiterCancelled(t *testing.T) {
+	l := newRateLimiter(1, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, l.wait(ctx))
+
+	// the next token is a second away, the wait ends with the context
+	cancel()
+	assert.Equal(t, context.Canceled, l.wait(ctx))
+}
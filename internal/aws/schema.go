@@ -14,12 +14,29 @@
 
 package aws
 
+import "encoding/json"
+
 // Group represents a Group in AWS SSO
 type Group struct {
-	ID          string   `json:"id,omitempty"`
-	Schemas     []string `json:"schemas"`
-	DisplayName string   `json:"displayName"`
-	Members     []string `json:"members"`
+	ID          string        `json:"id,omitempty"`
+	ExternalID  string        `json:"externalId,omitempty"`
+	Schemas     []string      `json:"schemas"`
+	DisplayName string        `json:"displayName"`
+	Members     []GroupMember `json:"members"`
+}
+
+// GroupMember is a member of a group as returned by the SCIM endpoint
+type GroupMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// listResponse holds the pagination values shared by every SCIM list response
+type listResponse struct {
+	TotalResults int               `json:"totalResults"`
+	ItemsPerPage int               `json:"itemsPerPage"`
+	StartIndex   int               `json:"startIndex"`
+	Resources    []json.RawMessage `json:"Resources"`
 }
 
 // GroupFilterResults represents filtered results when we search for
@@ -52,6 +69,19 @@ type GroupMemberChange struct {
 	Operations []GroupMemberChangeOperation `json:"Operations"`
 }
 
+// PatchOperation replaces an attribute of a resource with value
+type PatchOperation struct {
+	Operation OperationType `json:"op"`
+	Path      string        `json:"path"`
+	Value     interface{}   `json:"value"`
+}
+
+// Patch represents a change operation of the attributes of a resource
+type Patch struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
 // UserEmail represents a user email address
 type UserEmail struct {
 	Value   string `json:"value"`
@@ -61,22 +91,60 @@ type UserEmail struct {
 
 // UserAddress represents address values of users
 type UserAddress struct {
-	Type string `json:"type"`
+	Type          string `json:"type"`
+	Formatted     string `json:"formatted,omitempty"`
+	StreetAddress string `json:"streetAddress,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postalCode,omitempty"`
+	Country       string `json:"country,omitempty"`
+	Primary       bool   `json:"primary,omitempty"`
+}
+
+// UserPhoneNumber represents a phone number of users
+type UserPhoneNumber struct {
+	Value   string `json:"value"`
+	Type    string `json:"type"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// UserManager references the manager of a user by its id
+type UserManager struct {
+	Value string `json:"value"`
+}
+
+// EnterpriseUser represents the attributes of the enterprise
+// extension of users
+type EnterpriseUser struct {
+	EmployeeNumber string       `json:"employeeNumber,omitempty"`
+	CostCenter     string       `json:"costCenter,omitempty"`
+	Organization   string       `json:"organization,omitempty"`
+	Division       string       `json:"division,omitempty"`
+	Department     string       `json:"department,omitempty"`
+	Manager        *UserManager `json:"manager,omitempty"`
 }
 
 // User represents a User in AWS SSO
 type User struct {
-	ID       string   `json:"id,omitempty"`
-	Schemas  []string `json:"schemas"`
-	Username string   `json:"userName"`
-	Name     struct {
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	Schemas    []string `json:"schemas"`
+	Username   string   `json:"userName"`
+	Name       struct {
 		FamilyName string `json:"familyName"`
 		GivenName  string `json:"givenName"`
 	} `json:"name"`
-	DisplayName string        `json:"displayName"`
-	Active      bool          `json:"active"`
-	Emails      []UserEmail   `json:"emails"`
-	Addresses   []UserAddress `json:"addresses"`
+	DisplayName       string            `json:"displayName"`
+	NickName          string            `json:"nickName,omitempty"`
+	Title             string            `json:"title,omitempty"`
+	PreferredLanguage string            `json:"preferredLanguage,omitempty"`
+	Locale            string            `json:"locale,omitempty"`
+	Timezone          string            `json:"timezone,omitempty"`
+	Active            bool              `json:"active"`
+	Emails            []UserEmail       `json:"emails"`
+	PhoneNumbers      []UserPhoneNumber `json:"phoneNumbers,omitempty"`
+	Addresses         []UserAddress     `json:"addresses"`
+	Enterprise        *EnterpriseUser   `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
 }
 
 // UserFilterResults represents filtered results when we search for
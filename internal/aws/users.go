@@ -82,3 +82,21 @@ func UpdateUser(id string, firstName string, lastName string, email string, acti
 		Addresses:   a,
 	}
 }
+
+// EnterpriseUserSchema is the schema of the enterprise extension of users
+const EnterpriseUserSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+
+// EnterpriseExtension returns the enterprise extension of the user,
+// adding it and its schema when the user has none.
+func (u *User) EnterpriseExtension() *EnterpriseUser {
+	if u.Enterprise == nil {
+		u.Enterprise = &EnterpriseUser{}
+	}
+	for _, schema := range u.Schemas {
+		if schema == EnterpriseUserSchema {
+			return u.Enterprise
+		}
+	}
+	u.Schemas = append(u.Schemas, EnterpriseUserSchema)
+	return u.Enterprise
+}
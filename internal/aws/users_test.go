@@ -51,3 +51,13 @@ func TestUpdateUser(t *testing.T) {
 	assert.Len(t, u.Schemas, 1)
 	assert.Equal(t, u.Schemas[0], "urn:ietf:params:scim:schemas:core:2.0:User")
 }
+
+func TestUser_EnterpriseExtension(t *testing.T) {
+	u := NewUser("Lee", "Packham", "test@email.com", true)
+	assert.Nil(t, u.Enterprise)
+
+	u.EnterpriseExtension().Department = "Engineering"
+	u.EnterpriseExtension().CostCenter = "42"
+	assert.Equal(t, &EnterpriseUser{Department: "Engineering", CostCenter: "42"}, u.Enterprise)
+	assert.Equal(t, []string{"urn:ietf:params:scim:schemas:core:2.0:User", EnterpriseUserSchema}, u.Schemas)
+}
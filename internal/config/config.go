@@ -1,6 +1,8 @@
 // Package config ...
 package config
 
+import "time"
+
 // Config ...
 type Config struct {
 	// Verbose toggles the verbosity
@@ -21,6 +23,12 @@ type Config struct {
 	SCIMEndpoint string `mapstructure:"scim_endpoint"`
 	// SCIMAccessToken ...
 	SCIMAccessToken string `mapstructure:"scim_access_token"`
+	// SCIMPageSize is the number of users or groups requested in each page when listing them
+	SCIMPageSize int `mapstructure:"scim_page_size"`
+	// SCIMRequestsPerSecond is the number of requests per second sent to the SCIM endpoint, 0 means no limit
+	SCIMRequestsPerSecond float64 `mapstructure:"scim_requests_per_second"`
+	// SCIMBurst is the number of requests sent at once before SCIMRequestsPerSecond applies
+	SCIMBurst int `mapstructure:"scim_burst"`
 	// IsLambda ...
 	IsLambda bool
 	// Ignore users ...
@@ -29,9 +37,33 @@ type Config struct {
 	IgnoreGroups []string `mapstructure:"ignore_groups"`
 	// Include groups ...
 	IncludeGroups []string `mapstructure:"include_groups"`
+	// UserAttributes are the SCIM attributes synced from the google users, as attribute or attribute=source
+	UserAttributes []string `mapstructure:"user_attributes"`
+	// ProtectedUsers are synced but never deleted, deactivated or removed from groups in AWS SSO
+	ProtectedUsers []string `mapstructure:"protected_users"`
+	// ProtectedGroups are synced but never deleted in AWS SSO
+	ProtectedGroups []string `mapstructure:"protected_groups"`
+	// UserFilter is an expression selecting the google users synced
+	UserFilter string `mapstructure:"user_filter"`
+	// GroupFilter is an expression selecting the google groups synced
+	GroupFilter string `mapstructure:"group_filter"`
+	// GroupNameTemplate is the template of the AWS names of the google groups, empty uses the name given by the sync method
+	GroupNameTemplate string `mapstructure:"group_name_template"`
+	// GroupNameRewrites are the regexp=replacement rewrites applied to the AWS names of the google groups
+	GroupNameRewrites []string `mapstructure:"group_name_rewrites"`
 	// SyncMethod allow to defined the sync method used to get the user and groups from Google Workspace
 	SyncMethod string `mapstructure:"sync_method"`
-	// Type of datastore 
+	// Prefetch lists the google users, and groups with their members, once before resolving the group members: none, users or all
+	Prefetch string `mapstructure:"prefetch"`
+	// NestedGroups is how the groups nested in the google groups are synced, flatten or mirror
+	NestedGroups string `mapstructure:"nested_groups"`
+	// OrgUnits are the paths of the google org units synced by the orgunits sync method
+	OrgUnits []string `mapstructure:"org_units"`
+	// OrgUnitsRecursive also syncs the org units below the org units
+	OrgUnitsRecursive bool `mapstructure:"org_units_recursive"`
+	// OrgUnitGroups creates an AWS group per org unit with its users as members
+	OrgUnitGroups bool `mapstructure:"org_unit_groups"`
+	// Type of datastore
 	DatastoreType string `mapstructure:"datastore_type"`
 	// Prefix or bucket name for datastores
 	DatastorePrefix string `mapstructure:"datastore_prefix"`
@@ -39,6 +71,46 @@ type Config struct {
 	DatastoreUserObj string `mapstructure:"datastore_user_obj"`
 	// name of the datastore group object or file
 	DatastoreGroupObj string `mapstructure:"datastore_group_obj"`
+	// name of the datastore object or file mapping google group ids to aws group ids
+	DatastoreGroupIDObj string `mapstructure:"datastore_group_id_obj"`
+	// name of the datastore object or file keeping the state between runs, like the incremental checkpoint
+	DatastoreStateObj string `mapstructure:"datastore_state_obj"`
+	// Incremental syncs only the google users and groups changed since the last run: none, poll or push
+	Incremental string `mapstructure:"incremental"`
+	// FullSyncInterval is how often the incremental sync runs a full sync instead
+	FullSyncInterval time.Duration `mapstructure:"full_sync_interval"`
+	// IncrementalInterval is how often the watch command syncs the changes
+	IncrementalInterval time.Duration `mapstructure:"incremental_interval"`
+	// NotificationAddress is the HTTPS URL google pushes the admin activities to
+	NotificationAddress string `mapstructure:"notification_address"`
+	// ListenAddress is the address the watch command receives the pushed admin activities on
+	ListenAddress string `mapstructure:"listen_address"`
+	// DryRun computes the changes without applying them to AWS SSO
+	DryRun bool `mapstructure:"dry_run"`
+	// MaxDeletions is the maximum number of users, groups or group members deleted in a run
+	MaxDeletions int `mapstructure:"max_deletions"`
+	// MaxDeletionsPercent is the maximum percentage of the existing users, groups or group members deleted in a run
+	MaxDeletionsPercent int `mapstructure:"max_deletions_percent"`
+	// Force applies the changes even when the deletion limits are exceeded
+	Force bool `mapstructure:"force"`
+	// DeletedUsers is what happens to the AWS users of the google users deleted: delete, deactivate or keep
+	DeletedUsers string `mapstructure:"deleted_users"`
+	// SuspendedUsers is what happens to the AWS users of the google users suspended: delete, deactivate or keep
+	SuspendedUsers string `mapstructure:"suspended_users"`
+	// ArchivedUsers is what happens to the AWS users of the google users archived: delete, deactivate or keep
+	ArchivedUsers string `mapstructure:"archived_users"`
+	// RemovedUsers is what happens to the AWS users of the google users not in any group synced anymore: delete, deactivate or keep
+	RemovedUsers string `mapstructure:"removed_users"`
+	// DeletionGracePeriod is how long a user stays in AWS SSO once it is to delete
+	DeletionGracePeriod time.Duration `mapstructure:"deletion_grace_period"`
+	// DeprovisionAction is what is done with the users to delete: delete, or deactivate them and delete them after the grace period
+	DeprovisionAction string `mapstructure:"deprovision_action"`
+	// ManageUnowned deletes and removes from groups the AWS users and groups not created by ssosync, and takes over the ones matched by email or name
+	ManageUnowned bool `mapstructure:"manage_unowned"`
+	// Concurrency is the number of AWS SSO and Google Workspace calls made at the same time
+	Concurrency int `mapstructure:"concurrency"`
+	// Timeout cancels the run after this duration, 0 means no timeout
+	Timeout time.Duration `mapstructure:"timeout"`
 }
 
 const (
@@ -52,24 +124,115 @@ const (
 	DefaultGoogleCredentials = "credentials.json"
 	// DefaultSyncMethod is the default sync method to use.
 	DefaultSyncMethod = "groups"
+	// PrefetchNone requests the users of the groups one by one
+	PrefetchNone = "none"
+	// PrefetchUsers lists all the users once
+	PrefetchUsers = "users"
+	// PrefetchAll lists all the users, the groups and their members once
+	PrefetchAll = "all"
+	// DefaultPrefetch is the default prefetch mode
+	DefaultPrefetch = PrefetchNone
+	// NestedGroupsFlatten adds the users of the nested groups to the groups they are nested in
+	NestedGroupsFlatten = "flatten"
+	// NestedGroupsMirror syncs the nested groups as AWS groups of their own with their direct users
+	NestedGroupsMirror = "mirror"
+	// DefaultNestedGroups is the default nested groups mode
+	DefaultNestedGroups = NestedGroupsFlatten
+	// SyncMethodOrgUnits syncs the users of google org units
+	SyncMethodOrgUnits = "orgunits"
+	// DefaultOrgUnitsRecursive is the default recursion in the org units
+	DefaultOrgUnitsRecursive = true
+	// IncrementalNone always runs a full sync
+	IncrementalNone = "none"
+	// IncrementalPoll polls the admin activities changed since the checkpoint
+	IncrementalPoll = "poll"
+	// IncrementalPush receives the admin activities pushed by google, the watch command only
+	IncrementalPush = "push"
+	// DefaultIncremental is the default incremental mode
+	DefaultIncremental = IncrementalNone
+	// DefaultFullSyncInterval is the default duration between two full syncs of the incremental sync
+	DefaultFullSyncInterval = 24 * time.Hour
+	// DefaultIncrementalInterval is the default duration between two syncs of the watch command
+	DefaultIncrementalInterval = time.Minute
+	// DefaultListenAddress is the default address the watch command listens on
+	DefaultListenAddress = ":8080"
 	// DefaultDatastoreType is the default datastore to use
-	DefaultDatastoreType = "file"
-	DefaultDatastorePrefix = "ssosync-"
-	DefaultDatastoreUserObj = "Users.json"
-	DefaultDatastoreGroupObj = "Groups.json"
+	DefaultDatastoreType       = "file"
+	DefaultDatastorePrefix     = "ssosync-"
+	DefaultDatastoreUserObj    = "Users.json"
+	DefaultDatastoreGroupObj   = "Groups.json"
+	DefaultDatastoreGroupIDObj = "GroupIDs.json"
+	DefaultDatastoreStateObj   = "State.json"
+	// DefaultSCIMPageSize is the default number of users or groups requested in each page
+	DefaultSCIMPageSize = 50
+	// DefaultSCIMRequestsPerSecond is the default number of requests per second sent to the SCIM endpoint,
+	// 0 does not limit them and only the Retry-After of the throttled requests applies
+	DefaultSCIMRequestsPerSecond = 0
+	// DefaultSCIMBurst is the default number of requests sent at once to the SCIM endpoint
+	DefaultSCIMBurst = 10
+	// DefaultDryRun is the default dry run status.
+	DefaultDryRun = false
+	// DefaultMaxDeletions is the default maximum number of deletions, 0 means no limit
+	DefaultMaxDeletions = 0
+	// DefaultMaxDeletionsPercent is the default maximum percentage of deletions, 0 means no limit
+	DefaultMaxDeletionsPercent = 0
+	// DeprovisionDelete deletes the AWS user
+	DeprovisionDelete = "delete"
+	// DeprovisionDeactivate sets the AWS user inactive
+	DeprovisionDeactivate = "deactivate"
+	// DeprovisionKeep leaves the AWS user as it is
+	DeprovisionKeep = "keep"
+	// DefaultDeletedUsers is the default policy of the google users deleted
+	DefaultDeletedUsers = DeprovisionDelete
+	// DefaultSuspendedUsers is the default policy of the google users suspended
+	DefaultSuspendedUsers = DeprovisionDeactivate
+	// DefaultArchivedUsers is the default policy of the google users archived
+	DefaultArchivedUsers = DeprovisionKeep
+	// DefaultRemovedUsers is the default policy of the google users not in any group synced anymore
+	DefaultRemovedUsers = DeprovisionDelete
+	// DefaultDeletionGracePeriod is the default grace period before deleting a user, 0 deletes it right away
+	DefaultDeletionGracePeriod = 0
+	// DefaultDeprovisionAction is the default action on the users to delete
+	DefaultDeprovisionAction = DeprovisionDelete
+	// DefaultConcurrency is the default number of calls made at the same time
+	DefaultConcurrency = 1
+	// DefaultTimeout is the default duration of a run, 0 means no timeout
+	DefaultTimeout = 0
 )
 
 // New returns a new Config
 func New() *Config {
 	return &Config{
-		Debug:             DefaultDebug,
-		LogLevel:          DefaultLogLevel,
-		LogFormat:         DefaultLogFormat,
-		SyncMethod:        DefaultSyncMethod,
-		GoogleCredentials: DefaultGoogleCredentials,
-		DatastoreType:     DefaultDatastoreType,
-		DatastorePrefix:   DefaultDatastorePrefix,
-		DatastoreUserObj:  DefaultDatastoreUserObj,
-		DatastoreGroupObj: DefaultDatastoreGroupObj,
+		Debug:                 DefaultDebug,
+		LogLevel:              DefaultLogLevel,
+		LogFormat:             DefaultLogFormat,
+		SyncMethod:            DefaultSyncMethod,
+		OrgUnitsRecursive:     DefaultOrgUnitsRecursive,
+		NestedGroups:          DefaultNestedGroups,
+		Prefetch:              DefaultPrefetch,
+		GoogleCredentials:     DefaultGoogleCredentials,
+		DatastoreType:         DefaultDatastoreType,
+		DatastorePrefix:       DefaultDatastorePrefix,
+		DatastoreUserObj:      DefaultDatastoreUserObj,
+		DatastoreGroupObj:     DefaultDatastoreGroupObj,
+		DatastoreGroupIDObj:   DefaultDatastoreGroupIDObj,
+		DatastoreStateObj:     DefaultDatastoreStateObj,
+		Incremental:           DefaultIncremental,
+		FullSyncInterval:      DefaultFullSyncInterval,
+		IncrementalInterval:   DefaultIncrementalInterval,
+		ListenAddress:         DefaultListenAddress,
+		SCIMPageSize:          DefaultSCIMPageSize,
+		SCIMRequestsPerSecond: DefaultSCIMRequestsPerSecond,
+		SCIMBurst:             DefaultSCIMBurst,
+		DryRun:                DefaultDryRun,
+		MaxDeletions:          DefaultMaxDeletions,
+		MaxDeletionsPercent:   DefaultMaxDeletionsPercent,
+		DeletedUsers:          DefaultDeletedUsers,
+		SuspendedUsers:        DefaultSuspendedUsers,
+		ArchivedUsers:         DefaultArchivedUsers,
+		RemovedUsers:          DefaultRemovedUsers,
+		DeletionGracePeriod:   DefaultDeletionGracePeriod,
+		DeprovisionAction:     DefaultDeprovisionAction,
+		Concurrency:           DefaultConcurrency,
 	}
 }
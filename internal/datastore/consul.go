@@ -10,12 +10,14 @@ import (
 
 type consulDatastore struct {
 	*baseDatastore
-	kv       *consulapi.KV
-	userKey  string
-	groupKey string
+	kv         *consulapi.KV
+	userKey    string
+	groupKey   string
+	groupIDKey string
+	stateKey   string
 }
 
-func NewConsulDatastore(prefix string, userObj string, groupObj string) (Datastore, error) {
+func NewConsulDatastore(prefix string, userObj string, groupObj string, groupIDObj string, stateObj string) (Datastore, error) {
 	consul, err := consulapi.NewClient(consulapi.DefaultConfig())
 	if err != nil {
 		return nil, err
@@ -26,10 +28,15 @@ func NewConsulDatastore(prefix string, userObj string, groupObj string) (Datasto
 		kv:            consul.KV(),
 		userKey:       prefix + userObj,
 		groupKey:      prefix + groupObj,
+		groupIDKey:    prefix + groupIDObj,
+		stateKey:      prefix + stateObj,
 	}, nil
 }
 
 func (ds *consulDatastore) Load() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
 	log.Info("Loading user/group lists from consul")
 	log.Infof("loading users from '%s'", ds.userKey)
 
@@ -58,10 +65,39 @@ func (ds *consulDatastore) Load() error {
 		}
 	}
 
+	log.Infof("loading group ids from '%s'", ds.groupIDKey)
+	pair, _, err = ds.kv.Get(ds.groupIDKey, nil)
+	if err != nil {
+		return fmt.Errorf("error fetching group ids: %w", err)
+	} else if pair == nil {
+		log.Warningf("consul KV '%s' does not exist: %s", ds.groupIDKey, err)
+	} else {
+		err = json.Unmarshal(pair.Value, &ds.groupIDs)
+		if err != nil {
+			return fmt.Errorf("failed to parse group id list JSON from consul: %w", err)
+		}
+	}
+
+	log.Infof("loading state from '%s'", ds.stateKey)
+	pair, _, err = ds.kv.Get(ds.stateKey, nil)
+	if err != nil {
+		return fmt.Errorf("error fetching state: %w", err)
+	} else if pair == nil {
+		log.Warningf("consul KV '%s' does not exist: %s", ds.stateKey, err)
+	} else {
+		err = json.Unmarshal(pair.Value, &ds.state)
+		if err != nil {
+			return fmt.Errorf("failed to parse state JSON from consul: %w", err)
+		}
+	}
+
 	return err
 }
 
 func (ds *consulDatastore) Store() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
 	log.Info("Storing user/group lists in consul")
 	log.Infof("storing users to '%s'", ds.userKey)
 
@@ -89,5 +125,29 @@ func (ds *consulDatastore) Store() error {
 	if err != nil {
 		return fmt.Errorf("failed to PUT groups in '%s': %w", ds.groupKey, err)
 	}
+	data, err = json.MarshalIndent(ds.groupIDs, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to convert group id list to json: %w", err)
+	}
+	pair = consulapi.KVPair{
+		Key:   ds.groupIDKey,
+		Value: data,
+	}
+	_, err = ds.kv.Put(&pair, nil)
+	if err != nil {
+		return fmt.Errorf("failed to PUT group ids in '%s': %w", ds.groupIDKey, err)
+	}
+	data, err = json.MarshalIndent(ds.state, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to convert state to json: %w", err)
+	}
+	pair = consulapi.KVPair{
+		Key:   ds.stateKey,
+		Value: data,
+	}
+	_, err = ds.kv.Put(&pair, nil)
+	if err != nil {
+		return fmt.Errorf("failed to PUT state in '%s': %w", ds.stateKey, err)
+	}
 	return nil
 }
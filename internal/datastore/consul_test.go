@@ -124,7 +124,7 @@ func TestConsul(t *testing.T) {
 	for _, data := range tests {
 		data := data
 		prefix := setup()
-		ds, err := NewConsulDatastore(prefix, data.userFile, data.groupFile)
+		ds, err := NewConsulDatastore(prefix, data.userFile, data.groupFile, noSuchFileName, noSuchFileName)
 		if err != nil {
 			t.Errorf("failed to create datastore for test '%s': %s", data.desc, err)
 		}
@@ -2,6 +2,8 @@ package datastore
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/awslabs/ssosync/internal/config"
 	log "github.com/sirupsen/logrus"
@@ -16,34 +18,67 @@ type Datastore interface {
 	GetGroups() ([]string, error)
 	AddGroup(string) error
 	DeleteGroup(string) error
+	GetGroupIDs() (map[string]string, error)
+	SetGroupID(string, string) error
+	DeleteGroupID(string) error
+	GetState(string) (string, error)
+	SetState(string, string) error
+	GetDeprovisionedUsers() (map[string]time.Time, error)
+	SetDeprovisionedUser(string, time.Time) error
+	DeleteDeprovisionedUser(string) error
 }
 
 type datastoreUsers map[string]bool
 type datastoreGroups map[string]bool
+
+// datastoreGroupIDs maps the id of a google group to the id of its aws group,
+// so a group is still found after it was renamed
+type datastoreGroupIDs map[string]string
+
+// datastoreState is the state kept between runs, like the checkpoint of the
+// incremental sync
+type datastoreState struct {
+	Values map[string]string `json:"values,omitempty"`
+	// Deprovisioned maps the id of an aws user to the time it was first
+	// found to deprovision
+	Deprovisioned map[string]time.Time `json:"deprovisioned,omitempty"`
+}
+
 type baseDatastore struct {
-	users  datastoreUsers
-	groups datastoreGroups
+	// mu guards users, groups, groupIDs and state, the AWS client and the
+	// sync update them concurrently
+	mu       sync.Mutex
+	users    datastoreUsers
+	groups   datastoreGroups
+	groupIDs datastoreGroupIDs
+	state    datastoreState
 }
 
 func newBaseDatastore() *baseDatastore {
 	return &baseDatastore{
-		users:  datastoreUsers{},
-		groups: datastoreGroups{},
+		users:    datastoreUsers{},
+		groups:   datastoreGroups{},
+		groupIDs: datastoreGroupIDs{},
+		state:    datastoreState{Values: map[string]string{}},
 	}
 }
 
 func NewDatastore(cfg *config.Config) (Datastore, error) {
 	if cfg.DatastoreType == "file" {
-		return NewFileDatastore(cfg.DatastorePrefix, cfg.DatastoreUserObj, cfg.DatastoreGroupObj)
+		return NewFileDatastore(cfg.DatastorePrefix, cfg.DatastoreUserObj, cfg.DatastoreGroupObj, cfg.DatastoreGroupIDObj, cfg.DatastoreStateObj)
 	} else if cfg.DatastoreType == "consul" {
-		return NewConsulDatastore(cfg.DatastorePrefix, cfg.DatastoreUserObj, cfg.DatastoreGroupObj)
+		return NewConsulDatastore(cfg.DatastorePrefix, cfg.DatastoreUserObj, cfg.DatastoreGroupObj, cfg.DatastoreGroupIDObj, cfg.DatastoreStateObj)
 	} else if cfg.DatastoreType == "s3" {
-		return NewS3Datastore(cfg.DatastorePrefix, cfg.DatastoreUserObj, cfg.DatastoreGroupObj)
+		return NewS3Datastore(cfg.DatastorePrefix, cfg.DatastoreUserObj, cfg.DatastoreGroupObj, cfg.DatastoreGroupIDObj, cfg.DatastoreStateObj)
+	} else if cfg.DatastoreType == "none" {
+		return NewNullDatastore(), nil
 	}
 	return nil, fmt.Errorf("unknown datastore type: %s", cfg.DatastoreType)
 }
 
 func (ds *baseDatastore) GetUsers() ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	users := make([]string, 0, len(ds.users))
 	for name := range ds.users {
 		users = append(users, name)
@@ -52,6 +87,8 @@ func (ds *baseDatastore) GetUsers() ([]string, error) {
 }
 
 func (ds *baseDatastore) AddUser(user string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	log := log.WithFields(log.Fields{"user": user})
 	if _, ok := ds.users[user]; !ok {
 		log.Debug("adding user to datastore")
@@ -61,6 +98,8 @@ func (ds *baseDatastore) AddUser(user string) error {
 }
 
 func (ds *baseDatastore) DeleteUser(user string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	log := log.WithFields(log.Fields{"group": user})
 	log.Debug("deleting user from datastore")
 	delete(ds.users, user)
@@ -68,6 +107,8 @@ func (ds *baseDatastore) DeleteUser(user string) error {
 }
 
 func (ds *baseDatastore) GetGroups() ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	groups := make([]string, 0, len(ds.groups))
 	for name := range ds.groups {
 		groups = append(groups, name)
@@ -76,6 +117,8 @@ func (ds *baseDatastore) GetGroups() ([]string, error) {
 }
 
 func (ds *baseDatastore) AddGroup(group string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	log := log.WithFields(log.Fields{"group": group})
 	if _, ok := ds.groups[group]; !ok {
 		log.Debug("adding group to datastore")
@@ -85,8 +128,99 @@ func (ds *baseDatastore) AddGroup(group string) error {
 }
 
 func (ds *baseDatastore) DeleteGroup(group string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	log := log.WithFields(log.Fields{"group": group})
 	log.Debug("deleting group from datastore")
 	delete(ds.groups, group)
 	return nil
 }
+
+// GetGroupIDs returns a copy of the map of google group ids to aws group ids
+func (ds *baseDatastore) GetGroupIDs() (map[string]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	groupIDs := make(map[string]string, len(ds.groupIDs))
+	for googleID, awsID := range ds.groupIDs {
+		groupIDs[googleID] = awsID
+	}
+	return groupIDs, nil
+}
+
+func (ds *baseDatastore) SetGroupID(googleID string, awsID string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	log := log.WithFields(log.Fields{"google_id": googleID, "aws_id": awsID})
+	if ds.groupIDs[googleID] != awsID {
+		log.Debug("setting group id in datastore")
+		ds.groupIDs[googleID] = awsID
+	}
+	return nil
+}
+
+func (ds *baseDatastore) DeleteGroupID(googleID string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	log := log.WithFields(log.Fields{"google_id": googleID})
+	log.Debug("deleting group id from datastore")
+	delete(ds.groupIDs, googleID)
+	return nil
+}
+
+// GetState returns the value of the key in the state, empty when it is not set
+func (ds *baseDatastore) GetState(key string) (string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.state.Values[key], nil
+}
+
+func (ds *baseDatastore) SetState(key string, value string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	log := log.WithFields(log.Fields{"key": key, "value": value})
+	if ds.state.Values == nil {
+		ds.state.Values = map[string]string{}
+	}
+	if ds.state.Values[key] != value {
+		log.Debug("setting state in datastore")
+		ds.state.Values[key] = value
+	}
+	return nil
+}
+
+// GetDeprovisionedUsers returns a copy of the map of aws user ids to the
+// time they were first found to deprovision
+func (ds *baseDatastore) GetDeprovisionedUsers() (map[string]time.Time, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	deprovisioned := make(map[string]time.Time, len(ds.state.Deprovisioned))
+	for id, since := range ds.state.Deprovisioned {
+		deprovisioned[id] = since
+	}
+	return deprovisioned, nil
+}
+
+func (ds *baseDatastore) SetDeprovisionedUser(id string, since time.Time) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	log := log.WithFields(log.Fields{"user_id": id, "since": since})
+	if ds.state.Deprovisioned == nil {
+		ds.state.Deprovisioned = map[string]time.Time{}
+	}
+	if !ds.state.Deprovisioned[id].Equal(since) {
+		log.Debug("setting deprovisioned user in datastore")
+		ds.state.Deprovisioned[id] = since
+	}
+	return nil
+}
+
+func (ds *baseDatastore) DeleteDeprovisionedUser(id string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	log := log.WithFields(log.Fields{"user_id": id})
+	if _, ok := ds.state.Deprovisioned[id]; ok {
+		log.Debug("deleting deprovisioned user from datastore")
+		delete(ds.state.Deprovisioned, id)
+	}
+	return nil
+}
@@ -9,19 +9,26 @@ import (
 
 type fileDatastore struct {
 	*baseDatastore
-	userFile  string
-	groupFile string
+	userFile    string
+	groupFile   string
+	groupIDFile string
+	stateFile   string
 }
 
-func NewFileDatastore(prefix string, userObj string, groupObj string) (Datastore, error) {
+func NewFileDatastore(prefix string, userObj string, groupObj string, groupIDObj string, stateObj string) (Datastore, error) {
 	return &fileDatastore{
 		baseDatastore: newBaseDatastore(),
 		userFile:      prefix + userObj,
 		groupFile:     prefix + groupObj,
+		groupIDFile:   prefix + groupIDObj,
+		stateFile:     prefix + stateObj,
 	}, nil
 }
 
 func (ds *fileDatastore) Load() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
 	log.Info("Loading user/group lists from files")
 
 	log.Infof("loading users from '%s'", ds.userFile)
@@ -58,10 +65,47 @@ func (ds *fileDatastore) Load() error {
 		}
 	}
 
+	log.Infof("loading group ids from '%s'", ds.groupIDFile)
+	gif, err := os.Open(ds.groupIDFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Warningf("failed to open %s file: %s", ds.groupIDFile, err)
+		} else {
+			return fmt.Errorf("failed to open %s: %d", ds.groupIDFile, err)
+		}
+	} else {
+		defer gif.Close()
+		decoder := json.NewDecoder(gif)
+		err = decoder.Decode(&ds.groupIDs)
+		if err != nil {
+			return fmt.Errorf("failed to decode group id list: %w", err)
+		}
+	}
+
+	log.Infof("loading state from '%s'", ds.stateFile)
+	sf, err := os.Open(ds.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Warningf("failed to open %s file: %s", ds.stateFile, err)
+		} else {
+			return fmt.Errorf("failed to open %s: %d", ds.stateFile, err)
+		}
+	} else {
+		defer sf.Close()
+		decoder := json.NewDecoder(sf)
+		err = decoder.Decode(&ds.state)
+		if err != nil {
+			return fmt.Errorf("failed to decode state: %w", err)
+		}
+	}
+
 	return nil
 }
 
 func (ds *fileDatastore) Store() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
 	log.Info("Storing user/group lists in files")
 
 	log.Infof("storing users in '%s'", ds.userFile)
@@ -91,5 +135,33 @@ func (ds *fileDatastore) Store() error {
 			return fmt.Errorf("failed to encode group list to json: %w", err)
 		}
 	}
+
+	log.Infof("storing group ids in '%s'", ds.groupIDFile)
+	gif, err := os.Create(ds.groupIDFile)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", ds.groupIDFile, err)
+	} else {
+		defer gif.Close()
+		encoder := json.NewEncoder(gif)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(&ds.groupIDs)
+		if err != nil {
+			return fmt.Errorf("failed to encode group id list to json: %w", err)
+		}
+	}
+
+	log.Infof("storing state in '%s'", ds.stateFile)
+	sf, err := os.Create(ds.stateFile)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", ds.stateFile, err)
+	} else {
+		defer sf.Close()
+		encoder := json.NewEncoder(sf)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(&ds.state)
+		if err != nil {
+			return fmt.Errorf("failed to encode state to json: %w", err)
+		}
+	}
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestFile(t *testing.T) {
@@ -14,6 +15,7 @@ func TestFile(t *testing.T) {
 		unreadableFileName = "unreadable.json"
 		userFileName       = "Users.json"
 		groupFileName      = "Groups.json"
+		groupIDFileName    = "GroupIDs.json"
 	)
 
 	setup := func(t *testing.T) string {
@@ -55,6 +57,15 @@ func TestFile(t *testing.T) {
 			t.Fatalf("could not write valid user file %s: %s", prefix+groupFileName, err)
 		}
 		f.Close()
+		f, err = os.Create(prefix + groupIDFileName)
+		if err != nil {
+			t.Fatalf("could not create valid group id file %s: %s", prefix+groupIDFileName, err)
+		}
+		_, err = f.WriteString("{\"google-id-1\": \"aws-id-1\"}")
+		if err != nil {
+			t.Fatalf("could not write valid group id file %s: %s", prefix+groupIDFileName, err)
+		}
+		f.Close()
 		return prefix
 	}
 
@@ -95,11 +106,12 @@ func TestFile(t *testing.T) {
 		LoadStoreLoad
 	)
 	tests := []struct {
-		desc      string
-		groupFile string
-		userFile  string
-		success   bool // true if success is expected
-		test      TestType
+		desc        string
+		groupFile   string
+		userFile    string
+		groupIDFile string
+		success     bool // true if success is expected
+		test        TestType
 	}{
 		{
 			desc:      "no existing file",
@@ -150,12 +162,32 @@ func TestFile(t *testing.T) {
 			success:   true,
 			test:      LoadStoreLoad,
 		},
+		{
+			desc:        "empty group id file",
+			userFile:    noSuchFileName,
+			groupFile:   noSuchFileName,
+			groupIDFile: emptyFileName,
+			success:     false,
+			test:        LoadOnly,
+		},
+		{
+			desc:        "valid group id file",
+			userFile:    noSuchFileName,
+			groupFile:   noSuchFileName,
+			groupIDFile: groupIDFileName,
+			success:     true,
+			test:        LoadStoreLoad,
+		},
 	}
 
 	for _, data := range tests {
 		data := data
 		prefix := setup(t)
-		ds, err := NewFileDatastore(prefix, data.userFile, data.groupFile)
+		groupIDFile := data.groupIDFile
+		if groupIDFile == "" {
+			groupIDFile = noSuchFileName
+		}
+		ds, err := NewFileDatastore(prefix, data.userFile, data.groupFile, groupIDFile, noSuchFileName)
 		if err != nil {
 			t.Errorf("failed to create datastore for test '%s': %s", data.desc, err)
 		}
@@ -180,3 +212,77 @@ func TestFile(t *testing.T) {
 		}
 	}
 }
+
+func TestFileGroupIDs(t *testing.T) {
+	prefix := t.TempDir() + "/"
+
+	ds, _ := NewFileDatastore(prefix, "Users.json", "Groups.json", "GroupIDs.json", "State.json")
+	if err := ds.SetGroupID("google-id-1", "aws-id-1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ds.SetGroupID("google-id-2", "aws-id-2"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ds.DeleteGroupID("google-id-2"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ds.Store(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	loaded, _ := NewFileDatastore(prefix, "Users.json", "Groups.json", "GroupIDs.json", "State.json")
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	groupIDs, err := loaded.GetGroupIDs()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(groupIDs) != 1 || groupIDs["google-id-1"] != "aws-id-1" {
+		t.Errorf("GetGroupIDs() = %v, want map[google-id-1:aws-id-1]", groupIDs)
+	}
+}
+
+func TestFileState(t *testing.T) {
+	prefix := t.TempDir() + "/"
+
+	ds, _ := NewFileDatastore(prefix, "Users.json", "Groups.json", "GroupIDs.json", "State.json")
+	if err := ds.SetState("checkpoint", "2021-05-01T10:00:00Z"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	since := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := ds.SetDeprovisionedUser("aws-1", since); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ds.SetDeprovisionedUser("aws-2", since); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ds.DeleteDeprovisionedUser("aws-2"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ds.Store(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	loaded, _ := NewFileDatastore(prefix, "Users.json", "Groups.json", "GroupIDs.json", "State.json")
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	value, err := loaded.GetState("checkpoint")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if value != "2021-05-01T10:00:00Z" {
+		t.Errorf("GetState(checkpoint) = %q, want 2021-05-01T10:00:00Z", value)
+	}
+	if value, _ := loaded.GetState("missing"); value != "" {
+		t.Errorf("GetState(missing) = %q, want empty", value)
+	}
+	deprovisioned, err := loaded.GetDeprovisionedUsers()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(deprovisioned) != 1 || !deprovisioned["aws-1"].Equal(since) {
+		t.Errorf("GetDeprovisionedUsers() = %v, want aws-1 since %v", deprovisioned, since)
+	}
+}
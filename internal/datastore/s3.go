@@ -14,13 +14,15 @@ import (
 
 type s3Datastore struct {
 	*baseDatastore
-	s3       *s3.S3
-	bucket   string
-	userKey  string
-	groupKey string
+	s3         *s3.S3
+	bucket     string
+	userKey    string
+	groupKey   string
+	groupIDKey string
+	stateKey   string
 }
 
-func NewS3Datastore(bucket string, userObj string, groupObj string) (Datastore, error) {
+func NewS3Datastore(bucket string, userObj string, groupObj string, groupIDObj string, stateObj string) (Datastore, error) {
 	sess, err := session.NewSession()
 	if err != nil {
 		return nil, err
@@ -32,10 +34,15 @@ func NewS3Datastore(bucket string, userObj string, groupObj string) (Datastore,
 		bucket:        bucket,
 		userKey:       userObj,
 		groupKey:      groupObj,
+		groupIDKey:    groupIDObj,
+		stateKey:      stateObj,
 	}, nil
 }
 
 func (ds *s3Datastore) Load() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
 	log.Info("Loading user/group lists from S3")
 	log.Infof("loading users from bucket '%s' object '%s'", ds.bucket, ds.userKey)
 	userResults, err := ds.s3.GetObject(&s3.GetObjectInput{
@@ -82,10 +89,59 @@ func (ds *s3Datastore) Load() error {
 			return fmt.Errorf("failed to decode group list: %w", err)
 		}
 	}
+
+	log.Infof("loading group ids from bucket '%s' object '%s'", ds.bucket, ds.groupIDKey)
+	groupIDResult, err := ds.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(ds.bucket),
+		Key:    aws.String(ds.groupIDKey),
+	})
+	if err != nil {
+		// cast to awserr err to determin if its that the key does not exist
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == s3.ErrCodeNoSuchKey {
+				log.Warningf("S3 key '%s' does not exist: %s", ds.groupIDKey, err)
+			} else {
+				return fmt.Errorf("error fetching group ids: %w", err)
+			}
+		}
+	} else {
+		defer groupIDResult.Body.Close()
+		decoder := json.NewDecoder(groupIDResult.Body)
+		err = decoder.Decode(&ds.groupIDs)
+		if err != nil {
+			return fmt.Errorf("failed to decode group id list: %w", err)
+		}
+	}
+
+	log.Infof("loading state from bucket '%s' object '%s'", ds.bucket, ds.stateKey)
+	stateResult, err := ds.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(ds.bucket),
+		Key:    aws.String(ds.stateKey),
+	})
+	if err != nil {
+		// cast to awserr err to determin if its that the key does not exist
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == s3.ErrCodeNoSuchKey {
+				log.Warningf("S3 key '%s' does not exist: %s", ds.stateKey, err)
+			} else {
+				return fmt.Errorf("error fetching state: %w", err)
+			}
+		}
+	} else {
+		defer stateResult.Body.Close()
+		decoder := json.NewDecoder(stateResult.Body)
+		err = decoder.Decode(&ds.state)
+		if err != nil {
+			return fmt.Errorf("failed to decode state: %w", err)
+		}
+	}
 	return nil
 }
 
 func (ds *s3Datastore) Store() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
 	log.Infof("Storing user/group lists in S3 bucket: %s", ds.bucket)
 	data, err := json.Marshal(ds.users)
 	if err != nil {
@@ -115,5 +171,33 @@ func (ds *s3Datastore) Store() error {
 		return fmt.Errorf("failed to PUT group list in S3: %w", err)
 	}
 
+	data, err = json.Marshal(ds.groupIDs)
+	if err != nil {
+		return fmt.Errorf("failed to convert group id list to json: %w", err)
+	}
+	input = &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
+		Bucket: aws.String(ds.bucket),
+		Key:    aws.String(ds.groupIDKey),
+	}
+	_, err = ds.s3.PutObject(input)
+	if err != nil {
+		return fmt.Errorf("failed to PUT group id list in S3: %w", err)
+	}
+
+	data, err = json.Marshal(ds.state)
+	if err != nil {
+		return fmt.Errorf("failed to convert state to json: %w", err)
+	}
+	input = &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
+		Bucket: aws.String(ds.bucket),
+		Key:    aws.String(ds.stateKey),
+	}
+	_, err = ds.s3.PutObject(input)
+	if err != nil {
+		return fmt.Errorf("failed to PUT state in S3: %w", err)
+	}
+
 	return nil
 }
@@ -151,7 +151,7 @@ func TestS3(t *testing.T) {
 	for _, data := range tests {
 		data := data
 		prefix := setup()
-		ds, err := NewS3Datastore(bucket, prefix+data.userFile, prefix+data.groupFile)
+		ds, err := NewS3Datastore(bucket, prefix+data.userFile, prefix+data.groupFile, prefix+noSuchFileName, prefix+noSuchFileName)
 		if err != nil {
 			t.Errorf("failed to create datastore for test '%s': %s", data.desc, err)
 		}
@@ -0,0 +1,399 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// ErrDeprovision is returned for an invalid deprovisioning policy
+var ErrDeprovision = errors.New("invalid deprovisioning policy")
+
+// the states of the google users whose AWS users are deprovisioned
+const (
+	userDeleted   = "deleted"
+	userSuspended = "suspended"
+	userArchived  = "archived"
+	// userRemoved is a user not in any group or org unit synced anymore
+	userRemoved = "removed"
+)
+
+// validateDeprovision checks the policies and the grace period
+func validateDeprovision(cfg *config.Config) error {
+	policies := []struct{ flag, policy string }{
+		{"--deleted-users", cfg.DeletedUsers},
+		{"--suspended-users", cfg.SuspendedUsers},
+		{"--archived-users", cfg.ArchivedUsers},
+		{"--removed-users", cfg.RemovedUsers},
+	}
+	for _, p := range policies {
+		switch p.policy {
+		case config.DeprovisionDelete, config.DeprovisionDeactivate, config.DeprovisionKeep:
+		default:
+			return fmt.Errorf("%w: %s %q, expected %s, %s or %s", ErrDeprovision, p.flag, p.policy, config.DeprovisionDelete, config.DeprovisionDeactivate, config.DeprovisionKeep)
+		}
+	}
+	if cfg.DeletionGracePeriod < 0 {
+		return fmt.Errorf("%w: negative --deletion-grace-period %s", ErrDeprovision, cfg.DeletionGracePeriod)
+	}
+
+	switch cfg.DeprovisionAction {
+	case config.DeprovisionDelete, config.DeprovisionDeactivate:
+	default:
+		return fmt.Errorf("%w: --deprovision-action %q, expected %s or %s", ErrDeprovision, cfg.DeprovisionAction, config.DeprovisionDelete, config.DeprovisionDeactivate)
+	}
+	return nil
+}
+
+// deprovisionPolicy returns what is done with the AWS users of the google
+// users in the state given
+func (s *syncGSuite) deprovisionPolicy(state string) string {
+	switch state {
+	case userDeleted:
+		return s.cfg.DeletedUsers
+	case userSuspended:
+		return s.cfg.SuspendedUsers
+	case userArchived:
+		return s.cfg.ArchivedUsers
+	default:
+		return s.cfg.RemovedUsers
+	}
+}
+
+// googleUserState returns the state of a google user that can't sign in,
+// empty for the active users
+func googleUserState(u *admin.User) string {
+	if u.Archived {
+		return userArchived
+	}
+	if u.Suspended {
+		return userSuspended
+	}
+	return ""
+}
+
+// syncedGoogleUser returns the google user as it is synced under the
+// policy of its state, a copy suspended or not when its AWS user is
+// deactivated or kept, and nil when its AWS user is deleted
+func (s *syncGSuite) syncedGoogleUser(u *admin.User) (*admin.User, string) {
+	state := googleUserState(u)
+	if state == "" {
+		return u, ""
+	}
+
+	switch s.deprovisionPolicy(state) {
+	case config.DeprovisionDelete:
+		return nil, state
+	case config.DeprovisionDeactivate:
+		if u.Suspended {
+			return u, state
+		}
+	default:
+		if !u.Suspended {
+			return u, state
+		}
+	}
+	synced := *u
+	synced.Suspended = !u.Suspended
+	return &synced, state
+}
+
+// userStates are the states of the google users whose AWS users are
+// deprovisioned, by external id and by lowercase email
+type userStates map[string]string
+
+// add sets the state of the google user, the first state set is kept
+func (st userStates) add(u *admin.User, state string) {
+	for _, key := range []string{externalID(u.Id), strings.ToLower(u.PrimaryEmail)} {
+		if _, ok := st[key]; key != "" && !ok {
+			st[key] = state
+		}
+	}
+}
+
+// get returns the state of the google user of the AWS user
+func (st userStates) get(u *aws.User) (string, bool) {
+	if u.ExternalID != "" {
+		if state, ok := st[u.ExternalID]; ok {
+			return state, true
+		}
+	}
+	state, ok := st[strings.ToLower(u.Username)]
+	return state, ok
+}
+
+// deprovisionGoogleUsers applies the policies of the suspended and archived
+// google users, it returns the users and group members synced and the
+// states of the users left out, whose AWS users are deleted
+func (s *syncGSuite) deprovisionGoogleUsers(googleUsers []*admin.User, googleGroupsUsers map[string][]*admin.User) ([]*admin.User, map[string][]*admin.User, userStates) {
+	states := userStates{}
+	synced := make(map[string]*admin.User, len(googleUsers))
+	users := make([]*admin.User, 0, len(googleUsers))
+	for _, u := range googleUsers {
+		su, state := s.syncedGoogleUser(u)
+		synced[u.PrimaryEmail] = su
+		if su == nil {
+			log.WithFields(log.Fields{"user": u.PrimaryEmail, "state": state}).Debug("not syncing user, its AWS user is deleted")
+			states.add(u, state)
+			continue
+		}
+		users = append(users, su)
+	}
+
+	if googleGroupsUsers == nil {
+		return users, nil, states
+	}
+	groupsUsers := make(map[string][]*admin.User, len(googleGroupsUsers))
+	for name, members := range googleGroupsUsers {
+		groupsUsers[name] = make([]*admin.User, 0, len(members))
+		for _, m := range members {
+			su, ok := synced[m.PrimaryEmail]
+			if !ok {
+				su, _ = s.syncedGoogleUser(m)
+			}
+			if su != nil {
+				groupsUsers[name] = append(groupsUsers[name], su)
+			}
+		}
+	}
+	return users, groupsUsers, states
+}
+
+// addDeletedGoogleUsers adds the google users deleted to the states, they
+// are only requested when their policy differs from the one of the users
+// removed from the groups synced
+func (s *syncGSuite) addDeletedGoogleUsers(ctx context.Context, states userStates, candidates []*aws.User) error {
+	if len(candidates) == 0 || s.cfg.DeletedUsers == s.cfg.RemovedUsers {
+		return nil
+	}
+
+	log.Debug("get deleted users")
+	deletedUsers, err := s.google.GetDeletedUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range deletedUsers {
+		states.add(u, userDeleted)
+	}
+	return nil
+}
+
+// deprovisioning holds the changes to the times the AWS users were first
+// found to delete or deactivate, kept in the datastore by AWS id. The
+// changes are planned with the others and only recorded when applied.
+type deprovisioning struct {
+	pending map[string]time.Time
+	set     map[string]time.Time
+	forget  []string
+}
+
+// newDeprovisioning returns the deprovisioning of the users pending in the
+// datastore
+func (s *syncGSuite) newDeprovisioning() (*deprovisioning, error) {
+	pending, err := s.ds.GetDeprovisionedUsers()
+	if err != nil {
+		return nil, err
+	}
+	return &deprovisioning{pending: pending, set: make(map[string]time.Time)}, nil
+}
+
+// track keeps since as the time the user was first found to deprovision,
+// a zero time forgets the user
+func (d *deprovisioning) track(id string, since time.Time) {
+	_, known := d.pending[id]
+	if since.IsZero() {
+		if known {
+			d.forget = append(d.forget, id)
+		}
+		return
+	}
+	if !known {
+		d.set[id] = since
+	}
+}
+
+// recordDeprovisioned keeps the times the users were first found to
+// deprovision in the datastore, and forgets the users given
+func (s *syncGSuite) recordDeprovisioned(set map[string]time.Time, forget []string) error {
+	for id, since := range set {
+		if err := s.ds.SetDeprovisionedUser(id, since); err != nil {
+			return err
+		}
+	}
+	for _, id := range forget {
+		if err := s.ds.DeleteDeprovisionedUser(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deprovisionAWSUsers applies the policies to the AWS users missing in
+// google, the users without state were removed from the groups synced. It
+// returns the users to delete and the users to deactivate.
+func (s *syncGSuite) deprovisionAWSUsers(dp *deprovisioning, awsUsers []*aws.User, candidates []*aws.User, states userStates) ([]*aws.User, []*aws.User) {
+	awsUsersByID := make(map[string]*aws.User, len(awsUsers))
+	for _, u := range awsUsers {
+		awsUsersByID[u.ID] = u
+	}
+
+	now := time.Now()
+	del := make([]*aws.User, 0, len(candidates))
+	deactivate := make([]*aws.User, 0)
+	for _, u := range candidates {
+		state, ok := states.get(u)
+		if !ok {
+			state = userRemoved
+		}
+		action, since := s.deprovisionAction(u, state, dp.pending, now)
+		dp.track(u.ID, since)
+		switch action {
+		case config.DeprovisionDelete:
+			del = append(del, u)
+		case config.DeprovisionDeactivate:
+			deactivated := *u
+			if full, ok := awsUsersByID[u.ID]; ok {
+				deactivated = *full
+			}
+			deactivated.Active = false
+			deactivate = append(deactivate, &deactivated)
+		}
+	}
+	return del, deactivate
+}
+
+// forgetDeprovisioned forgets the users to delete that are synced again or
+// don't exist anymore, candidates are the AWS users missing in google
+func (dp *deprovisioning) forgetDeprovisioned(awsUsers []*aws.User, candidates []*aws.User) {
+	missing := make(map[string]struct{}, len(candidates))
+	for _, u := range candidates {
+		missing[u.ID] = struct{}{}
+	}
+	existing := make(map[string]struct{}, len(awsUsers))
+	for _, u := range awsUsers {
+		existing[u.ID] = struct{}{}
+	}
+	for id := range dp.pending {
+		_, exists := existing[id]
+		if _, ok := missing[id]; ok && exists {
+			continue
+		}
+		dp.forget = append(dp.forget, id)
+	}
+	sort.Strings(dp.forget)
+}
+
+// deprovisionAction returns what is done now with the AWS user of a google
+// user in the state given: delete, deactivate or nothing, and since when
+// the user is to deprovision. A user to delete is kept until the grace
+// period elapsed since it was first found to delete, or deactivated first
+// with --deprovision-action deactivate and deleted once the grace period
+// elapsed, never without grace period. A zero time means the user is not
+// tracked anymore.
+func (s *syncGSuite) deprovisionAction(u *aws.User, state string, pending map[string]time.Time, now time.Time) (string, time.Time) {
+	log := log.WithFields(log.Fields{"user": u.Username, "state": state})
+
+	switch s.deprovisionPolicy(state) {
+	case config.DeprovisionDelete:
+		if !s.removableUser(u, "deleting user") {
+			return "", time.Time{}
+		}
+		deactivateFirst := s.cfg.DeprovisionAction == config.DeprovisionDeactivate
+		if !deactivateFirst && s.cfg.DeletionGracePeriod <= 0 {
+			return config.DeprovisionDelete, time.Time{}
+		}
+
+		since, ok := pending[u.ID]
+		if !ok {
+			since = now
+		}
+
+		deleteAfter := since.Add(s.cfg.DeletionGracePeriod)
+		if deactivateFirst {
+			if s.cfg.DeletionGracePeriod > 0 && !now.Before(deleteAfter) {
+				return config.DeprovisionDelete, since
+			}
+			if !u.Active {
+				return "", since
+			}
+			if s.cfg.DeletionGracePeriod > 0 {
+				log = log.WithField("delete_after", deleteAfter)
+			}
+			log.Info("deactivating user instead of deleting it")
+			return config.DeprovisionDeactivate, since
+		}
+
+		if now.Before(deleteAfter) {
+			log.WithField("delete_after", deleteAfter).Info("not deleting user yet, it is in the grace period")
+			return "", since
+		}
+		return config.DeprovisionDelete, since
+	case config.DeprovisionDeactivate:
+		if !u.Active || !s.removableUser(u, "deactivating user") {
+			return "", time.Time{}
+		}
+		return config.DeprovisionDeactivate, time.Time{}
+	default:
+		log.Debug("keeping user")
+		return "", time.Time{}
+	}
+}
+
+// deprovisionAWSUser deletes or deactivates the AWS user of a google user
+// in the state given as decided by deprovisionAction, and then records
+// since when the user is to deprovision
+func (s *syncGSuite) deprovisionAWSUser(ctx context.Context, u *aws.User, state string, action string, since time.Time, pending map[string]time.Time) error {
+	ll := log.WithFields(log.Fields{"user": u.Username, "state": state})
+	switch action {
+	case config.DeprovisionDeactivate:
+		deactivated := *u
+		deactivated.Active = false
+		if s.cfg.DryRun {
+			ll.Warn("dry run: would deactivate user")
+			return nil
+		}
+		ll.Info("deactivating user")
+		if _, err := s.aws.UpdateUser(ctx, &deactivated); err != nil {
+			return err
+		}
+	case config.DeprovisionDelete:
+		if s.cfg.DryRun {
+			ll.Warn("dry run: would delete user")
+			return nil
+		}
+		ll.Info("deleting user")
+		if err := s.deleteUser(ctx, u); err != nil {
+			return err
+		}
+		since = time.Time{}
+	}
+	if s.cfg.DryRun {
+		return nil
+	}
+
+	dp := &deprovisioning{pending: pending, set: make(map[string]time.Time)}
+	dp.track(u.ID, since)
+	return s.recordDeprovisioned(dp.set, dp.forget)
+}
@@ -0,0 +1,302 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestPlanDeprovision(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*config.Config)
+		prepare   func(*fakeGoogle)
+		deleted   []string
+		updated   []string
+		inactive  []string
+		added     []string
+	}{
+		{
+			name:    "removed users are deleted by default",
+			deleted: []string{"user-3@email.com"},
+			added:   []string{"user-1@email.com", "user-2@email.com"},
+		},
+		{
+			name:      "removed users are kept",
+			configure: func(cfg *config.Config) { cfg.RemovedUsers = config.DeprovisionKeep },
+			added:     []string{"user-1@email.com", "user-2@email.com"},
+		},
+		{
+			name:      "deleted users are deactivated",
+			configure: func(cfg *config.Config) { cfg.DeletedUsers = config.DeprovisionDeactivate },
+			prepare: func(g *fakeGoogle) {
+				g.deleted = []*admin.User{{Id: "guser-3", PrimaryEmail: "user-3@email.com"}}
+			},
+			updated:  []string{"user-3@email.com"},
+			inactive: []string{"user-3@email.com"},
+			added:    []string{"user-1@email.com", "user-2@email.com"},
+		},
+		{
+			name:     "suspended users are deactivated by default",
+			prepare:  func(g *fakeGoogle) { g.users[1].Suspended = true },
+			deleted:  []string{"user-3@email.com"},
+			updated:  []string{"user-2@email.com"},
+			inactive: []string{"user-2@email.com"},
+			added:    []string{"user-1@email.com", "user-2@email.com"},
+		},
+		{
+			name:      "suspended users are deleted and not added to groups",
+			configure: func(cfg *config.Config) { cfg.SuspendedUsers = config.DeprovisionDelete },
+			prepare:   func(g *fakeGoogle) { g.users[1].Suspended = true },
+			deleted:   []string{"user-2@email.com", "user-3@email.com"},
+			added:     []string{"user-1@email.com"},
+		},
+		{
+			name:      "suspended users are kept active",
+			configure: func(cfg *config.Config) { cfg.SuspendedUsers = config.DeprovisionKeep },
+			prepare:   func(g *fakeGoogle) { g.users[1].Suspended = true },
+			deleted:   []string{"user-3@email.com"},
+			added:     []string{"user-1@email.com", "user-2@email.com"},
+		},
+		{
+			name:      "archived users are deactivated",
+			configure: func(cfg *config.Config) { cfg.ArchivedUsers = config.DeprovisionDeactivate },
+			prepare:   func(g *fakeGoogle) { g.users[1].Archived = true },
+			deleted:   []string{"user-3@email.com"},
+			updated:   []string{"user-2@email.com"},
+			inactive:  []string{"user-2@email.com"},
+			added:     []string{"user-1@email.com", "user-2@email.com"},
+		},
+		{
+			name: "protected users are not deactivated",
+			configure: func(cfg *config.Config) {
+				cfg.RemovedUsers = config.DeprovisionDeactivate
+				cfg.ProtectedUsers = []string{"user-3@email.com"}
+			},
+			added: []string{"user-1@email.com", "user-2@email.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, g, _ := newTestSync()
+			if tt.configure != nil {
+				tt.configure(s.cfg)
+			}
+			s = New(s.cfg, s.aws, g, s.ds).(*syncGSuite)
+			if tt.prepare != nil {
+				tt.prepare(g)
+			}
+
+			p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			deleted := make([]string, 0)
+			for _, u := range p.DeleteUsers {
+				deleted = append(deleted, u.Username)
+			}
+			updated := make([]string, 0)
+			inactive := make([]string, 0)
+			for _, u := range p.UpdateUsers {
+				updated = append(updated, u.Username)
+				if !u.Active {
+					inactive = append(inactive, u.Username)
+				}
+			}
+			added := make([]string, 0)
+			for _, m := range p.AddMembers {
+				for _, u := range m.Users {
+					added = append(added, u.Username)
+				}
+			}
+			assert.ElementsMatch(t, tt.deleted, deleted, "deleted")
+			assert.ElementsMatch(t, tt.updated, updated, "updated")
+			assert.ElementsMatch(t, tt.inactive, inactive, "inactive")
+			assert.ElementsMatch(t, tt.added, added, "added")
+		})
+	}
+}
+
+func TestDeletionGracePeriod(t *testing.T) {
+	s, g, a := newTestSync()
+	s.cfg.DeletionGracePeriod = time.Hour
+	au3, _ := a.FindUserByEmail(context.Background(), "user-3@email.com")
+
+	// planning doesn't start the grace period, applying the plan does
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Empty(t, p.DeleteUsers)
+	assert.Contains(t, p.Deprovisioned, au3.ID)
+	pending, _ := s.ds.GetDeprovisionedUsers()
+	assert.Empty(t, pending)
+
+	assert.NoError(t, s.ApplyPlan(context.Background(), p))
+	pending, _ = s.ds.GetDeprovisionedUsers()
+	since, ok := pending[au3.ID]
+	assert.True(t, ok)
+
+	// user-3 is only deleted once the grace period elapsed
+	assert.NoError(t, s.SyncGroupsUsers(context.Background(), []string{""}))
+	assert.NotContains(t, a.calls, "DeleteUser user-3@email.com")
+	pending, _ = s.ds.GetDeprovisionedUsers()
+	assert.Equal(t, since, pending[au3.ID])
+
+	assert.NoError(t, s.ds.SetDeprovisionedUser(au3.ID, time.Now().Add(-2*time.Hour)))
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	if assert.Len(t, p.DeleteUsers, 1) {
+		assert.Equal(t, "user-3@email.com", p.DeleteUsers[0].Username)
+	}
+	assert.Empty(t, p.Deprovisioned)
+
+	// user-3 is back in google, it is forgotten once applied
+	u3 := g.addUser("name-3", "lastname-3", "user-3@email.com")
+	g.members["ggroup-1"] = append(g.members["ggroup-1"], &admin.Member{Id: u3.Id, Email: u3.PrimaryEmail, Type: "USER"})
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{au3.ID}, p.ForgetDeprovisioned)
+	pending, _ = s.ds.GetDeprovisionedUsers()
+	assert.NotEmpty(t, pending)
+
+	assert.NoError(t, s.SyncGroupsUsers(context.Background(), []string{""}))
+	pending, _ = s.ds.GetDeprovisionedUsers()
+	assert.Empty(t, pending)
+}
+
+func TestDeletionGracePeriodNotStarted(t *testing.T) {
+	s, _, a := newTestSync()
+	s.cfg.DeletionGracePeriod = time.Hour
+	au3, _ := a.FindUserByEmail(context.Background(), "user-3@email.com")
+
+	// a dry run doesn't start the grace period
+	s.cfg.DryRun = true
+	assert.NoError(t, s.SyncGroupsUsers(context.Background(), []string{""}))
+	pending, _ := s.ds.GetDeprovisionedUsers()
+	assert.Empty(t, pending)
+
+	// neither does a run refused by the deletion limits, removing user-3
+	// from Group-1 removes all its members
+	s.cfg.DryRun = false
+	s.cfg.MaxDeletionsPercent = 10
+	err := s.SyncGroupsUsers(context.Background(), []string{""})
+	assert.True(t, errors.Is(err, ErrDeletionLimit))
+	pending, _ = s.ds.GetDeprovisionedUsers()
+	assert.Empty(t, pending)
+	assert.Empty(t, a.calls)
+
+	// the times planned are kept in the plan file and recorded when applied
+	s.cfg.MaxDeletionsPercent = 0
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, p.Write(&buf))
+	p, err = ReadPlan(&buf)
+	assert.NoError(t, err)
+	assert.NoError(t, s.ApplyPlan(context.Background(), p))
+	pending, _ = s.ds.GetDeprovisionedUsers()
+	assert.Contains(t, pending, au3.ID)
+}
+
+func TestDeprovisionActionDeactivate(t *testing.T) {
+	s, _, a := newTestSync()
+	s.cfg.DeprovisionAction = config.DeprovisionDeactivate
+	s.cfg.DeletionGracePeriod = 7 * 24 * time.Hour
+	au3, _ := a.FindUserByEmail(context.Background(), "user-3@email.com")
+
+	// user-3 is deactivated instead of deleted, once
+	assert.NoError(t, s.SyncGroupsUsers(context.Background(), []string{""}))
+	assert.Contains(t, a.calls, "UpdateUser user-3@email.com")
+	assert.NotContains(t, a.calls, "DeleteUser user-3@email.com")
+	u, _ := a.FindUserByEmail(context.Background(), "user-3@email.com")
+	assert.False(t, u.Active)
+	pending, _ := s.ds.GetDeprovisionedUsers()
+	_, ok := pending[au3.ID]
+	assert.True(t, ok)
+
+	a.calls = nil
+	assert.NoError(t, s.SyncGroupsUsers(context.Background(), []string{""}))
+	assert.Empty(t, a.calls)
+
+	// and deleted after the grace period
+	assert.NoError(t, s.ds.SetDeprovisionedUser(au3.ID, time.Now().AddDate(0, 0, -8)))
+	assert.NoError(t, s.SyncGroupsUsers(context.Background(), []string{""}))
+	assert.Equal(t, []string{"DeleteUser user-3@email.com"}, a.calls)
+
+	// without grace period, the users deactivated are never deleted
+	s, _, a = newTestSync()
+	s.cfg.DeprovisionAction = config.DeprovisionDeactivate
+	au3, _ = a.FindUserByEmail(context.Background(), "user-3@email.com")
+	assert.NoError(t, s.ds.SetDeprovisionedUser(au3.ID, time.Now().AddDate(-1, 0, 0)))
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Empty(t, p.DeleteUsers)
+	if assert.Len(t, p.UpdateUsers, 1) {
+		assert.False(t, p.UpdateUsers[0].Active)
+	}
+}
+
+func TestSyncUsersDeprovision(t *testing.T) {
+	s, g, a := newTestSync()
+	s.cfg.DeletedUsers = config.DeprovisionDeactivate
+	s.cfg.SuspendedUsers = config.DeprovisionDelete
+
+	// user-3 is deleted in google, user-2 suspended
+	g.deleted = []*admin.User{{Id: "guser-3", PrimaryEmail: "user-3@email.com"}}
+	g.users[1].Suspended = true
+
+	assert.NoError(t, s.SyncUsers(context.Background(), ""))
+	assert.ElementsMatch(t, []string{
+		"UpdateUser user-3@email.com",
+		"DeleteUser user-2@email.com",
+		"CreateUser user-1@email.com",
+	}, a.calls)
+	au3, _ := a.FindUserByEmail(context.Background(), "user-3@email.com")
+	assert.False(t, au3.Active)
+
+	// user-2 is not synced, and user-3 is not deactivated again
+	a.calls = nil
+	assert.NoError(t, s.SyncUsers(context.Background(), ""))
+	assert.Empty(t, a.calls)
+	_, ok := s.users["user-2@email.com"]
+	assert.False(t, ok)
+}
+
+func TestValidateDeprovision(t *testing.T) {
+	cfg := newTestSyncConfig()
+	assert.NoError(t, validateDeprovision(cfg))
+
+	cfg.ArchivedUsers = "disable"
+	assert.True(t, errors.Is(validateDeprovision(cfg), ErrDeprovision))
+
+	cfg = newTestSyncConfig()
+	cfg.DeletionGracePeriod = -time.Hour
+	assert.True(t, errors.Is(validateDeprovision(cfg), ErrDeprovision))
+
+	cfg = newTestSyncConfig()
+	cfg.DeprovisionAction = config.DeprovisionDeactivate
+	cfg.DeletionGracePeriod = time.Hour
+	assert.NoError(t, validateDeprovision(cfg))
+	cfg.DeprovisionAction = config.DeprovisionKeep
+	assert.True(t, errors.Is(validateDeprovision(cfg), ErrDeprovision))
+}
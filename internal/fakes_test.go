@@ -0,0 +1,614 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/google"
+	admin "google.golang.org/api/admin/directory/v1"
+	reports "google.golang.org/api/admin/reports/v1"
+)
+
+// fakeAWS is an in memory aws.Client, it records every call that changes
+// the state so the tests can check what a sync did
+type fakeAWS struct {
+	mu      sync.Mutex
+	nextID  int
+	users   map[string]*aws.User
+	groups  map[string]*aws.Group
+	members map[string]map[string]bool
+	calls   []string
+
+	// membersNotListed makes GetGroupMemberIDs behave like an endpoint
+	// that does not return the members, probes counts IsUserInGroup calls
+	membersNotListed bool
+	probes           int
+
+	// noGroupExternalIDs makes the groups behave like an endpoint that
+	// does not keep their external id
+	noGroupExternalIDs bool
+}
+
+func newFakeAWS() *fakeAWS {
+	return &fakeAWS{
+		users:   make(map[string]*aws.User),
+		groups:  make(map[string]*aws.Group),
+		members: make(map[string]map[string]bool),
+	}
+}
+
+func (f *fakeAWS) id(prefix string) string {
+	f.nextID++
+	return fmt.Sprintf("%s-%d", prefix, f.nextID)
+}
+
+// addUser creates a user without recording the call, to prepare a test
+func (f *fakeAWS) addUser(u *aws.User) *aws.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	nu := *u
+	nu.ID = f.id("user")
+	f.users[nu.Username] = &nu
+	return &nu
+}
+
+// addGroup creates a group with its members without recording the call,
+// to prepare a test
+func (f *fakeAWS) addGroup(g *aws.Group, members ...*aws.User) *aws.Group {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ng := *g
+	ng.ID = f.id("group")
+	f.groups[ng.DisplayName] = &ng
+	f.members[ng.ID] = make(map[string]bool)
+	for _, u := range members {
+		f.members[ng.ID][u.ID] = true
+	}
+	return &ng
+}
+
+func (f *fakeAWS) record(format string, a ...interface{}) {
+	f.calls = append(f.calls, fmt.Sprintf(format, a...))
+}
+
+func (f *fakeAWS) AddUserToGroup(ctx context.Context, u *aws.User, g *aws.Group) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("AddUserToGroup %s %s", u.Username, g.DisplayName)
+	if f.members[g.ID] == nil {
+		f.members[g.ID] = make(map[string]bool)
+	}
+	f.members[g.ID][u.ID] = true
+	return nil
+}
+
+func (f *fakeAWS) AddUsersToGroup(ctx context.Context, users []*aws.User, g *aws.Group) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("AddUsersToGroup %s %s", usernames(users), g.DisplayName)
+	if f.members[g.ID] == nil {
+		f.members[g.ID] = make(map[string]bool)
+	}
+	for _, u := range users {
+		f.members[g.ID][u.ID] = true
+	}
+	return nil
+}
+
+func (f *fakeAWS) CreateGroup(ctx context.Context, g *aws.Group) (*aws.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CreateGroup %s", g.DisplayName)
+	if _, ok := f.groups[g.DisplayName]; ok {
+		return nil, &aws.SCIMError{Status: http.StatusConflict, ScimType: "uniqueness"}
+	}
+	ng := *g
+	ng.ID = f.id("group")
+	if f.noGroupExternalIDs {
+		ng.ExternalID = ""
+	}
+	f.groups[ng.DisplayName] = &ng
+	return &ng, nil
+}
+
+func (f *fakeAWS) CreateUser(ctx context.Context, u *aws.User) (*aws.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CreateUser %s", u.Username)
+	if _, ok := f.users[u.Username]; ok {
+		return nil, &aws.SCIMError{Status: http.StatusConflict, ScimType: "uniqueness"}
+	}
+	nu := *u
+	nu.ID = f.id("user")
+	f.users[nu.Username] = &nu
+	return &nu, nil
+}
+
+func (f *fakeAWS) DeleteGroup(ctx context.Context, g *aws.Group) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("DeleteGroup %s", g.DisplayName)
+	if _, ok := f.groups[g.DisplayName]; !ok {
+		return &aws.SCIMError{Status: http.StatusNotFound}
+	}
+	delete(f.groups, g.DisplayName)
+	delete(f.members, g.ID)
+	return nil
+}
+
+func (f *fakeAWS) DeleteUser(ctx context.Context, u *aws.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("DeleteUser %s", u.Username)
+	if _, ok := f.users[u.Username]; !ok {
+		return &aws.SCIMError{Status: http.StatusNotFound}
+	}
+	delete(f.users, u.Username)
+	return nil
+}
+
+func (f *fakeAWS) FindGroupByDisplayName(ctx context.Context, name string) (*aws.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if g, ok := f.groups[name]; ok {
+		return g, nil
+	}
+	return nil, aws.ErrGroupNotFound
+}
+
+func (f *fakeAWS) FindGroupByExternalID(ctx context.Context, id string) (*aws.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, g := range f.groups {
+		if g.ExternalID == id {
+			return g, nil
+		}
+	}
+	return nil, aws.ErrGroupNotFound
+}
+
+func (f *fakeAWS) FindGroupByID(ctx context.Context, id string) (*aws.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, g := range f.groups {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+	return nil, aws.ErrGroupNotFound
+}
+
+func (f *fakeAWS) FindUserByEmail(ctx context.Context, email string) (*aws.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[email]; ok {
+		return u, nil
+	}
+	return nil, aws.ErrUserNotFound
+}
+
+func (f *fakeAWS) FindUserByExternalID(ctx context.Context, id string) (*aws.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.ExternalID == id {
+			return u, nil
+		}
+	}
+	return nil, aws.ErrUserNotFound
+}
+
+func (f *fakeAWS) FindUserByID(ctx context.Context, id string) (*aws.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, aws.ErrUserNotFound
+}
+
+func (f *fakeAWS) GetUsers(ctx context.Context) ([]*aws.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]*aws.User, 0, len(f.users))
+	for _, u := range f.users {
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (f *fakeAWS) GetGroupMembers(ctx context.Context, g *aws.Group) ([]*aws.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]*aws.User, 0)
+	for _, u := range f.users {
+		if f.members[g.ID][u.ID] {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeAWS) GetGroupMemberIDs(ctx context.Context, g *aws.Group) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.membersNotListed {
+		return nil, aws.ErrMembersNotListed
+	}
+	ids := make([]string, 0, len(f.members[g.ID]))
+	for id := range f.members[g.ID] {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (f *fakeAWS) IsUserInGroup(ctx context.Context, u *aws.User, g *aws.Group) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probes++
+	return f.members[g.ID][u.ID], nil
+}
+
+func (f *fakeAWS) GetGroups(ctx context.Context) ([]*aws.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	groups := make([]*aws.Group, 0, len(f.groups))
+	for _, g := range f.groups {
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+func (f *fakeAWS) UpdateGroup(ctx context.Context, g *aws.Group) (*aws.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("UpdateGroup %s", g.DisplayName)
+	for name, old := range f.groups {
+		if old.ID == g.ID {
+			delete(f.groups, name)
+		}
+	}
+	ng := *g
+	if f.noGroupExternalIDs {
+		ng.ExternalID = ""
+	}
+	f.groups[ng.DisplayName] = &ng
+	return &ng, nil
+}
+
+func (f *fakeAWS) UpdateUser(ctx context.Context, u *aws.User) (*aws.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("UpdateUser %s", u.Username)
+	for name, old := range f.users {
+		if old.ID == u.ID {
+			delete(f.users, name)
+		}
+	}
+	nu := *u
+	f.users[nu.Username] = &nu
+	return &nu, nil
+}
+
+func (f *fakeAWS) RemoveUserFromGroup(ctx context.Context, u *aws.User, g *aws.Group) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("RemoveUserFromGroup %s %s", u.Username, g.DisplayName)
+	delete(f.members[g.ID], u.ID)
+	return nil
+}
+
+func (f *fakeAWS) RemoveUsersFromGroup(ctx context.Context, users []*aws.User, g *aws.Group) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("RemoveUsersFromGroup %s %s", usernames(users), g.DisplayName)
+	for _, u := range users {
+		delete(f.members[g.ID], u.ID)
+	}
+	return nil
+}
+
+// usernames joins the user names with commas, in the order given
+func usernames(users []*aws.User) string {
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	return strings.Join(names, ",")
+}
+
+// fakeGoogle is an in memory google.Client, queries are only supported
+// in the form used by the sync engine
+type fakeGoogle struct {
+	users   []*admin.User
+	deleted []*admin.User
+	groups  []*admin.Group
+	members map[string][]*admin.Member
+
+	orgUnits []*admin.OrgUnit
+
+	// activities are the admin activities, channels the channels watching
+	// them
+	activities []*reports.Activity
+	channels   []*reports.Channel
+
+	// userRequests counts the GetUser calls by key, memberRequests the
+	// GetGroupMembers calls by group id
+	mu             sync.Mutex
+	userRequests   map[string]int
+	memberRequests map[string]int
+
+	// userFailures is the number of GetUser calls failing by key
+	userFailures map[string]int
+}
+
+func newFakeGoogle() *fakeGoogle {
+	return &fakeGoogle{
+		members:        make(map[string][]*admin.Member),
+		userRequests:   make(map[string]int),
+		memberRequests: make(map[string]int),
+		userFailures:   make(map[string]int),
+	}
+}
+
+// addUser adds a user to the directory
+func (f *fakeGoogle) addUser(givenName, familyName, email string) *admin.User {
+	u := &admin.User{
+		Id:           fmt.Sprintf("guser-%d", len(f.users)+1),
+		PrimaryEmail: email,
+		Name: &admin.UserName{
+			GivenName:  givenName,
+			FamilyName: familyName,
+		},
+	}
+	f.users = append(f.users, u)
+	return u
+}
+
+// addGroup adds a group with the users given as members
+func (f *fakeGoogle) addGroup(name, email string, users ...*admin.User) *admin.Group {
+	g := &admin.Group{
+		Id:    fmt.Sprintf("ggroup-%d", len(f.groups)+1),
+		Name:  name,
+		Email: email,
+	}
+	f.groups = append(f.groups, g)
+	for _, u := range users {
+		f.members[g.Id] = append(f.members[g.Id], &admin.Member{Id: u.Id, Email: u.PrimaryEmail, Type: "USER"})
+	}
+	return g
+}
+
+// addOrgUnit adds an org unit to the directory and moves the users to it
+func (f *fakeGoogle) addOrgUnit(path string, users ...*admin.User) *admin.OrgUnit {
+	ou := &admin.OrgUnit{
+		OrgUnitId:   fmt.Sprintf("gou-%d", len(f.orgUnits)+1),
+		OrgUnitPath: path,
+		Name:        path[strings.LastIndex(path, "/")+1:],
+	}
+	f.orgUnits = append(f.orgUnits, ou)
+	for _, u := range users {
+		u.OrgUnitPath = path
+	}
+	return ou
+}
+
+func (f *fakeGoogle) GetUsers(ctx context.Context, query string) ([]*admin.User, error) {
+	if query == "" {
+		return f.users, nil
+	}
+	email := strings.TrimPrefix(query, "email:")
+	for _, u := range f.users {
+		if u.PrimaryEmail == email {
+			return []*admin.User{u}, nil
+		}
+	}
+	return []*admin.User{}, nil
+}
+
+func (f *fakeGoogle) GetUser(ctx context.Context, key string) (*admin.User, error) {
+	f.mu.Lock()
+	f.userRequests[key]++
+	failed := f.userFailures[key] > 0
+	if failed {
+		f.userFailures[key]--
+	}
+	f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if failed {
+		return nil, errors.New("server error")
+	}
+	for _, u := range f.users {
+		if u.PrimaryEmail == key || u.Id == key {
+			return u, nil
+		}
+	}
+	return nil, google.ErrUserNotFound
+}
+
+func (f *fakeGoogle) GetDeletedUsers(ctx context.Context) ([]*admin.User, error) {
+	return f.deleted, nil
+}
+
+func (f *fakeGoogle) GetGroups(ctx context.Context, query string) ([]*admin.Group, error) {
+	if query == "" {
+		return f.groups, nil
+	}
+	email := strings.TrimPrefix(query, "email=")
+	for _, g := range f.groups {
+		if g.Email == email {
+			return []*admin.Group{g}, nil
+		}
+	}
+	return []*admin.Group{}, nil
+}
+
+func (f *fakeGoogle) GetGroupMembers(ctx context.Context, g *admin.Group) ([]*admin.Member, error) {
+	f.mu.Lock()
+	f.memberRequests[g.Id]++
+	f.mu.Unlock()
+	return f.members[g.Id], nil
+}
+
+func (f *fakeGoogle) GetOrgUnits(ctx context.Context, orgUnitPath string, recursive bool) ([]*admin.OrgUnit, error) {
+	ous := make([]*admin.OrgUnit, 0)
+	for _, ou := range f.orgUnits {
+		if inOrgUnit(ou.OrgUnitPath, orgUnitPath, recursive) {
+			ous = append(ous, ou)
+		}
+	}
+	return ous, nil
+}
+
+func (f *fakeGoogle) GetOrgUnitUsers(ctx context.Context, orgUnitPath string, recursive bool) ([]*admin.User, error) {
+	users := make([]*admin.User, 0)
+	for _, u := range f.users {
+		if inOrgUnit(u.OrgUnitPath, orgUnitPath, recursive) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// nestGroup nests the child group in the parent group
+func (f *fakeGoogle) nestGroup(parent, child *admin.Group) {
+	f.members[parent.Id] = append(f.members[parent.Id], &admin.Member{Id: child.Id, Email: child.Email, Type: "GROUP"})
+}
+
+// walk visits the members of the group and of its nested groups, each
+// nested group once
+func (f *fakeGoogle) walk(g *admin.Group, visited map[string]bool, visit func(m *admin.Member)) {
+	visited[g.Id] = true
+	for _, m := range f.members[g.Id] {
+		visit(m)
+		if m.Type != "GROUP" || visited[m.Id] {
+			continue
+		}
+		for _, nested := range f.groups {
+			if nested.Id == m.Id {
+				f.walk(nested, visited, visit)
+			}
+		}
+	}
+}
+
+func (f *fakeGoogle) GetDirectAndIndirectGroupMemberUsers(ctx context.Context, g *admin.Group) ([]*admin.Member, error) {
+	users := make([]*admin.Member, 0)
+	seen := make(map[string]bool)
+	f.walk(g, make(map[string]bool), func(m *admin.Member) {
+		if m.Type != "GROUP" && !seen[m.Email] {
+			seen[m.Email] = true
+			users = append(users, m)
+		}
+	})
+	return users, nil
+}
+
+func (f *fakeGoogle) GetNestedGroups(ctx context.Context, g *admin.Group) ([]*admin.Group, error) {
+	groups := make([]*admin.Group, 0)
+	seen := map[string]bool{g.Id: true}
+	f.walk(g, make(map[string]bool), func(m *admin.Member) {
+		if m.Type != "GROUP" || seen[m.Id] {
+			return
+		}
+		seen[m.Id] = true
+		for _, nested := range f.groups {
+			if nested.Id == m.Id {
+				c := *nested
+				groups = append(groups, &c)
+			}
+		}
+	})
+	return groups, nil
+}
+
+func (f *fakeGoogle) GetGroup(ctx context.Context, key string) (*admin.Group, error) {
+	for _, g := range f.groups {
+		if g.Id == key || g.Email == key {
+			return g, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeGoogle) GetParentGroups(ctx context.Context, key string) ([]*admin.Group, error) {
+	groups := make([]*admin.Group, 0)
+	for _, g := range f.groups {
+		for _, m := range f.members[g.Id] {
+			if m.Email == key {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// addActivity adds an admin activity with an event of the type and name
+// given, the parameters are given as name, value pairs
+func (f *fakeGoogle) addActivity(at time.Time, eventType, name string, params ...string) *reports.Activity {
+	e := &reports.ActivityEvents{Type: eventType, Name: name}
+	for i := 0; i+1 < len(params); i += 2 {
+		e.Parameters = append(e.Parameters, &reports.ActivityEventsParameters{Name: params[i], Value: params[i+1]})
+	}
+	a := &reports.Activity{
+		Id:     &reports.ActivityId{Time: at.UTC().Format(time.RFC3339Nano)},
+		Events: []*reports.ActivityEvents{e},
+	}
+	f.activities = append(f.activities, a)
+	return a
+}
+
+func (f *fakeGoogle) GetActivities(ctx context.Context, since time.Time) ([]*reports.Activity, error) {
+	activities := make([]*reports.Activity, 0)
+	for _, a := range f.activities {
+		at, err := time.Parse(time.RFC3339Nano, a.Id.Time)
+		if err != nil {
+			return nil, err
+		}
+		if !at.Before(since) {
+			activities = append(activities, a)
+		}
+	}
+	return activities, nil
+}
+
+func (f *fakeGoogle) WatchActivities(ctx context.Context, channel *reports.Channel) (*reports.Channel, error) {
+	c := *channel
+	c.Expiration = time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	f.channels = append(f.channels, &c)
+	return &c, nil
+}
+
+func (f *fakeGoogle) StopWatching(ctx context.Context, channel *reports.Channel) error {
+	for i, c := range f.channels {
+		if c.Id == channel.Id {
+			f.channels = append(f.channels[:i], f.channels[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown channel %s", channel.Id)
+}
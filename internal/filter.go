@@ -0,0 +1,253 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrFilter is returned when a filter expression is invalid or fails
+var ErrFilter = errors.New("invalid filter")
+
+// filter is a CEL expression selecting the google users, groups or org
+// units synced. The fields of the resource are declared as variables with
+// syncMethod, fields that are not set are empty strings, 0, false or
+// empty objects. The expression
+// orgUnitPath.startsWith('/Engineering') && isEnforcedIn2Sv selects the
+// users of an organizational unit who use 2-step verification.
+type filter struct {
+	text     string
+	resource reflect.Type
+	program  cel.Program
+	err      error
+}
+
+// filterField is a json field of a resource declared in the filters
+type filterField struct {
+	name  string
+	index int
+}
+
+// newFilter compiles the filter expression of the resource given, e.g.
+// &admin.User{}, an empty one selects everything
+func newFilter(text string, resource interface{}) (*filter, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	t := reflect.TypeOf(resource)
+	declarations := []*exprpb.Decl{decls.NewVar("syncMethod", decls.String)}
+	for _, f := range filterFields(t) {
+		declarations = append(declarations, decls.NewVar(f.name, filterType(t.Elem().Field(f.index).Type)))
+	}
+	env, err := cel.NewEnv(cel.Declarations(declarations...))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrFilter, text, err)
+	}
+
+	ast, iss := env.Compile(text)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrFilter, text, iss.Err())
+	}
+	// a dyn result is checked when the filter is evaluated
+	if !proto.Equal(ast.ResultType(), decls.Bool) && !proto.Equal(ast.ResultType(), decls.Dyn) {
+		return nil, fmt.Errorf("%w: %q: result is not a boolean", ErrFilter, text)
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrFilter, text, err)
+	}
+	return &filter{text: text, resource: t, program: prg}, nil
+}
+
+// lenientFilter compiles the filter expression, an invalid one fails every
+// match so that nothing is synced by mistake
+func lenientFilter(text string, resource interface{}) *filter {
+	f, err := newFilter(text, resource)
+	if err != nil {
+		log.WithError(err).Warn("the filter selects nothing")
+		return &filter{text: text, err: err}
+	}
+	return f
+}
+
+// groupResource returns the resource the group filter selects with the
+// sync method
+func groupResource(syncMethod string) interface{} {
+	if syncMethod == config.SyncMethodOrgUnits {
+		return &admin.OrgUnit{}
+	}
+	return &admin.Group{}
+}
+
+// match reports whether the filter selects the google user, group or org
+// unit, the extra variables are added to its fields
+func (f *filter) match(object interface{}, vars map[string]interface{}) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	if f.err != nil {
+		return false, f.err
+	}
+
+	v := reflect.ValueOf(object)
+	if v.Type() != f.resource {
+		return false, fmt.Errorf("%w: %q: %T is not a %v", ErrFilter, f.text, object, f.resource)
+	}
+	if v.IsNil() {
+		v = reflect.New(f.resource.Elem())
+	}
+	activation := make(map[string]interface{}, len(vars))
+	for _, field := range filterFields(f.resource) {
+		value, err := filterValue(v.Elem().Field(field.index))
+		if err != nil {
+			return false, fmt.Errorf("%w: %q: %v", ErrFilter, f.text, err)
+		}
+		activation[field.name] = value
+	}
+	activation["syncMethod"] = ""
+	for k, value := range vars {
+		activation[k] = value
+	}
+
+	out, _, err := f.program.Eval(activation)
+	if err != nil {
+		return false, fmt.Errorf("%w: %q: %v", ErrFilter, f.text, err)
+	}
+	selected, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: %q: result %v is not a boolean", ErrFilter, f.text, out.Value())
+	}
+	return selected, nil
+}
+
+// filterFields returns the json fields of the struct pointed to by t
+func filterFields(t reflect.Type) []filterField {
+	var fields []filterField
+	st := t.Elem()
+	for i := 0; i < st.NumField(); i++ {
+		name := jsonName(st.Field(i))
+		if name == "" {
+			continue
+		}
+		fields = append(fields, filterField{name: name, index: i})
+	}
+	return fields
+}
+
+// jsonName returns the json name of the struct field, empty when it is
+// not serialized
+func jsonName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" || name == "" {
+		return ""
+	}
+	return name
+}
+
+// filterType returns the CEL type declared for a field of the go type
+func filterType(t reflect.Type) *exprpb.Type {
+	switch t.Kind() {
+	case reflect.String:
+		return decls.String
+	case reflect.Bool:
+		return decls.Bool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decls.Int
+	case reflect.Float32, reflect.Float64:
+		return decls.Double
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return decls.NewListType(decls.String)
+		}
+	case reflect.Struct:
+		return decls.NewMapType(decls.String, decls.Dyn)
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Struct {
+			return decls.NewMapType(decls.String, decls.Dyn)
+		}
+	}
+	return decls.Dyn
+}
+
+// filterValue converts a field to the value of its CEL type, the structs
+// are maps of their json fields with the ones not set
+func filterValue(v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			items := make([]string, v.Len())
+			for i := range items {
+				items[i] = v.Index(i).String()
+			}
+			return items, nil
+		}
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() == reflect.Struct {
+			if v.IsNil() {
+				return filterValue(reflect.Zero(v.Type().Elem()))
+			}
+			return filterValue(v.Elem())
+		}
+	case reflect.Struct:
+		m := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			name := jsonName(v.Type().Field(i))
+			if name == "" {
+				continue
+			}
+			value, err := filterValue(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			m[name] = value
+		}
+		return m, nil
+	}
+
+	// the other fields are the json values, e.g. lists of objects
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestFilter_Match(t *testing.T) {
+	u := &admin.User{
+		PrimaryEmail:    "jane@email.com",
+		OrgUnitPath:     "/Engineering/Platform",
+		IsEnforcedIn2Sv: true,
+		Name:            &admin.UserName{GivenName: "Jane"},
+		Organizations:   []interface{}{map[string]interface{}{"department": "R&D"}},
+	}
+	g := &admin.Group{Email: "aws-admins@email.com", DirectMembersCount: 42, Aliases: []string{"aws@email.com"}}
+	ou := &admin.OrgUnit{OrgUnitPath: "/Engineering", ParentOrgUnitPath: "/"}
+	vars := map[string]interface{}{"syncMethod": "groups"}
+
+	tests := []struct {
+		expr   string
+		object interface{}
+		want   bool
+	}{
+		{"", u, true},
+		{"orgUnitPath.startsWith('/Engineering') && isEnforcedIn2Sv", u, true},
+		{"orgUnitPath.endsWith(\"Sales\") || name.givenName == 'Jane'", u, true},
+		{"isAdmin == false && suspended != true", u, true},
+		{"isAdmin", u, false},
+		{"!isAdmin", u, true},
+		{"primaryEmail.contains('@') && size(organizations) == 1", u, true},
+		{"organizations[0].department == 'R&D'", u, true},
+		{"primaryEmail in ['john@email.com', 'jane@email.com']", u, true},
+		{"name.familyName == ''", u, true},
+		{"syncMethod == 'users_groups' || orgUnitPath == '/'", u, false},
+		{"email.matches('^aws-') && directMembersCount < 500", g, true},
+		{"directMembersCount >= 42 && directMembersCount <= 42", g, true},
+		{"email.matches('^aws-') && directMembersCount > 100", g, false},
+		{"email > 'aws' && email < 'b'", g, true},
+		{"'aws@email.com' in aliases && size(aliases) == 1", g, true},
+		{"(email.endsWith('@email.com') || false) && !(directMembersCount == 0)", g, true},
+		{"orgUnitPath.startsWith('/Engineering') && parentOrgUnitPath == '/' && !blockInheritance", ou, true},
+
+		// && binds tighter than ||, ! tighter than both
+		{"true || false && false", u, true},
+		{"(true || false) && false", u, false},
+		{"false && true || true", u, true},
+		{"!isAdmin && isAdmin", u, false},
+		{"!(isAdmin && isAdmin)", u, true},
+		{"directMembersCount + 8 * 2 == 58", g, true},
+
+		// escaping in the string literals and regular expressions
+		{"'it\\'s' == \"it's\"", u, true},
+		{"'a\\\\b'.size() == 3", u, true},
+		{"primaryEmail.matches('^jane@email\\\\.com$')", u, true},
+		{"primaryEmail.matches(r'^jane@email\\.com$')", u, true},
+		{"primaryEmail.matches(r'^jane.email\\.org$')", u, false},
+	}
+	for _, tt := range tests {
+		f, err := newFilter(tt.expr, resourceOf(tt.object))
+		if !assert.NoError(t, err, tt.expr) {
+			continue
+		}
+		got, err := f.match(tt.object, vars)
+		assert.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, got, tt.expr)
+	}
+}
+
+// resourceOf returns an empty resource of the type of the object
+func resourceOf(object interface{}) interface{} {
+	switch object.(type) {
+	case *admin.Group:
+		return &admin.Group{}
+	case *admin.OrgUnit:
+		return &admin.OrgUnit{}
+	}
+	return &admin.User{}
+}
+
+func TestFilterErrors(t *testing.T) {
+	u := &admin.User{PrimaryEmail: "jane@email.com"}
+	g := &admin.Group{Email: "aws-admins@email.com", DirectMembersCount: 42}
+
+	// syntax and type errors are found when the filter is compiled
+	for _, tt := range []struct {
+		expr     string
+		resource interface{}
+	}{
+		{"orgUnitPath ==", u},
+		{"(isAdmin", u},
+		{"primaryEmail == 'jane", u},
+		{"isAdmin isAdmin", u},
+		{"isAdmin and suspended", u},
+		{"email # 'x'", g},
+		{"primaryEmail.unknown('x')", u},
+		{"unknownField == ''", u},
+		{"email == ''", u},
+		{"primaryEmail", u},
+		{"size(primaryEmail)", u},
+		{"primaryEmail && true", u},
+		{"primaryEmail < 1", u},
+		{"primaryEmail in 'jane'", u},
+		{"directMembersCount <= '42'", g},
+		{"name.givenName == 1 || isAdmin == 'true'", u},
+		{"syncMethod == 1", g},
+	} {
+		_, err := newFilter(tt.expr, resourceOf(tt.resource))
+		assert.True(t, errors.Is(err, ErrFilter), tt.expr)
+	}
+
+	// errors of the values are found when the filter is evaluated
+	for _, tt := range []struct {
+		expr   string
+		object interface{}
+	}{
+		{"organizations[0].department == 'R&D'", u},
+		{"name.givenName", u},
+		{"1 / (directMembersCount - 42) == 0", g},
+		{"email.matches('[')", g},
+	} {
+		f, err := newFilter(tt.expr, resourceOf(tt.object))
+		if !assert.NoError(t, err, tt.expr) {
+			continue
+		}
+		_, err = f.match(tt.object, nil)
+		assert.True(t, errors.Is(err, ErrFilter), tt.expr)
+	}
+
+	// a filter only matches its resource
+	f, err := newFilter("isAdmin", &admin.User{})
+	if assert.NoError(t, err) {
+		_, err = f.match(g, nil)
+		assert.True(t, errors.Is(err, ErrFilter))
+	}
+
+	// an invalid filter fails every match
+	_, err = lenientFilter("(isAdmin", &admin.User{}).match(u, nil)
+	assert.True(t, errors.Is(err, ErrFilter))
+}
+
+func TestPlanFilters(t *testing.T) {
+	s, _, _ := newTestSync()
+	s.userFilter, _ = newFilter("primaryEmail != 'user-1@email.com'", &admin.User{})
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Empty(t, p.CreateUsers)
+	if assert.Len(t, p.AddMembers, 1) {
+		assert.Len(t, p.AddMembers[0].Users, 1)
+		assert.Equal(t, "user-2@email.com", p.AddMembers[0].Users[0].Username)
+	}
+
+	// the filter is evaluated per sync method
+	s.userFilter, _ = newFilter("syncMethod == 'groups'", &admin.User{})
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Len(t, p.CreateUsers, 1)
+
+	s.groupFilter, _ = newFilter("directMembersCount > 100", &admin.Group{})
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Empty(t, p.CreateGroups)
+	assert.Empty(t, p.AddMembers)
+
+	// a failing filter aborts the sync
+	s.groupFilter, _ = newFilter("email.matches('[')", &admin.Group{})
+	_, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.True(t, errors.Is(err, ErrFilter))
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package google
+
+import (
+	"context"
+	"time"
+
+	reports "google.golang.org/api/admin/reports/v1"
+)
+
+// activitiesApplication is the application of the activities of the
+// admins, the changes made to the users, groups and group members
+const activitiesApplication = "admin"
+
+// GetActivities will get the admin activities since the time given from
+// Google's Reports API, oldest first
+// References:
+// * https://developers.google.com/admin-sdk/reports/reference/rest/v1/activities/list
+// * https://developers.google.com/admin-sdk/reports/v1/appendix/activity/admin-user-settings
+// * https://developers.google.com/admin-sdk/reports/v1/appendix/activity/admin-group-settings
+func (c *client) GetActivities(ctx context.Context, since time.Time) ([]*reports.Activity, error) {
+	a := make([]*reports.Activity, 0)
+	err := c.reports.Activities.List("all", activitiesApplication).
+		StartTime(since.UTC().Format(time.RFC3339)).
+		Pages(ctx, func(activities *reports.Activities) error {
+			a = append(a, activities.Items...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// the activities are listed newest first
+	for i, j := 0, len(a)-1; i < j; i, j = i+1, j-1 {
+		a[i], a[j] = a[j], a[i]
+	}
+	return a, nil
+}
+
+// WatchActivities will subscribe the channel given to the admin
+// activities, Google posts them to the address of the channel until it
+// expires or is stopped
+// References:
+// * https://developers.google.com/admin-sdk/reports/v1/guides/push
+func (c *client) WatchActivities(ctx context.Context, channel *reports.Channel) (*reports.Channel, error) {
+	return c.reports.Activities.Watch("all", activitiesApplication, channel).Context(ctx).Do()
+}
+
+// StopWatching will stop the channel returned by WatchActivities
+func (c *client) StopWatching(ctx context.Context, channel *reports.Channel) error {
+	return c.reports.Channels.Stop(channel).Context(ctx).Do()
+}
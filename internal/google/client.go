@@ -17,32 +17,64 @@ package google
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2/google"
 	admin "google.golang.org/api/admin/directory/v1"
+	reports "google.golang.org/api/admin/reports/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+// ErrUserNotFound is returned when the user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
 // Client is the Interface for the Client
 type Client interface {
-	GetUsers(string) ([]*admin.User, error)
-	GetDeletedUsers() ([]*admin.User, error)
-	GetGroups(string) ([]*admin.Group, error)
-	GetGroupMembers(*admin.Group) ([]*admin.Member, error)
-	GetDirectAndIndirectGroupMemberUsers(*admin.Group) ([]*admin.Member, error)
+	GetUsers(context.Context, string) ([]*admin.User, error)
+	GetUser(context.Context, string) (*admin.User, error)
+	GetDeletedUsers(context.Context) ([]*admin.User, error)
+	GetGroups(context.Context, string) ([]*admin.Group, error)
+	GetGroup(context.Context, string) (*admin.Group, error)
+	GetParentGroups(context.Context, string) ([]*admin.Group, error)
+	GetGroupMembers(context.Context, *admin.Group) ([]*admin.Member, error)
+	GetDirectAndIndirectGroupMemberUsers(context.Context, *admin.Group) ([]*admin.Member, error)
+	GetNestedGroups(context.Context, *admin.Group) ([]*admin.Group, error)
+	GetOrgUnits(context.Context, string, bool) ([]*admin.OrgUnit, error)
+	GetOrgUnitUsers(context.Context, string, bool) ([]*admin.User, error)
+	GetActivities(context.Context, time.Time) ([]*reports.Activity, error)
+	WatchActivities(context.Context, *reports.Channel) (*reports.Channel, error)
+	StopWatching(context.Context, *reports.Channel) error
 }
 
 type client struct {
-	ctx     context.Context
 	service *admin.Service
+
+	// reports lists the admin activities, it needs
+	// reports.AdminReportsAuditReadonlyScope
+	reports *reports.Service
+
+	// customFieldMask lists the custom schemas returned with the users
+	customFieldMask string
+
+	// graph resolves the nested groups
+	graph *groupGraph
 }
 
-// NewClient creates a new client for Google's Admin API
-func NewClient(ctx context.Context, adminEmail string, serviceAccountKey []byte) (Client, error) {
-	config, err := google.JWTConfigFromJSON(serviceAccountKey, admin.AdminDirectoryGroupReadonlyScope,
+// NewClient creates a new client for Google's Admin API, the users are
+// returned with the fields of the custom schemas given. The extra scopes
+// are requested on top of the groups, members and users ones, like
+// admin.AdminDirectoryOrgunitReadonlyScope to list the org units or
+// reports.AdminReportsAuditReadonlyScope to list the admin activities.
+func NewClient(ctx context.Context, adminEmail string, serviceAccountKey []byte, customSchemas []string, extraScopes ...string) (Client, error) {
+	scopes := append([]string{admin.AdminDirectoryGroupReadonlyScope,
 		admin.AdminDirectoryGroupMemberReadonlyScope,
-		admin.AdminDirectoryUserReadonlyScope)
+		admin.AdminDirectoryUserReadonlyScope}, extraScopes...)
+	config, err := google.JWTConfigFromJSON(serviceAccountKey, scopes...)
 
 	config.Subject = adminEmail
 
@@ -57,16 +89,25 @@ func NewClient(ctx context.Context, adminEmail string, serviceAccountKey []byte)
 		return nil, err
 	}
 
-	return &client{
-		ctx:     ctx,
-		service: srv,
-	}, nil
+	rep, err := reports.NewService(ctx, option.WithTokenSource(ts))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		service:         srv,
+		reports:         rep,
+		customFieldMask: strings.Join(customSchemas, ","),
+	}
+	c.graph = newGroupGraph(c.listMembers, c.getGroup)
+
+	return c, nil
 }
 
 // GetDeletedUsers will get the deleted users from the Google's Admin API.
-func (c *client) GetDeletedUsers() ([]*admin.User, error) {
+func (c *client) GetDeletedUsers(ctx context.Context) ([]*admin.User, error) {
 	u := make([]*admin.User, 0)
-	err := c.service.Users.List().Customer("my_customer").ShowDeleted("true").Pages(c.ctx, func(users *admin.Users) error {
+	err := c.service.Users.List().Customer("my_customer").ShowDeleted("true").Pages(ctx, func(users *admin.Users) error {
 		u = append(u, users.Users...)
 		return nil
 	})
@@ -74,10 +115,32 @@ func (c *client) GetDeletedUsers() ([]*admin.User, error) {
 	return u, err
 }
 
-// GetGroupMembers will get the members of the group specified
-func (c *client) GetGroupMembers(g *admin.Group) ([]*admin.Member, error) {
+// GetGroupMembers will get the members of the group specified.
+// The members are requested once per client and shared with the nested
+// groups resolution.
+func (c *client) GetGroupMembers(ctx context.Context, g *admin.Group) ([]*admin.Member, error) {
+	return c.graph.directMembers(ctx, g.Id)
+}
+
+// GetDirectAndIndirectGroupMemberUsers will get the members of the group
+// specified that are not groups, with the ones of the groups nested in it
+// at any depth, once each. The nested groups are requested once per client
+// and the ones the customer can't read or nested in themselves are skipped.
+func (c *client) GetDirectAndIndirectGroupMemberUsers(ctx context.Context, g *admin.Group) ([]*admin.Member, error) {
+	return c.graph.users(ctx, g)
+}
+
+// GetNestedGroups will get the groups nested in the group specified, at any
+// depth, once each
+func (c *client) GetNestedGroups(ctx context.Context, g *admin.Group) ([]*admin.Group, error) {
+	return c.graph.nested(ctx, g)
+}
+
+// listMembers will get the direct members of the group with the id or
+// email given
+func (c *client) listMembers(ctx context.Context, groupKey string) ([]*admin.Member, error) {
 	m := make([]*admin.Member, 0)
-	err := c.service.Members.List(g.Id).Pages(c.ctx, func(members *admin.Members) error {
+	err := c.service.Members.List(groupKey).Pages(ctx, func(members *admin.Members) error {
 		m = append(m, members.Members...)
 		return nil
 	})
@@ -85,32 +148,29 @@ func (c *client) GetGroupMembers(g *admin.Group) ([]*admin.Member, error) {
 	return m, err
 }
 
-// GetDirectAndIndirectGroupMemberUsers will recursively get the users of the group specified
-func (c *client) GetDirectAndIndirectGroupMemberUsers(g *admin.Group) ([]*admin.Member, error) {
-	u := make([]*admin.Member, 0)
-	err := c.service.Members.List(g.Id).Pages(context.TODO(), func(members *admin.Members) error {
-		for _, m := range members.Members {
-			if m.Type == "GROUP" {
-				q := fmt.Sprintf("email=%s", m.Email)
-				g, err := c.GetGroups(q)
-				if err != nil {
-					return err
-				}
-
-				c, err := c.GetDirectAndIndirectGroupMemberUsers(g[0])
-				if err != nil {
-					return err
-				}
-
-				u = append(u, c...)
-			} else {
-				u = append(u, m)
-			}
-		}
+// GetGroup will get the group with the id or email given, nil when the
+// customer can't read it. The group is requested once per client.
+func (c *client) GetGroup(ctx context.Context, groupKey string) (*admin.Group, error) {
+	return c.graph.group(ctx, groupKey)
+}
+
+// GetParentGroups will get the groups the group or user with the email
+// given is a direct member of
+// References:
+// * https://developers.google.com/admin-sdk/directory/reference/rest/v1/groups/list
+func (c *client) GetParentGroups(ctx context.Context, memberKey string) ([]*admin.Group, error) {
+	g := make([]*admin.Group, 0)
+	err := c.service.Groups.List().UserKey(memberKey).Pages(ctx, func(groups *admin.Groups) error {
+		g = append(g, groups.Groups...)
 		return nil
 	})
 
-	return u, err
+	return g, err
+}
+
+// getGroup will get the group with the id or email given
+func (c *client) getGroup(ctx context.Context, groupKey string) (*admin.Group, error) {
+	return c.service.Groups.Get(groupKey).Context(ctx).Do()
 }
 
 // GetUsers will get the users from Google's Admin API
@@ -126,23 +186,45 @@ func (c *client) GetDirectAndIndirectGroupMemberUsers(g *admin.Group) ([]*admin.
 //  manager='janesmith@example.com'
 //  orgName=Engineering orgTitle:Manager
 //  EmploymentData.projects:'GeneGnomes'
-func (c *client) GetUsers(query string) ([]*admin.User, error) {
+// The fields of the custom schemas of the client are requested with
+// projection=custom and customFieldMask.
+func (c *client) GetUsers(ctx context.Context, query string) ([]*admin.User, error) {
 	u := make([]*admin.User, 0)
-	var err error
 
+	call := c.service.Users.List().Customer("my_customer")
 	if query != "" {
-		err = c.service.Users.List().Query(query).Customer("my_customer").Pages(c.ctx, func(users *admin.Users) error {
-			u = append(u, users.Users...)
-			return nil
-		})
+		call = call.Query(query)
+	}
+	if c.customFieldMask != "" {
+		call = call.Projection("custom").CustomFieldMask(c.customFieldMask)
+	}
 
-	} else {
-		err = c.service.Users.List().Customer("my_customer").Pages(c.ctx, func(users *admin.Users) error {
-			u = append(u, users.Users...)
-			return nil
-		})
+	err := call.Pages(ctx, func(users *admin.Users) error {
+		u = append(u, users.Users...)
+		return nil
+	})
+
+	return u, err
+}
+
+// GetUser will get the user with the email or id given from Google's Admin
+// API, ErrUserNotFound is returned when it does not exist, like for the
+// members of groups that are not users of the customer.
+// The fields of the custom schemas of the client are requested with
+// projection=custom and customFieldMask.
+// References:
+// * https://developers.google.com/admin-sdk/directory/reference/rest/v1/users/get
+func (c *client) GetUser(ctx context.Context, userKey string) (*admin.User, error) {
+	call := c.service.Users.Get(userKey)
+	if c.customFieldMask != "" {
+		call = call.Projection("custom").CustomFieldMask(c.customFieldMask)
 	}
 
+	u, err := call.Context(ctx).Do()
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+		return nil, ErrUserNotFound
+	}
 	return u, err
 }
 
@@ -159,21 +241,86 @@ func (c *client) GetUsers(query string) ([]*admin.User, error) {
 //  name:contact* email:contact*
 //  name:Admin* email:aws-*
 //  email:aws-*
-func (c *client) GetGroups(query string) ([]*admin.Group, error) {
+func (c *client) GetGroups(ctx context.Context, query string) ([]*admin.Group, error) {
 	g := make([]*admin.Group, 0)
 	var err error
 
 	if query != "" {
-		err = c.service.Groups.List().Customer("my_customer").Query(query).Pages(c.ctx, func(groups *admin.Groups) error {
+		err = c.service.Groups.List().Customer("my_customer").Query(query).Pages(ctx, func(groups *admin.Groups) error {
 			g = append(g, groups.Groups...)
 			return nil
 		})
 	} else {
-		err = c.service.Groups.List().Customer("my_customer").Pages(c.ctx, func(groups *admin.Groups) error {
+		err = c.service.Groups.List().Customer("my_customer").Pages(ctx, func(groups *admin.Groups) error {
 			g = append(g, groups.Groups...)
 			return nil
 		})
 
 	}
+
+	// the groups listed are not requested again when they are nested
+	for _, group := range g {
+		c.graph.addGroup(group)
+	}
 	return g, err
 }
+
+// GetOrgUnits will get the org unit with the path given from Google's Admin
+// API and, when recursive, all the org units below it.
+// The root org unit "/" has no resource, it is returned with the id of the
+// parent of its children.
+// References:
+// * https://developers.google.com/admin-sdk/directory/reference/rest/v1/orgunits/list
+func (c *client) GetOrgUnits(ctx context.Context, orgUnitPath string, recursive bool) ([]*admin.OrgUnit, error) {
+	var ou *admin.OrgUnit
+	if orgUnitPath == "/" {
+		children, err := c.service.Orgunits.List("my_customer").OrgUnitPath("/").Type("children").Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		ou = &admin.OrgUnit{Name: "/", OrgUnitPath: "/"}
+		if len(children.OrganizationUnits) > 0 {
+			ou.OrgUnitId = children.OrganizationUnits[0].ParentOrgUnitId
+		}
+	} else {
+		var err error
+		ou, err = c.service.Orgunits.Get("my_customer", strings.TrimPrefix(orgUnitPath, "/")).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ous := []*admin.OrgUnit{ou}
+	if !recursive {
+		return ous, nil
+	}
+
+	below, err := c.service.Orgunits.List("my_customer").OrgUnitPath(orgUnitPath).Type("all").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ous, below.OrganizationUnits...), nil
+}
+
+// GetOrgUnitUsers will get the users of the org unit with the path given
+// from Google's Admin API, with the users of the org units below it when
+// recursive. The users are queried with orgUnitPath, which matches the
+// whole org unit tree.
+// References:
+// * https://developers.google.com/admin-sdk/directory/v1/guides/search-users
+func (c *client) GetOrgUnitUsers(ctx context.Context, orgUnitPath string, recursive bool) ([]*admin.User, error) {
+	q := fmt.Sprintf("orgUnitPath='%s'", strings.ReplaceAll(orgUnitPath, "'", "\\'"))
+	users, err := c.GetUsers(ctx, q)
+	if err != nil || recursive {
+		return users, err
+	}
+
+	u := make([]*admin.User, 0, len(users))
+	for _, user := range users {
+		if strings.EqualFold(user.OrgUnitPath, orgUnitPath) {
+			u = append(u, user)
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package google
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// groupGraph resolves the groups nested in groups, the members and the
+// groups of each group are requested by one call at a time and shared by
+// the concurrent calls. Only the requests that succeeded are kept, a failed
+// one is retried by the next call. Nested groups the customer can't read,
+// like the groups of other domains, are skipped.
+type groupGraph struct {
+	listMembers func(ctx context.Context, groupKey string) ([]*admin.Member, error)
+	getGroup    func(ctx context.Context, groupKey string) (*admin.Group, error)
+
+	mu      sync.Mutex
+	members map[string]*graphEntry
+	groups  map[string]*graphEntry
+}
+
+// graphEntry is a request made by one call at a time, holding lock, until
+// it succeeds
+type graphEntry struct {
+	lock    chan struct{}
+	done    bool
+	members []*admin.Member
+	group   *admin.Group
+}
+
+func newGroupGraph(
+	listMembers func(ctx context.Context, groupKey string) ([]*admin.Member, error),
+	getGroup func(ctx context.Context, groupKey string) (*admin.Group, error),
+) *groupGraph {
+	return &groupGraph{
+		listMembers: listMembers,
+		getGroup:    getGroup,
+		members:     make(map[string]*graphEntry),
+		groups:      make(map[string]*graphEntry),
+	}
+}
+
+// entry returns the entry of the key in the cache, created when missing
+func (gg *groupGraph) entry(cache map[string]*graphEntry, key string) *graphEntry {
+	gg.mu.Lock()
+	defer gg.mu.Unlock()
+	e, ok := cache[key]
+	if !ok {
+		e = &graphEntry{lock: make(chan struct{}, 1)}
+		cache[key] = e
+	}
+	return e
+}
+
+// load makes the request of the entry unless one succeeded already, the
+// call gives up waiting for the others when its context is done
+func (e *graphEntry) load(ctx context.Context, request func() error) error {
+	select {
+	case e.lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-e.lock }()
+
+	if e.done {
+		return nil
+	}
+	if err := request(); err != nil {
+		return err
+	}
+	e.done = true
+	return nil
+}
+
+// directMembers returns the direct members of the group, nil when the
+// group can't be read
+func (gg *groupGraph) directMembers(ctx context.Context, groupKey string) ([]*admin.Member, error) {
+	e := gg.entry(gg.members, groupKey)
+	err := e.load(ctx, func() error {
+		members, err := gg.listMembers(ctx, groupKey)
+		if foreign(err) {
+			log.WithField("group", groupKey).WithError(err).Warn("skipping group, its members can't be read")
+			members, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		e.members = members
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e.members, nil
+}
+
+// group returns the group, nil when it can't be read
+func (gg *groupGraph) group(ctx context.Context, groupKey string) (*admin.Group, error) {
+	e := gg.entry(gg.groups, groupKey)
+	err := e.load(ctx, func() error {
+		g, err := gg.getGroup(ctx, groupKey)
+		if foreign(err) {
+			log.WithField("group", groupKey).WithError(err).Warn("skipping nested group, it can't be read")
+			g, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		e.group = g
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e.group, nil
+}
+
+// addGroup records a group listed otherwise, it is copied as the caller
+// may rename it
+func (gg *groupGraph) addGroup(g *admin.Group) {
+	c := *g
+	e := gg.entry(gg.groups, g.Id)
+	_ = e.load(context.Background(), func() error {
+		e.group = &c
+		return nil
+	})
+}
+
+// users returns the members of the group that are not groups, with the
+// ones of the groups nested in it, once each
+func (gg *groupGraph) users(ctx context.Context, g *admin.Group) ([]*admin.Member, error) {
+	users := make([]*admin.Member, 0)
+	seen := make(map[string]struct{})
+	err := gg.walk(ctx, g, func(m *admin.Member) {
+		key := memberKey(m)
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			users = append(users, m)
+		}
+	}, nil)
+	return users, err
+}
+
+// nested returns the groups nested in the group, at any depth, once each
+func (gg *groupGraph) nested(ctx context.Context, g *admin.Group) ([]*admin.Group, error) {
+	groups := make([]*admin.Group, 0)
+	err := gg.walk(ctx, g, nil, func(nested *admin.Group) {
+		// the groups are shared by the calls, the caller may rename them
+		c := *nested
+		groups = append(groups, &c)
+	})
+	return groups, err
+}
+
+// walk visits the members and the nested groups of the group, each nested
+// group once, a group nested in itself is only logged
+func (gg *groupGraph) walk(ctx context.Context, g *admin.Group, member func(*admin.Member), group func(*admin.Group)) error {
+	visited := map[string]struct{}{g.Id: {}}
+
+	var visit func(groupKey string, path []string) error
+	visit = func(groupKey string, path []string) error {
+		members, err := gg.directMembers(ctx, groupKey)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range members {
+			if m.Type != "GROUP" {
+				if member != nil {
+					member(m)
+				}
+				continue
+			}
+
+			key := m.Id
+			if key == "" {
+				key = m.Email
+			}
+			if contains(path, key) {
+				log.WithField("group", g.Email).WithField("cycle", strings.Join(append(path, m.Email), " -> ")).Warn("skipping nested group, it is nested in itself")
+				continue
+			}
+			if _, ok := visited[key]; ok {
+				continue
+			}
+			visited[key] = struct{}{}
+
+			if group != nil {
+				nested, err := gg.group(ctx, key)
+				if err != nil {
+					return err
+				}
+				if nested == nil {
+					continue
+				}
+				group(nested)
+			}
+
+			if err := visit(key, append(path[:len(path):len(path)], key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return visit(g.Id, []string{g.Id})
+}
+
+// foreign reports whether the error is returned for a group the customer
+// can't read
+func foreign(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusForbidden || gerr.Code == http.StatusNotFound
+	}
+	return false
+}
+
+// memberKey identifies a member, by its email as users are matched by
+// email
+func memberKey(m *admin.Member) string {
+	if m.Email != "" {
+		return strings.ToLower(m.Email)
+	}
+	return m.Id
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package google
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeDirectory is a directory of groups for the group graph, it counts
+// the requests made
+type fakeDirectory struct {
+	mu       sync.Mutex
+	groups   map[string]*admin.Group
+	members  map[string][]*admin.Member
+	requests map[string]int
+
+	// failures is the number of requests failing by request
+	failures map[string]int
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		groups:   make(map[string]*admin.Group),
+		members:  make(map[string][]*admin.Member),
+		requests: make(map[string]int),
+		failures: make(map[string]int),
+	}
+}
+
+func (d *fakeDirectory) addGroup(id string, members ...*admin.Member) *admin.Group {
+	g := &admin.Group{Id: id, Email: id + "@email.com", Name: id}
+	d.groups[id] = g
+	d.members[id] = members
+	return g
+}
+
+func user(email string) *admin.Member {
+	return &admin.Member{Id: "id-" + email, Email: email, Type: "USER"}
+}
+
+func group(id string) *admin.Member {
+	return &admin.Member{Id: id, Email: id + "@email.com", Type: "GROUP"}
+}
+
+func (d *fakeDirectory) listMembers(ctx context.Context, groupKey string) ([]*admin.Member, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests["members "+groupKey]++
+	if err := d.fail(ctx, "members "+groupKey); err != nil {
+		return nil, err
+	}
+	if groupKey == "broken" {
+		return nil, &googleapi.Error{Code: http.StatusInternalServerError}
+	}
+	members, ok := d.members[groupKey]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusForbidden}
+	}
+	return members, nil
+}
+
+func (d *fakeDirectory) getGroup(ctx context.Context, groupKey string) (*admin.Group, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests["group "+groupKey]++
+	if err := d.fail(ctx, "group "+groupKey); err != nil {
+		return nil, err
+	}
+	g, ok := d.groups[groupKey]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound}
+	}
+	return g, nil
+}
+
+// fail returns the error of the request when it fails, d.mu is held
+func (d *fakeDirectory) fail(ctx context.Context, request string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d.failures[request] > 0 {
+		d.failures[request]--
+		return &googleapi.Error{Code: http.StatusServiceUnavailable}
+	}
+	return nil
+}
+
+func TestGroupGraph_Users(t *testing.T) {
+	d := newFakeDirectory()
+	// a -> b -> c -> a is a cycle, u1 is in a and c, external is in
+	// another domain
+	a := d.addGroup("a", user("u1@email.com"), group("b"), group("external"))
+	d.addGroup("b", user("u2@email.com"), group("c"))
+	d.addGroup("c", user("U1@email.com"), user("u3@email.com"), group("a"))
+	gg := newGroupGraph(d.listMembers, d.getGroup)
+
+	users, err := gg.users(context.Background(), a)
+	assert.NoError(t, err)
+	var emails []string
+	for _, m := range users {
+		emails = append(emails, m.Email)
+	}
+	assert.Equal(t, []string{"u1@email.com", "u2@email.com", "u3@email.com"}, emails)
+
+	// the members of every group are requested once
+	_, err = gg.users(context.Background(), d.groups["b"])
+	assert.NoError(t, err)
+	for key, n := range d.requests {
+		assert.Equal(t, 1, n, key)
+	}
+
+	_, err = gg.users(context.Background(), d.addGroup("d", group("broken")))
+	var gerr *googleapi.Error
+	assert.True(t, errors.As(err, &gerr))
+}
+
+func TestGroupGraph_Nested(t *testing.T) {
+	d := newFakeDirectory()
+	a := d.addGroup("a", user("u1@email.com"), group("b"), group("c"), group("external"))
+	d.addGroup("b", group("c"), group("a"))
+	d.addGroup("c", user("u2@email.com"))
+	gg := newGroupGraph(d.listMembers, d.getGroup)
+
+	nested, err := gg.nested(context.Background(), a)
+	assert.NoError(t, err)
+	if assert.Len(t, nested, 2) {
+		assert.Equal(t, "b", nested[0].Id)
+		assert.Equal(t, "c", nested[1].Id)
+	}
+
+	// the groups returned can be renamed by the caller
+	nested[0].Name = "renamed"
+	nested, err = gg.nested(context.Background(), a)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", nested[0].Name)
+}
+
+func TestGroupGraph_Concurrent(t *testing.T) {
+	d := newFakeDirectory()
+	d.addGroup("shared", user("u1@email.com"))
+	var groups []*admin.Group
+	for _, id := range []string{"a", "b", "c", "d"} {
+		groups = append(groups, d.addGroup(id, group("shared")))
+	}
+	gg := newGroupGraph(d.listMembers, d.getGroup)
+
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *admin.Group) {
+			defer wg.Done()
+			users, err := gg.users(context.Background(), g)
+			assert.NoError(t, err)
+			assert.Len(t, users, 1)
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, d.requests["members shared"])
+}
+
+func TestGroupGraph_AddGroup(t *testing.T) {
+	d := newFakeDirectory()
+	a := d.addGroup("a", group("b"))
+	b := d.addGroup("b", user("u1@email.com"))
+	gg := newGroupGraph(d.listMembers, d.getGroup)
+
+	// a group already listed is not requested when it is nested
+	gg.addGroup(b)
+	b.Name = "renamed"
+	nested, err := gg.nested(context.Background(), a)
+	assert.NoError(t, err)
+	if assert.Len(t, nested, 1) {
+		assert.Equal(t, "b", nested[0].Name)
+	}
+	assert.Zero(t, d.requests["group b"])
+}
+
+func TestGroupGraph_Retries(t *testing.T) {
+	d := newFakeDirectory()
+	a := d.addGroup("a", user("u1@email.com"), group("b"))
+	d.addGroup("b", user("u2@email.com"))
+	gg := newGroupGraph(d.listMembers, d.getGroup)
+
+	// the failed requests are not cached, the next calls retry them
+	d.failures["members b"] = 1
+	d.failures["group b"] = 1
+	_, err := gg.users(context.Background(), a)
+	assert.Error(t, err)
+	_, err = gg.nested(context.Background(), a)
+	assert.Error(t, err)
+
+	users, err := gg.users(context.Background(), a)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+	nested, err := gg.nested(context.Background(), a)
+	assert.NoError(t, err)
+	assert.Len(t, nested, 1)
+	assert.Equal(t, map[string]int{"members a": 1, "members b": 2, "group b": 2}, d.requests)
+
+	// a canceled call does not fail the others
+	c := d.addGroup("c", user("u3@email.com"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = gg.users(ctx, c)
+	assert.True(t, errors.Is(err, context.Canceled))
+	users, err = gg.users(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+}
@@ -0,0 +1,437 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+	reports "google.golang.org/api/admin/reports/v1"
+)
+
+// ErrIncremental is returned when the incremental mode is unknown or can't
+// be used with the sync method
+var ErrIncremental = errors.New("invalid incremental mode")
+
+const (
+	// checkpointKey is the key of the time of the last incremental sync in
+	// the datastore state, the activities since then are synced next
+	checkpointKey = "incremental_checkpoint"
+	// fullSyncKey is the key of the time of the last full sync in the
+	// datastore state
+	fullSyncKey = "incremental_full_sync"
+)
+
+// activityLag is subtracted from the checkpoint when the activities are
+// polled, the reports API lists them some minutes after they happen and
+// syncing an activity twice makes no change
+const activityLag = 10 * time.Minute
+
+// validateIncremental checks the incremental mode, it only works with the
+// groups sync method
+func validateIncremental(cfg *config.Config) error {
+	switch cfg.Incremental {
+	case "", config.IncrementalNone:
+		return nil
+	case config.IncrementalPoll, config.IncrementalPush:
+	default:
+		return fmt.Errorf("%w: %q, expected %s, %s or %s", ErrIncremental, cfg.Incremental, config.IncrementalNone, config.IncrementalPoll, config.IncrementalPush)
+	}
+
+	if cfg.SyncMethod != config.DefaultSyncMethod {
+		return fmt.Errorf("%w: %s only works when --sync-method '%s'", ErrIncremental, cfg.Incremental, config.DefaultSyncMethod)
+	}
+	return nil
+}
+
+// incremental reports whether only the changes since the checkpoint are
+// synced
+func incremental(cfg *config.Config) bool {
+	return cfg.Incremental == config.IncrementalPoll || cfg.Incremental == config.IncrementalPush
+}
+
+// SyncIncremental syncs the google users and groups changed by the admin
+// activities since the checkpoint kept in the datastore. A full sync is run
+// instead on the first run, every FullSyncInterval and when the changes
+// can't be synced alone, like the deletion of a group.
+func (s *syncGSuite) SyncIncremental(ctx context.Context, queries []string) error {
+	return s.syncIncremental(ctx, queries, func(since time.Time) ([]*reports.Activity, error) {
+		log.WithField("since", since).Info("get google admin activities")
+		return s.google.GetActivities(ctx, since.Add(-activityLag))
+	})
+}
+
+// SyncActivities syncs the google users and groups changed by the admin
+// activities given, pushed by google, like SyncIncremental
+func (s *syncGSuite) SyncActivities(ctx context.Context, queries []string, activities []*reports.Activity) error {
+	return s.syncIncremental(ctx, queries, func(time.Time) ([]*reports.Activity, error) {
+		return activities, nil
+	})
+}
+
+func (s *syncGSuite) syncIncremental(ctx context.Context, queries []string, activities func(since time.Time) ([]*reports.Activity, error)) error {
+	start := time.Now().UTC()
+
+	checkpoint, err := s.stateTime(checkpointKey)
+	if err != nil {
+		return err
+	}
+	fullSync, err := s.stateTime(fullSyncKey)
+	if err != nil {
+		return err
+	}
+
+	var reason string
+	switch {
+	case checkpoint.IsZero():
+		reason = "no checkpoint"
+	case s.cfg.FullSyncInterval > 0 && start.Sub(fullSync) >= s.cfg.FullSyncInterval:
+		reason = "full sync interval elapsed"
+	default:
+		a, err := activities(checkpoint)
+		if err != nil {
+			return err
+		}
+
+		c := newChanges(a)
+		if c.empty() {
+			log.Info("no google changes since the checkpoint")
+			return s.setStateTime(checkpointKey, start)
+		}
+
+		var plan *Plan
+		plan, reason, err = s.planChanges(ctx, queries, c)
+		if err != nil {
+			return err
+		}
+		if plan != nil {
+			if err := s.syncPlan(ctx, plan); err != nil {
+				return err
+			}
+			return s.setStateTime(checkpointKey, start)
+		}
+	}
+
+	log.WithField("reason", reason).Info("running a full sync")
+	if err := s.SyncGroupsUsers(ctx, queries); err != nil {
+		return err
+	}
+	if err := s.setStateTime(fullSyncKey, start); err != nil {
+		return err
+	}
+	return s.setStateTime(checkpointKey, start)
+}
+
+// planChanges computes the changes SyncGroupsUsers would make for the
+// google users and groups changed. The plan is nil when a full sync is
+// needed instead, for the reason returned.
+func (s *syncGSuite) planChanges(ctx context.Context, queries []string, c *changes) (*Plan, string, error) {
+	if c.full != "" {
+		return nil, c.full, nil
+	}
+
+	// users and groups share the members, a group added to or removed from
+	// a group changes the nesting
+	for email := range c.members {
+		u, err := s.googleUsers.get(ctx, email)
+		if err != nil {
+			return nil, "", err
+		}
+		if u != nil {
+			continue
+		}
+		g, err := s.google.GetGroup(ctx, email)
+		if err != nil {
+			return nil, "", err
+		}
+		if g != nil {
+			return nil, "nesting of groups changed", nil
+		}
+	}
+
+	googleGroups, err := s.changedGroups(ctx, queries, c.groups)
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.WithFields(log.Fields{"users": len(c.users), "groups": len(googleGroups)}).Info("syncing google changes")
+	googleUsers, googleGroupsUsers, err := s.getGoogleGroupsAndUsers(ctx, googleGroups)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the users changed outside of the groups are only updated, they are
+	// created by the sync of their groups
+	sc := &scope{
+		deletedUsers:  make(map[string]struct{}),
+		existingUsers: make(map[string]struct{}),
+	}
+	members := make(map[string]struct{}, len(googleUsers))
+	for _, u := range googleUsers {
+		members[strings.ToLower(u.PrimaryEmail)] = struct{}{}
+	}
+	for email := range c.users {
+		if _, ok := members[email]; ok {
+			continue
+		}
+		u, err := s.googleUsers.get(ctx, email)
+		if err != nil {
+			return nil, "", err
+		}
+		if u == nil {
+			sc.deletedUsers[email] = struct{}{}
+			continue
+		}
+		if s.ignoreUser(u.PrimaryEmail) {
+			continue
+		}
+		ok, err := s.selectUser(u)
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			continue
+		}
+		sc.existingUsers[strings.ToLower(u.PrimaryEmail)] = struct{}{}
+		googleUsers = append(googleUsers, u)
+	}
+
+	plan, err := s.plan(ctx, googleUsers, googleGroups, googleGroupsUsers, true, sc)
+	return plan, "", err
+}
+
+// changedGroups returns the groups synced affected by the changes of the
+// groups with the emails given: the groups themselves and, as the members
+// of nested groups are members of the groups they are nested in, the
+// groups they are nested in. When the nesting is mirrored, a group nested
+// in a group synced is synced.
+func (s *syncGSuite) changedGroups(ctx context.Context, queries []string, emails map[string]struct{}) ([]*admin.Group, error) {
+	if len(emails) == 0 {
+		return []*admin.Group{}, nil
+	}
+
+	listed, err := s.listGroups(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+	synced := make(map[string]*admin.Group, len(listed))
+	for _, g := range listed {
+		synced[strings.ToLower(g.Email)] = g
+	}
+
+	changed := make(map[string]*admin.Group)
+	for email := range emails {
+		ancestors, err := s.ancestors(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+
+		nestedInSynced := false
+		for _, a := range ancestors {
+			if g, ok := synced[strings.ToLower(a.Email)]; ok {
+				nestedInSynced = true
+				if !s.mirrorNesting() {
+					changed[g.Id] = g
+				}
+			}
+		}
+
+		if g, ok := synced[email]; ok {
+			changed[g.Id] = g
+		} else if nestedInSynced && s.mirrorNesting() {
+			g, err := s.google.GetGroup(ctx, email)
+			if err != nil {
+				return nil, err
+			}
+			if g != nil {
+				changed[g.Id] = g
+			}
+		}
+	}
+
+	groups := make([]*admin.Group, 0, len(changed))
+	for _, g := range changed {
+		groups = append(groups, g)
+	}
+	return s.filterGroups(groups)
+}
+
+// ancestors returns the groups the group with the email given is nested
+// in, at any depth, once each
+func (s *syncGSuite) ancestors(ctx context.Context, email string) ([]*admin.Group, error) {
+	ancestors := make([]*admin.Group, 0)
+	visited := map[string]struct{}{email: {}}
+	queue := []string{email}
+	for len(queue) > 0 {
+		parents, err := s.google.GetParentGroups(ctx, queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+
+		for _, p := range parents {
+			key := strings.ToLower(p.Email)
+			if _, ok := visited[key]; ok {
+				continue
+			}
+			visited[key] = struct{}{}
+			ancestors = append(ancestors, p)
+			queue = append(queue, key)
+		}
+	}
+	return ancestors, nil
+}
+
+// stateTime returns the time kept in the datastore state with the key
+// given, zero when it is not set
+func (s *syncGSuite) stateTime(key string) (time.Time, error) {
+	value, err := s.ds.GetState(key)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		log.WithField("key", key).WithError(err).Warn("ignoring invalid time in the datastore state")
+		return time.Time{}, nil
+	}
+	return t, nil
+}
+
+func (s *syncGSuite) setStateTime(key string, t time.Time) error {
+	return s.ds.SetState(key, t.UTC().Format(time.RFC3339Nano))
+}
+
+// scope restricts a plan to the google users and groups changed since the
+// checkpoint of the incremental sync. Only the members of the groups given
+// are compared, no group is deleted, the users are only deleted when they
+// were deleted in google.
+type scope struct {
+	// deletedUsers are the emails of the users deleted in google
+	deletedUsers map[string]struct{}
+	// existingUsers are the emails of the users changed outside of the
+	// groups given, they are updated but not created
+	existingUsers map[string]struct{}
+}
+
+// users returns the users of the scope to create and to delete, only the
+// users deleted in google and the ones deprovisioned for their own state
+// are deleted
+func (sc *scope) users(add []*aws.User, del []*aws.User, states userStates) ([]*aws.User, []*aws.User) {
+	created := make([]*aws.User, 0, len(add))
+	for _, u := range add {
+		if _, ok := sc.existingUsers[strings.ToLower(u.Username)]; !ok {
+			created = append(created, u)
+		}
+	}
+
+	for email := range sc.deletedUsers {
+		states[email] = userDeleted
+	}
+	deleted := make([]*aws.User, 0)
+	for _, u := range del {
+		if _, ok := states.get(u); ok {
+			deleted = append(deleted, u)
+		}
+	}
+	return created, deleted
+}
+
+// groups returns the AWS groups whose members are compared, the ones of
+// the google groups of the scope, and the groups to delete, none
+func (sc *scope) groups(awsGroups []*aws.Group, update []*aws.Group, equals []*aws.Group) ([]*aws.Group, []*aws.Group) {
+	ids := make(map[string]struct{}, len(update)+len(equals))
+	for _, g := range append(append([]*aws.Group{}, update...), equals...) {
+		ids[g.ID] = struct{}{}
+	}
+
+	listed := make([]*aws.Group, 0, len(ids))
+	for _, g := range awsGroups {
+		if _, ok := ids[g.ID]; ok {
+			listed = append(listed, g)
+		}
+	}
+	return listed, nil
+}
+
+// changes are the google users and groups changed by admin activities, by
+// lowercase email
+type changes struct {
+	users  map[string]struct{}
+	groups map[string]struct{}
+	// members are the members added to or removed from the groups, users
+	// or groups
+	members map[string]struct{}
+	// full is the reason to run a full sync, when the changes can't be
+	// synced alone
+	full string
+}
+
+// newChanges returns the changes made by the admin activities, see
+// https://developers.google.com/admin-sdk/reports/v1/appendix/activity/admin-user-settings
+// and https://developers.google.com/admin-sdk/reports/v1/appendix/activity/admin-group-settings
+func newChanges(activities []*reports.Activity) *changes {
+	c := &changes{
+		users:   make(map[string]struct{}),
+		groups:  make(map[string]struct{}),
+		members: make(map[string]struct{}),
+	}
+
+	add := func(set map[string]struct{}, email string) {
+		if email != "" {
+			set[strings.ToLower(email)] = struct{}{}
+		}
+	}
+
+	for _, a := range activities {
+		for _, e := range a.Events {
+			params := make(map[string]string, len(e.Parameters))
+			for _, p := range e.Parameters {
+				params[p.Name] = p.Value
+			}
+
+			switch e.Type {
+			case "USER_SETTINGS":
+				add(c.users, params["USER_EMAIL"])
+				if e.Name == "RENAME_USER" {
+					add(c.users, params["NEW_VALUE"])
+				}
+			case "GROUP_SETTINGS":
+				add(c.groups, params["GROUP_EMAIL"])
+				switch e.Name {
+				case "DELETE_GROUP":
+					c.full = "group " + params["GROUP_EMAIL"] + " deleted"
+				case "CHANGE_GROUP_EMAIL":
+					add(c.groups, params["NEW_VALUE"])
+				case "ADD_GROUP_MEMBER", "REMOVE_GROUP_MEMBER", "UPDATE_GROUP_MEMBER":
+					add(c.members, params["USER_EMAIL"])
+				}
+			}
+		}
+	}
+
+	return c
+}
+
+// empty reports whether no user or group changed
+func (c *changes) empty() bool {
+	return len(c.users) == 0 && len(c.groups) == 0 && c.full == ""
+}
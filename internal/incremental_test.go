@@ -0,0 +1,191 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/awslabs/ssosync/internal/datastore"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestSyncIncremental(t *testing.T) {
+	s, g, a := newTestSync()
+	s.cfg.Incremental = config.IncrementalPoll
+	ds := s.ds
+
+	// each run has its own google users cache
+	run := func() {
+		t.Helper()
+		a.calls = nil
+		s := New(s.cfg, a, g, ds)
+		assert.NoError(t, s.SyncIncremental(context.Background(), []string{""}))
+	}
+
+	// the first run is a full sync
+	run()
+	assert.Equal(t, []string{
+		"DeleteUser user-3@email.com",
+		"CreateUser user-1@email.com",
+		"AddUsersToGroup user-1@email.com,user-2@email.com Group-1",
+		"RemoveUsersFromGroup user-3@email.com Group-1",
+	}, a.calls)
+	checkpoint, _ := ds.GetState(checkpointKey)
+	fullSync, _ := ds.GetState(fullSyncKey)
+	assert.NotEmpty(t, checkpoint)
+	assert.Equal(t, checkpoint, fullSync)
+
+	// without activity nothing is synced, Group-2 is only created by the
+	// next full sync
+	g.addGroup("Group-2", "group-2@email.com", g.users[0])
+	run()
+	assert.Empty(t, a.calls)
+
+	// a user added to Group-1 is created and added to it
+	u4 := g.addUser("name-4", "lastname-4", "user-4@email.com")
+	u4.Id = "guser-4"
+	g.members["ggroup-1"] = append(g.members["ggroup-1"], &admin.Member{Id: u4.Id, Email: u4.PrimaryEmail, Type: "USER"})
+	g.addActivity(time.Now(), "GROUP_SETTINGS", "ADD_GROUP_MEMBER", "GROUP_EMAIL", "group-1@email.com", "USER_EMAIL", "user-4@email.com")
+	run()
+	assert.Equal(t, []string{
+		"CreateUser user-4@email.com",
+		"AddUsersToGroup user-4@email.com Group-1",
+	}, a.calls)
+
+	// a user deleted in google is deleted, a renamed one is updated
+	g.users = g.users[1:]
+	g.members["ggroup-1"] = g.members["ggroup-1"][1:]
+	g.members["ggroup-2"] = nil
+	g.users[0].Name.GivenName = "renamed-2"
+	g.activities = nil
+	g.addActivity(time.Now(), "USER_SETTINGS", "DELETE_USER", "USER_EMAIL", "user-1@email.com")
+	g.addActivity(time.Now(), "USER_SETTINGS", "CHANGE_FIRST_NAME", "USER_EMAIL", "user-2@email.com")
+	run()
+	assert.Equal(t, []string{
+		"DeleteUser user-1@email.com",
+		"UpdateUser user-2@email.com",
+	}, a.calls)
+
+	// the full sync interval elapsed
+	g.activities = nil
+	assert.NoError(t, ds.SetState(fullSyncKey, time.Now().Add(-25*time.Hour).Format(time.RFC3339Nano)))
+	run()
+	assert.Equal(t, []string{"CreateGroup Group-2"}, a.calls)
+}
+
+func TestPlanChanges(t *testing.T) {
+	s, g, _ := newTestSync()
+	g.addUser("name-4", "lastname-4", "user-4@email.com").Id = "guser-4"
+	g.addGroup("Group-2", "group-2@email.com", g.users[2])
+	now := time.Now()
+
+	g.addActivity(now, "GROUP_SETTINGS", "ADD_GROUP_MEMBER", "GROUP_EMAIL", "group-2@email.com", "USER_EMAIL", "user-4@email.com")
+	p, reason, err := s.planChanges(context.Background(), []string{""}, newChanges(g.activities))
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	assert.Equal(t, "users: 1 to create, 0 to update, 0 to delete; groups: 1 to create, 0 to update, 0 to delete; members: 1 to add, 0 to remove", p.Summary())
+
+	// AWS user-3 is only deleted by a full sync
+	assert.Empty(t, p.DeleteUsers)
+
+	// a user changed outside of the groups synced is not created
+	g.activities = nil
+	g.addActivity(now, "USER_SETTINGS", "CHANGE_LAST_NAME", "USER_EMAIL", "user-1@email.com")
+	p, _, err = s.planChanges(context.Background(), []string{"email=group-2@email.com"}, newChanges(g.activities))
+	assert.NoError(t, err)
+	assert.True(t, p.Empty())
+
+	// the nesting and the deleted groups need a full sync
+	g.nestGroup(g.groups[0], g.groups[1])
+	g.activities = nil
+	g.addActivity(now, "GROUP_SETTINGS", "ADD_GROUP_MEMBER", "GROUP_EMAIL", "group-1@email.com", "USER_EMAIL", "group-2@email.com")
+	p, reason, err = s.planChanges(context.Background(), []string{""}, newChanges(g.activities))
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+	assert.Equal(t, "nesting of groups changed", reason)
+
+	g.activities = nil
+	g.addActivity(now, "GROUP_SETTINGS", "DELETE_GROUP", "GROUP_EMAIL", "group-3@email.com")
+	p, reason, err = s.planChanges(context.Background(), []string{""}, newChanges(g.activities))
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+	assert.Equal(t, "group group-3@email.com deleted", reason)
+}
+
+func TestChangedGroups(t *testing.T) {
+	g := newFakeGoogle()
+	u := g.addUser("name-1", "lastname-1", "user-1@email.com")
+	parent := g.addGroup("Parent", "parent@email.com")
+	child := g.addGroup("Child", "child@email.com", u)
+	g.nestGroup(parent, child)
+	g.addGroup("Other", "other@email.com")
+
+	s := New(newTestSyncConfig(), newFakeAWS(), g, datastore.NewNullDatastore()).(*syncGSuite)
+	changed := map[string]struct{}{"child@email.com": {}}
+
+	// the members of the child are members of the parent
+	groups, err := s.changedGroups(context.Background(), []string{"email=parent@email.com"}, changed)
+	assert.NoError(t, err)
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "Parent", groups[0].Name)
+	}
+
+	// the child is synced as a group of its own
+	s.cfg.NestedGroups = config.NestedGroupsMirror
+	groups, err = s.changedGroups(context.Background(), []string{"email=parent@email.com"}, changed)
+	assert.NoError(t, err)
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "Child", groups[0].Name)
+	}
+
+	groups, err = s.changedGroups(context.Background(), []string{"email=other@email.com"}, changed)
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestNewChanges(t *testing.T) {
+	g := newFakeGoogle()
+	now := time.Now()
+	g.addActivity(now, "USER_SETTINGS", "RENAME_USER", "USER_EMAIL", "Old@email.com", "NEW_VALUE", "new@email.com")
+	g.addActivity(now, "GROUP_SETTINGS", "REMOVE_GROUP_MEMBER", "GROUP_EMAIL", "group-1@email.com", "USER_EMAIL", "user-1@email.com")
+	g.addActivity(now, "GROUP_SETTINGS", "CHANGE_GROUP_EMAIL", "GROUP_EMAIL", "group-2@email.com", "NEW_VALUE", "group-3@email.com")
+	g.addActivity(now, "DOMAIN_SETTINGS", "CHANGE_DOMAIN_NAME", "DOMAIN_NAME", "email.com")
+
+	c := newChanges(g.activities)
+	assert.Equal(t, map[string]struct{}{"old@email.com": {}, "new@email.com": {}}, c.users)
+	assert.Equal(t, map[string]struct{}{"group-1@email.com": {}, "group-2@email.com": {}, "group-3@email.com": {}}, c.groups)
+	assert.Equal(t, map[string]struct{}{"user-1@email.com": {}}, c.members)
+	assert.Empty(t, c.full)
+	assert.False(t, c.empty())
+	assert.True(t, newChanges(nil).empty())
+}
+
+func TestValidateIncremental(t *testing.T) {
+	cfg := newTestSyncConfig()
+	assert.NoError(t, validateIncremental(cfg))
+	cfg.Incremental = config.IncrementalPoll
+	assert.NoError(t, validateIncremental(cfg))
+	cfg.Incremental = "always"
+	assert.True(t, errors.Is(validateIncremental(cfg), ErrIncremental))
+
+	cfg.Incremental = config.IncrementalPush
+	cfg.SyncMethod = config.SyncMethodOrgUnits
+	assert.True(t, errors.Is(validateIncremental(cfg), ErrIncremental))
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// ErrGroupName is returned when the AWS name of a google group is invalid
+var ErrGroupName = errors.New("invalid group name")
+
+// groupNameFuncs are the functions of the group name template
+var groupNameFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix string, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix string, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old string, new string, s string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+// groupNameData is the google group as seen by the group name template
+type groupNameData struct {
+	ID             string
+	Name           string
+	Email          string
+	EmailLocalPart string
+	EmailDomain    string
+}
+
+// groupNameRewrite replaces the matches of a regular expression in the
+// AWS names of the groups
+type groupNameRewrite struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// groupNamer computes the AWS names of the google groups from a template,
+// then rewrites them
+type groupNamer struct {
+	// template is nil when the sync method names the groups
+	template *template.Template
+	rewrites []groupNameRewrite
+}
+
+// newGroupNamer parses the group name template and the rewrites, given as
+// regexp=replacement
+func newGroupNamer(text string, rewrites []string) (*groupNamer, error) {
+	n := &groupNamer{}
+
+	if strings.TrimSpace(text) != "" {
+		t, err := template.New("group-name").Funcs(groupNameFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: template: %v", ErrGroupName, err)
+		}
+		n.template = t
+	}
+
+	for _, rewrite := range rewrites {
+		if rewrite == "" {
+			continue
+		}
+		i := strings.Index(rewrite, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("%w: rewrite %q is not regexp=replacement", ErrGroupName, rewrite)
+		}
+		re, err := regexp.Compile(rewrite[:i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: rewrite %q: %v", ErrGroupName, rewrite, err)
+		}
+		n.rewrites = append(n.rewrites, groupNameRewrite{re: re, replacement: rewrite[i+1:]})
+	}
+
+	return n, nil
+}
+
+// name returns the AWS name of the google group, defaultName is the name
+// given by the sync method when there is no template. " and " is replaced
+// by " & " after the rewrites, AWS SSO fails to parse the requests about
+// the groups containing it.
+func (n *groupNamer) name(g *admin.Group, defaultName string) (string, error) {
+	name := defaultName
+	if n.template != nil {
+		localPart, domain := g.Email, ""
+		if i := strings.LastIndex(g.Email, "@"); i >= 0 {
+			localPart, domain = g.Email[:i], g.Email[i+1:]
+		}
+
+		var b strings.Builder
+		err := n.template.Execute(&b, groupNameData{
+			ID:             g.Id,
+			Name:           g.Name,
+			Email:          g.Email,
+			EmailLocalPart: localPart,
+			EmailDomain:    domain,
+		})
+		if err != nil {
+			return "", fmt.Errorf("%w: group %s: %v", ErrGroupName, g.Email, err)
+		}
+		name = b.String()
+	}
+
+	for _, rewrite := range n.rewrites {
+		name = rewrite.re.ReplaceAllString(name, rewrite.replacement)
+	}
+	name = strings.ReplaceAll(name, " and ", " & ")
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: group %s has an empty name", ErrGroupName, g.Email)
+	}
+	return name, nil
+}
+
+// names returns the AWS names of the google groups, in the same order.
+// Two groups can't have the same name, one would replace the other.
+func (n *groupNamer) names(groups []*admin.Group, defaultName func(g *admin.Group) string) ([]string, error) {
+	names := make([]string, len(groups))
+	emails := make(map[string]string)
+	for i, g := range groups {
+		name, err := n.name(g, defaultName(g))
+		if err != nil {
+			return nil, err
+		}
+		if email, ok := emails[name]; ok {
+			return nil, fmt.Errorf("%w: groups %s and %s are both named %q", ErrGroupName, email, g.Email, name)
+		}
+		emails[name] = g.Email
+		names[i] = name
+	}
+	return names, nil
+}
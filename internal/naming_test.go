@@ -0,0 +1,142 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestGroupNamer_Name(t *testing.T) {
+	g := &admin.Group{Id: "ggroup-1", Name: "Admins and Ops", Email: "Ops-Admins@email.com"}
+
+	tests := []struct {
+		name     string
+		template string
+		rewrites []string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "default name",
+			want: "Admins & Ops",
+		},
+		{
+			name:     "template",
+			template: "aws-{{ .EmailLocalPart | lower }}",
+			want:     "aws-ops-admins",
+		},
+		{
+			name:     "template functions",
+			template: `{{ .Name | replace " and " "+" | upper }}@{{ .EmailDomain | trimSuffix ".com" }}`,
+			want:     "ADMINS+OPS@email",
+		},
+		{
+			name:     "rewrites in order",
+			template: "{{ .Email }}",
+			rewrites: []string{"@.*$=", "^Ops-(.*)$=${1}-Ops"},
+			want:     "Admins-Ops",
+		},
+		{
+			name:     "custom rewrites keep and replaced",
+			rewrites: []string{"^Admins=Owners", "&=and"},
+			want:     "Owners & Ops",
+		},
+		{
+			name:     "template keeps and replaced",
+			template: "{{ .Name }} and {{ .EmailDomain }}",
+			want:     "Admins & Ops & email.com",
+		},
+		{
+			name:     "unknown field",
+			template: "{{ .Unknown }}",
+			wantErr:  true,
+		},
+		{
+			name:     "empty name",
+			rewrites: []string{".*="},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := newGroupNamer(tt.template, tt.rewrites)
+			assert.NoError(t, err)
+
+			got, err := n.name(g, g.Name)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrGroupName))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewGroupNamerErrors(t *testing.T) {
+	for _, tt := range []struct {
+		template string
+		rewrites []string
+	}{
+		{template: "{{ .Name "},
+		{rewrites: []string{"no-separator"}},
+		{rewrites: []string{"=empty"}},
+		{rewrites: []string{"(=x"}},
+	} {
+		_, err := newGroupNamer(tt.template, tt.rewrites)
+		assert.True(t, errors.Is(err, ErrGroupName), "%v", tt)
+	}
+}
+
+func TestGroupNamer_NamesConflict(t *testing.T) {
+	n, err := newGroupNamer("{{ .EmailLocalPart }}", nil)
+	assert.NoError(t, err)
+
+	groups := []*admin.Group{
+		{Name: "Group-1", Email: "group@one.com"},
+		{Name: "Group-2", Email: "group@two.com"},
+	}
+	_, err = n.names(groups, func(g *admin.Group) string { return g.Name })
+	assert.True(t, errors.Is(err, ErrGroupName))
+
+	names, err := n.names(groups[:1], func(g *admin.Group) string { return g.Name })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group"}, names)
+}
+
+func TestPlanGroupNameTemplate(t *testing.T) {
+	s, _, a := newTestSync()
+	s.groupNamer, _ = newGroupNamer("aws-{{ .EmailLocalPart }}", nil)
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 1 to create, 0 to update, 1 to delete; groups: 0 to create, 1 to update, 0 to delete; members: 2 to add, 1 to remove", p.Summary())
+
+	assert.NoError(t, s.ApplyPlan(context.Background(), p))
+	assert.Contains(t, a.calls, "UpdateGroup aws-group-1")
+
+	// SyncGroups names the groups with the same template
+	a.calls = nil
+	s.includeGroups, _ = compilePatterns([]string{"group-1@email.com"})
+	assert.NoError(t, s.SyncGroups(context.Background(), []string{""}))
+	assert.NotContains(t, a.calls, "UpdateGroup group-1@email.com")
+	_, err = a.FindGroupByDisplayName(context.Background(), "aws-group-1")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/ssosync/internal/config"
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// ErrNestedGroups is returned when the nested groups mode is unknown
+var ErrNestedGroups = errors.New("invalid nested groups mode")
+
+// validateNestedGroups checks the nested groups mode
+func validateNestedGroups(mode string) error {
+	switch mode {
+	case config.NestedGroupsFlatten, config.NestedGroupsMirror:
+		return nil
+	}
+	return fmt.Errorf("%w: %q, expected %s or %s", ErrNestedGroups, mode, config.NestedGroupsFlatten, config.NestedGroupsMirror)
+}
+
+// mirrorNesting reports whether the nested google groups are synced as AWS
+// groups of their own instead of adding their users to the groups they are
+// nested in, AWS SSO groups can't be nested
+func (s *syncGSuite) mirrorNesting() bool {
+	return s.cfg.NestedGroups == config.NestedGroupsMirror
+}
+
+// withNestedGroups returns the groups with the groups nested in them when
+// the nesting is mirrored, once each
+func (s *syncGSuite) withNestedGroups(ctx context.Context, groups []*admin.Group) ([]*admin.Group, error) {
+	if !s.mirrorNesting() {
+		return groups, nil
+	}
+
+	nestedGroups := make([][]*admin.Group, len(groups))
+	err := forEach(ctx, s.cfg.Concurrency, len(groups), func(i int) error {
+		log.WithField("group", groups[i].Email).Debug("get nested groups from google")
+		nested, err := s.google.GetNestedGroups(ctx, groups[i])
+		if err != nil {
+			return err
+		}
+		nestedGroups[i] = nested
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(groups))
+	for _, g := range groups {
+		seen[g.Id] = struct{}{}
+	}
+	for _, nested := range nestedGroups {
+		for _, g := range nested {
+			if _, ok := seen[g.Id]; !ok {
+				seen[g.Id] = struct{}{}
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// groupMembers returns the members of the group synced to AWS, the users
+// of the nested groups are included unless the nesting is mirrored
+func (s *syncGSuite) groupMembers(ctx context.Context, g *admin.Group) ([]*admin.Member, error) {
+	if !s.mirrorNesting() {
+		return s.google.GetDirectAndIndirectGroupMemberUsers(ctx, g)
+	}
+
+	members, err := s.google.GetGroupMembers(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*admin.Member, 0, len(members))
+	for _, m := range members {
+		if m.Type != "GROUP" {
+			users = append(users, m)
+		}
+	}
+	return users, nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// members returns the emails of the users added to each group by the plan
+func members(p *Plan) map[string][]string {
+	m := make(map[string][]string)
+	for _, gm := range p.AddMembers {
+		for _, u := range gm.Users {
+			m[gm.Group.DisplayName] = append(m[gm.Group.DisplayName], u.Username)
+		}
+	}
+	return m
+}
+
+func TestPlanNestedGroups(t *testing.T) {
+	s, g, _ := newTestSync()
+
+	// Group-2 with user-4 is nested in Group-1, and Group-1 in Group-2
+	u4 := g.addUser("name-4", "lastname-4", "user-4@email.com")
+	u4.Id = "guser-4" // guser-3 is the AWS user-3
+	g1 := g.groups[0]
+	g2 := g.addGroup("Group-2", "group-2@email.com", u4)
+	g.nestGroup(g1, g2)
+	g.nestGroup(g2, g1)
+	queries := []string{"email=group-1@email.com"}
+
+	p, err := s.PlanGroupsUsers(context.Background(), queries)
+	assert.NoError(t, err)
+	assert.Empty(t, p.CreateGroups)
+	assert.Equal(t, map[string][]string{"Group-1": {"user-1@email.com", "user-2@email.com", "user-4@email.com"}}, members(p))
+
+	s.cfg.NestedGroups = config.NestedGroupsMirror
+	p, err = s.PlanGroupsUsers(context.Background(), queries)
+	assert.NoError(t, err)
+	if assert.Len(t, p.CreateGroups, 1) {
+		assert.Equal(t, "Group-2", p.CreateGroups[0].DisplayName)
+		assert.Equal(t, externalID(g2.Id), p.CreateGroups[0].ExternalID)
+	}
+	assert.Equal(t, map[string][]string{
+		"Group-1": {"user-1@email.com", "user-2@email.com"},
+		"Group-2": {"user-4@email.com"},
+	}, members(p))
+}
+
+func TestValidateNestedGroups(t *testing.T) {
+	assert.NoError(t, validateNestedGroups(config.NestedGroupsFlatten))
+	assert.NoError(t, validateNestedGroups(config.NestedGroupsMirror))
+	assert.True(t, errors.Is(validateNestedGroups("nest"), ErrNestedGroups))
+}
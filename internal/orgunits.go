@@ -0,0 +1,200 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/awslabs/ssosync/internal/config"
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// ErrOrgUnit is returned when the org units of the orgunits sync method
+// are invalid
+var ErrOrgUnit = errors.New("invalid org unit")
+
+// validateOrgUnits checks the paths of the org units synced
+func validateOrgUnits(paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("%w: --org-units is required by the %s sync method", ErrOrgUnit, config.SyncMethodOrgUnits)
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("%w: %q is not a path like /Engineering", ErrOrgUnit, p)
+		}
+	}
+	return nil
+}
+
+// SyncOrgUnits will sync the users of the google org units to AWS SSO, the
+// org units are also synced as groups with --org-unit-groups.
+// When running with --dry-run the changes are only logged.
+func (s *syncGSuite) SyncOrgUnits(ctx context.Context, paths []string) error {
+	plan, err := s.PlanOrgUnits(ctx, paths)
+	if err != nil {
+		return err
+	}
+
+	return s.syncPlan(ctx, plan)
+}
+
+// PlanOrgUnits computes the changes SyncOrgUnits would make to AWS SSO
+// without applying any of them
+func (s *syncGSuite) PlanOrgUnits(ctx context.Context, paths []string) (*Plan, error) {
+	googleUsers, err := s.getOrgUnitsUsers(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.cfg.OrgUnitGroups {
+		return s.plan(ctx, googleUsers, nil, nil, false, nil)
+	}
+
+	orgUnits, err := s.getOrgUnits(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	// the org units are synced as groups named by their path by default
+	googleGroups := make([]*admin.Group, len(orgUnits))
+	for i, ou := range orgUnits {
+		googleGroups[i] = &admin.Group{
+			Id:          ou.OrgUnitId,
+			Name:        ou.OrgUnitPath,
+			Description: ou.Description,
+		}
+	}
+	names, err := s.groupNamer.names(googleGroups, func(g *admin.Group) string { return g.Name })
+	if err != nil {
+		return nil, err
+	}
+
+	googleGroupsUsers := make(map[string][]*admin.User, len(googleGroups))
+	for i, g := range googleGroups {
+		g.Name = names[i]
+		members := make([]*admin.User, 0)
+		for _, u := range googleUsers {
+			if inOrgUnit(u.OrgUnitPath, orgUnits[i].OrgUnitPath, s.cfg.OrgUnitsRecursive) {
+				members = append(members, u)
+			}
+		}
+		googleGroupsUsers[g.Name] = members
+	}
+
+	return s.plan(ctx, googleUsers, googleGroups, googleGroupsUsers, true, nil)
+}
+
+// getOrgUnitsUsers returns the users of the org units, once each
+func (s *syncGSuite) getOrgUnitsUsers(ctx context.Context, paths []string) ([]*admin.User, error) {
+	orgUnitsUsers := make([][]*admin.User, len(paths))
+	err := forEach(ctx, s.cfg.Concurrency, len(paths), func(i int) error {
+		log.WithField("org_unit", paths[i]).Debug("get org unit users from google")
+		users, err := s.google.GetOrgUnitUsers(ctx, paths[i], s.cfg.OrgUnitsRecursive)
+		if err != nil {
+			return err
+		}
+		orgUnitsUsers[i] = users
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	googleUsers := make([]*admin.User, 0)
+	seen := make(map[string]struct{})
+	for _, users := range orgUnitsUsers {
+		for _, u := range users {
+			if _, ok := seen[u.PrimaryEmail]; ok {
+				continue
+			}
+			seen[u.PrimaryEmail] = struct{}{}
+
+			if s.ignoreUser(u.PrimaryEmail) {
+				log.WithField("email", u.PrimaryEmail).Debug("ignoring user")
+				continue
+			}
+			ok, err := s.selectUser(u)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				googleUsers = append(googleUsers, u)
+			}
+		}
+	}
+
+	return googleUsers, nil
+}
+
+// getOrgUnits returns the org units synced as groups, once each. The
+// ignore lists match their paths and the group filter their fields.
+func (s *syncGSuite) getOrgUnits(ctx context.Context, paths []string) ([]*admin.OrgUnit, error) {
+	pathsOrgUnits := make([][]*admin.OrgUnit, len(paths))
+	err := forEach(ctx, s.cfg.Concurrency, len(paths), func(i int) error {
+		log.WithField("org_unit", paths[i]).Debug("get org units from google")
+		orgUnits, err := s.google.GetOrgUnits(ctx, paths[i], s.cfg.OrgUnitsRecursive)
+		if err != nil {
+			return err
+		}
+		pathsOrgUnits[i] = orgUnits
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]interface{}{"syncMethod": s.cfg.SyncMethod}
+	orgUnits := make([]*admin.OrgUnit, 0)
+	seen := make(map[string]struct{})
+	for _, ous := range pathsOrgUnits {
+		for _, ou := range ous {
+			if _, ok := seen[ou.OrgUnitPath]; ok {
+				continue
+			}
+			seen[ou.OrgUnitPath] = struct{}{}
+
+			if s.ignoreGroup(ou.OrgUnitPath) {
+				log.WithField("org_unit", ou.OrgUnitPath).Debug("ignoring org unit")
+				continue
+			}
+			ok, err := s.groupFilter.match(ou, vars)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				log.WithField("org_unit", ou.OrgUnitPath).Debug("ignoring org unit, using --group-filter")
+				continue
+			}
+			orgUnits = append(orgUnits, ou)
+		}
+	}
+
+	return orgUnits, nil
+}
+
+// inOrgUnit reports whether a user of the org unit with the path given is
+// in the org unit, or in one below it when recursive. Org unit paths are
+// not case sensitive.
+func inOrgUnit(userPath string, orgUnitPath string, recursive bool) bool {
+	userPath, orgUnitPath = strings.ToLower(userPath), strings.ToLower(orgUnitPath)
+	if userPath == orgUnitPath {
+		return true
+	}
+	return recursive && strings.HasPrefix(userPath, strings.TrimSuffix(orgUnitPath, "/")+"/")
+}
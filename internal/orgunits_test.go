@@ -0,0 +1,140 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/awslabs/ssosync/internal/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+// newOrgUnitsSync returns a sync of the org units /Engineering, with
+// user-1 and /Engineering/Platform below it, with user-2, and /Sales with
+// user-3
+func newOrgUnitsSync() (*syncGSuite, *fakeGoogle, *fakeAWS) {
+	g := newFakeGoogle()
+	u1 := g.addUser("name-1", "lastname-1", "user-1@email.com")
+	u2 := g.addUser("name-2", "lastname-2", "user-2@email.com")
+	u3 := g.addUser("name-3", "lastname-3", "user-3@email.com")
+	g.addOrgUnit("/Engineering", u1)
+	g.addOrgUnit("/Engineering/Platform", u2)
+	g.addOrgUnit("/Sales", u3)
+
+	cfg := newTestSyncConfig()
+	cfg.SyncMethod = config.SyncMethodOrgUnits
+	cfg.OrgUnits = []string{"/Engineering"}
+
+	a := newFakeAWS()
+	return New(cfg, a, g, datastore.NewNullDatastore()).(*syncGSuite), g, a
+}
+
+func TestPlanOrgUnits(t *testing.T) {
+	s, _, a := newOrgUnitsSync()
+
+	// an owned group of the groups sync method is left as it is
+	a.addGroup(aws.NewGroup("Group-1"))
+
+	p, err := s.PlanOrgUnits(context.Background(), s.cfg.OrgUnits)
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 2 to create, 0 to update, 0 to delete; groups: 0 to create, 0 to update, 0 to delete; members: 0 to add, 0 to remove", p.Summary())
+
+	s.cfg.OrgUnitsRecursive = false
+	p, err = s.PlanOrgUnits(context.Background(), s.cfg.OrgUnits)
+	assert.NoError(t, err)
+	if assert.Len(t, p.CreateUsers, 1) {
+		assert.Equal(t, "user-1@email.com", p.CreateUsers[0].Username)
+	}
+}
+
+func TestPlanOrgUnitGroups(t *testing.T) {
+	s, _, _ := newOrgUnitsSync()
+	s.cfg.OrgUnitGroups = true
+
+	p, err := s.PlanOrgUnits(context.Background(), s.cfg.OrgUnits)
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 2 to create, 0 to update, 0 to delete; groups: 2 to create, 0 to update, 0 to delete; members: 3 to add, 0 to remove", p.Summary())
+	if assert.Len(t, p.CreateGroups, 2) {
+		assert.Equal(t, "/Engineering", p.CreateGroups[0].DisplayName)
+		assert.Equal(t, externalID("gou-1"), p.CreateGroups[0].ExternalID)
+		assert.Equal(t, "/Engineering/Platform", p.CreateGroups[1].DisplayName)
+	}
+
+	// the org units are named like groups and matched by the ignore lists
+	s.groupNamer, _ = newGroupNamer(`{{ trimPrefix "/" .Name }}`, nil)
+	s.ignoreGroups, _ = compilePatterns([]string{"/Engineering/*"})
+	s.cfg.OrgUnits = []string{"/Engineering", "/Sales"}
+	p, err = s.PlanOrgUnits(context.Background(), s.cfg.OrgUnits)
+	assert.NoError(t, err)
+	if assert.Len(t, p.CreateGroups, 2) {
+		assert.Equal(t, "Engineering", p.CreateGroups[0].DisplayName)
+		assert.Equal(t, "Sales", p.CreateGroups[1].DisplayName)
+	}
+	assert.Len(t, p.CreateUsers, 3)
+}
+
+func TestSyncOrgUnits(t *testing.T) {
+	s, g, a := newOrgUnitsSync()
+	s.cfg.OrgUnitGroups = true
+
+	err := s.SyncOrgUnits(context.Background(), s.cfg.OrgUnits)
+	assert.NoError(t, err)
+	assert.Len(t, a.users, 2)
+	assert.Len(t, a.groups, 2)
+
+	// a user moved out of the org units is deleted and removed from its groups
+	g.users[1].OrgUnitPath = "/Sales"
+	p, err := s.PlanOrgUnits(context.Background(), s.cfg.OrgUnits)
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 0 to create, 0 to update, 1 to delete; groups: 0 to create, 0 to update, 0 to delete; members: 0 to add, 2 to remove", p.Summary())
+
+	err = s.SyncOrgUnits(context.Background(), s.cfg.OrgUnits)
+	assert.NoError(t, err)
+	assert.Contains(t, a.calls, "DeleteUser user-2@email.com")
+}
+
+func TestValidateOrgUnits(t *testing.T) {
+	assert.NoError(t, validateOrgUnits([]string{"/", "/Engineering"}))
+	assert.True(t, errors.Is(validateOrgUnits(nil), ErrOrgUnit))
+	assert.True(t, errors.Is(validateOrgUnits([]string{"Engineering"}), ErrOrgUnit))
+
+	cfg := newTestSyncConfig()
+	cfg.SyncMethod = config.SyncMethodOrgUnits
+	assert.True(t, errors.Is(validateConfig(cfg), ErrOrgUnit))
+}
+
+func TestInOrgUnit(t *testing.T) {
+	tests := []struct {
+		user, orgUnit string
+		recursive     bool
+		want          bool
+	}{
+		{"/Engineering", "/Engineering", false, true},
+		{"/engineering", "/Engineering", false, true},
+		{"/Engineering/Platform", "/Engineering", false, false},
+		{"/Engineering/Platform", "/Engineering", true, true},
+		{"/EngineeringOps", "/Engineering", true, false},
+		{"/Sales", "/", true, true},
+		{"/Sales", "/", false, false},
+		{"/", "/", false, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, inOrgUnit(tt.user, tt.orgUnit, tt.recursive), "%s in %s", tt.user, tt.orgUnit)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrPattern is returned when a pattern of a users or groups list is invalid
+var ErrPattern = errors.New("invalid pattern")
+
+// pattern matches a user or group name, it is a regular expression between
+// slashes like /^aws-.*$/, a glob when it has any of *?[ or else the name
+type pattern struct {
+	name string
+	glob bool
+	re   *regexp.Regexp
+}
+
+// patterns matches the names of a users or groups list
+type patterns []pattern
+
+// compilePatterns compiles the entries of a users or groups list
+func compilePatterns(entries []string) (patterns, error) {
+	var ps patterns
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		p, err := compilePattern(entry)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// lenientPatterns compiles the entries of a users or groups list, the
+// invalid ones are only matched as names
+func lenientPatterns(entries []string) patterns {
+	var ps patterns
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		p, err := compilePattern(entry)
+		if err != nil {
+			log.WithError(err).Warn("matching the pattern as a name")
+			p = pattern{name: entry}
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+func compilePattern(entry string) (pattern, error) {
+	if len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+		re, err := regexp.Compile(entry[1 : len(entry)-1])
+		if err != nil {
+			return pattern{}, fmt.Errorf("%w: %s: %v", ErrPattern, entry, err)
+		}
+		return pattern{name: entry, re: re}, nil
+	}
+
+	if strings.ContainsAny(entry, "*?[") {
+		if _, err := path.Match(entry, ""); err != nil {
+			return pattern{}, fmt.Errorf("%w: %s: %v", ErrPattern, entry, err)
+		}
+		return pattern{name: entry, glob: true}, nil
+	}
+
+	return pattern{name: entry}, nil
+}
+
+// match reports whether any of the patterns matches the name
+func (ps patterns) match(name string) bool {
+	for _, p := range ps {
+		switch {
+		case p.re != nil:
+			if p.re.MatchString(name) {
+				return true
+			}
+		case p.glob:
+			if ok, _ := path.Match(p.name, name); ok {
+				return true
+			}
+		case p.name == name:
+			return true
+		}
+	}
+	return false
+}
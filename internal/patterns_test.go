@@ -0,0 +1,103 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatterns_Match(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		match   []string
+		noMatch []string
+	}{
+		{
+			name:    "names",
+			entries: []string{"user-1@email.com", "", "user-2@email.com"},
+			match:   []string{"user-1@email.com", "user-2@email.com"},
+			noMatch: []string{"user-3@email.com", "USER-1@email.com", ""},
+		},
+		{
+			name:    "globs",
+			entries: []string{"*@contractors.com", "admin-?@email.com", "group-[0-9]"},
+			match:   []string{"john@contractors.com", "admin-1@email.com", "group-7"},
+			noMatch: []string{"john@email.com", "admin-10@email.com", "group-a"},
+		},
+		{
+			name:    "regular expressions",
+			entries: []string{"/^break-glass-/", "/(?i)^aws-.*-admins$/"},
+			match:   []string{"break-glass-1@email.com", "AWS-Prod-Admins"},
+			noMatch: []string{"user-break-glass-1@email.com", "aws-prod-users"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := compilePatterns(tt.entries)
+			assert.NoError(t, err)
+			for _, name := range tt.match {
+				assert.True(t, ps.match(name), name)
+			}
+			for _, name := range tt.noMatch {
+				assert.False(t, ps.match(name), name)
+			}
+		})
+	}
+}
+
+func TestCompilePatternsErrors(t *testing.T) {
+	for _, entry := range []string{"/(/", "group-[0-9"} {
+		_, err := compilePatterns([]string{entry})
+		assert.True(t, errors.Is(err, ErrPattern), entry)
+
+		// New keeps them as names, validateConfig refuses them
+		assert.True(t, lenientPatterns([]string{entry}).match(entry))
+		cfg := config.New()
+		cfg.ProtectedUsers = []string{entry}
+		assert.True(t, errors.Is(validateConfig(cfg), ErrPattern))
+	}
+}
+
+func TestPlanProtected(t *testing.T) {
+	s, g, a := newTestSync()
+	s.protectedUsers, _ = compilePatterns([]string{"/^user-[23]@/"})
+	s.protectedGroups, _ = compilePatterns([]string{"Owned-*"})
+
+	// user-2 is suspended in google and an owned group is gone from google
+	g.users[1].Suspended = true
+	og := aws.NewGroup("Owned-1")
+	og.ExternalID = externalID("ggroup-gone")
+	a.addGroup(og)
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 1 to create, 0 to update, 0 to delete; groups: 0 to create, 0 to update, 0 to delete; members: 2 to add, 0 to remove", p.Summary())
+
+	// protected users still get the other changes
+	g.users[1].Name.GivenName = "renamed-2"
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	if assert.Len(t, p.UpdateUsers, 1) {
+		assert.True(t, p.UpdateUsers[0].Active)
+		assert.Equal(t, "renamed-2", p.UpdateUsers[0].Name.GivenName)
+	}
+}
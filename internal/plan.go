@@ -0,0 +1,270 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/aws"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PlanVersion is the version of the plan file format, plans written
+// with a different version are refused
+const PlanVersion = 3
+
+var (
+	// ErrPlanVersion is returned when a plan has an unsupported version
+	ErrPlanVersion = errors.New("unsupported plan version")
+	// ErrStalePlan is returned when AWS SSO changed since the plan was created
+	ErrStalePlan = errors.New("stale plan")
+	// ErrDeletionLimit is returned when a plan deletes more users, groups or
+	// group members than allowed
+	ErrDeletionLimit = errors.New("deletion limit exceeded")
+)
+
+// GroupMembership is a list of users whose membership of a group changes
+type GroupMembership struct {
+	Group *aws.Group  `json:"group"`
+	Users []*aws.User `json:"users"`
+}
+
+// Plan holds every change a sync run intends to make in AWS SSO,
+// computed before any of them is applied. The ids of the existing users
+// and groups, and the state of the ones to update by AWS id, are the
+// preconditions checked before applying a plan. The
+// times the users were first found to delete or deactivate, by AWS id, and
+// the users not deprovisioned anymore are kept in the datastore when the
+// plan is applied.
+type Plan struct {
+	Version       int                `json:"version"`
+	Created       time.Time          `json:"created"`
+	Endpoint      string             `json:"endpoint"`
+	AWSUsers      int                `json:"awsUsers"`
+	AWSGroups     int                `json:"awsGroups"`
+	AWSMembers    int                `json:"awsMembers"`
+	CreateUsers   []*aws.User        `json:"createUsers"`
+	UpdateUsers   []*aws.User        `json:"updateUsers"`
+	DeleteUsers   []*aws.User        `json:"deleteUsers"`
+	CreateGroups  []*aws.Group       `json:"createGroups"`
+	UpdateGroups  []*aws.Group       `json:"updateGroups"`
+	DeleteGroups  []*aws.Group       `json:"deleteGroups"`
+	AddMembers    []*GroupMembership `json:"addMembers"`
+	RemoveMembers []*GroupMembership `json:"removeMembers"`
+
+	PreviousUsers  map[string]*aws.User  `json:"previousUsers"`
+	PreviousGroups map[string]*aws.Group `json:"previousGroups"`
+
+	Deprovisioned       map[string]time.Time `json:"deprovisioned,omitempty"`
+	ForgetDeprovisioned []string             `json:"forgetDeprovisioned,omitempty"`
+}
+
+// ReadPlan decodes a plan previously written with Write
+func ReadPlan(r io.Reader) (*Plan, error) {
+	var p Plan
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %w", err)
+	}
+
+	if p.Version != PlanVersion {
+		return nil, fmt.Errorf("%w: %d, expected %d", ErrPlanVersion, p.Version, PlanVersion)
+	}
+
+	return &p, nil
+}
+
+// Write encodes the plan as JSON so it can be applied later
+func (p *Plan) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(p); err != nil {
+		return fmt.Errorf("failed to encode plan to json: %w", err)
+	}
+	return nil
+}
+
+// Empty returns true when the plan has no changes to apply
+func (p *Plan) Empty() bool {
+	return len(p.CreateUsers) == 0 &&
+		len(p.UpdateUsers) == 0 &&
+		len(p.DeleteUsers) == 0 &&
+		len(p.CreateGroups) == 0 &&
+		len(p.UpdateGroups) == 0 &&
+		len(p.DeleteGroups) == 0 &&
+		countMembers(p.AddMembers) == 0 &&
+		countMembers(p.RemoveMembers) == 0
+}
+
+// Summary returns a one line description of the number of changes
+func (p *Plan) Summary() string {
+	return fmt.Sprintf("users: %d to create, %d to update, %d to delete; groups: %d to create, %d to update, %d to delete; members: %d to add, %d to remove",
+		len(p.CreateUsers), len(p.UpdateUsers), len(p.DeleteUsers),
+		len(p.CreateGroups), len(p.UpdateGroups), len(p.DeleteGroups),
+		countMembers(p.AddMembers), countMembers(p.RemoveMembers))
+}
+
+// CheckDeletionLimits returns ErrDeletionLimit when the plan deletes more than
+// max users, groups or group members, or more than maxPercent percent of the
+// users, groups or group members that existed in AWS SSO. A limit of 0 is not checked.
+func (p *Plan) CheckDeletionLimits(max int, maxPercent int) error {
+	checks := []struct {
+		kind     string
+		deleted  int
+		existing int
+	}{
+		{"users", len(p.DeleteUsers), p.AWSUsers},
+		{"groups", len(p.DeleteGroups), p.AWSGroups},
+		{"group members", countMembers(p.RemoveMembers), p.AWSMembers},
+	}
+
+	for _, c := range checks {
+		if max > 0 && c.deleted > max {
+			return fmt.Errorf("%w: %d %s to delete, the limit is %d", ErrDeletionLimit, c.deleted, c.kind, max)
+		}
+		if maxPercent > 0 && c.deleted*100 > maxPercent*c.existing {
+			return fmt.Errorf("%w: %d of %d %s to delete, the limit is %d%%", ErrDeletionLimit, c.deleted, c.existing, c.kind, maxPercent)
+		}
+	}
+
+	return nil
+}
+
+// Log writes every change of the plan to the logger, it is used
+// when running with --dry-run
+func (p *Plan) Log() {
+	for _, u := range p.DeleteUsers {
+		log.WithField("user", u.Username).Warn("dry run: would delete user")
+	}
+	for _, u := range p.UpdateUsers {
+		log.WithField("user", u.Username).Warn("dry run: would update user")
+	}
+	for _, u := range p.CreateUsers {
+		log.WithField("user", u.Username).Info("dry run: would create user")
+	}
+	for _, g := range p.UpdateGroups {
+		log.WithField("group", g.DisplayName).Info("dry run: would update group")
+	}
+	for _, g := range p.CreateGroups {
+		log.WithField("group", g.DisplayName).Info("dry run: would create group")
+	}
+	for _, m := range p.AddMembers {
+		for _, u := range m.Users {
+			log.WithFields(log.Fields{"group": m.Group.DisplayName, "user": u.Username}).Info("dry run: would add user to group")
+		}
+	}
+	for _, m := range p.RemoveMembers {
+		for _, u := range m.Users {
+			log.WithFields(log.Fields{"group": m.Group.DisplayName, "user": u.Username}).Warn("dry run: would remove user from group")
+		}
+	}
+	for _, g := range p.DeleteGroups {
+		log.WithField("group", g.DisplayName).Warn("dry run: would delete group")
+	}
+
+	log.Info("dry run: " + p.Summary())
+}
+
+// Print writes a human readable version of the plan to w
+func (p *Plan) Print(w io.Writer) error {
+	lines := make([]string, 0)
+
+	for _, u := range p.DeleteUsers {
+		lines = append(lines, fmt.Sprintf("- user   %s", u.Username))
+	}
+	for _, u := range p.UpdateUsers {
+		lines = append(lines, fmt.Sprintf("~ user   %s", u.Username))
+	}
+	for _, u := range p.CreateUsers {
+		lines = append(lines, fmt.Sprintf("+ user   %s", u.Username))
+	}
+	for _, g := range p.UpdateGroups {
+		lines = append(lines, fmt.Sprintf("~ group  %s", g.DisplayName))
+	}
+	for _, g := range p.CreateGroups {
+		lines = append(lines, fmt.Sprintf("+ group  %s", g.DisplayName))
+	}
+	for _, m := range p.AddMembers {
+		for _, u := range m.Users {
+			lines = append(lines, fmt.Sprintf("+ member %s -> %s", u.Username, m.Group.DisplayName))
+		}
+	}
+	for _, m := range p.RemoveMembers {
+		for _, u := range m.Users {
+			lines = append(lines, fmt.Sprintf("- member %s -> %s", u.Username, m.Group.DisplayName))
+		}
+	}
+	for _, g := range p.DeleteGroups {
+		lines = append(lines, fmt.Sprintf("- group  %s", g.DisplayName))
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, "No changes, AWS SSO is up to date.")
+	}
+	lines = append(lines, "", "Plan: "+p.Summary())
+
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sort orders every list of the plan by user name or group display name,
+// so the same state always produces the same plan
+func (p *Plan) sort() {
+	sortUsers(p.CreateUsers)
+	sortUsers(p.UpdateUsers)
+	sortUsers(p.DeleteUsers)
+	sortGroups(p.CreateGroups)
+	sortGroups(p.UpdateGroups)
+	sortGroups(p.DeleteGroups)
+
+	for _, members := range [][]*GroupMembership{p.AddMembers, p.RemoveMembers} {
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].Group.DisplayName < members[j].Group.DisplayName
+		})
+		for _, m := range members {
+			sortUsers(m.Users)
+		}
+	}
+}
+
+func sortUsers(users []*aws.User) {
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+}
+
+func sortGroups(groups []*aws.Group) {
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].DisplayName < groups[j].DisplayName
+	})
+}
+
+func countMembers(members []*GroupMembership) int {
+	n := 0
+	for _, m := range members {
+		n += len(m.Users)
+	}
+	return n
+}
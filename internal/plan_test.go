@@ -0,0 +1,427 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/awslabs/ssosync/internal/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSync returns a sync with fake clients, google has user-1 and user-2
+// in Group-1 and aws has user-2 and user-3, with user-3 in Group-1. user-3
+// was synced from a google user that is gone.
+func newTestSync() (*syncGSuite, *fakeGoogle, *fakeAWS) {
+	g := newFakeGoogle()
+	u1 := g.addUser("name-1", "lastname-1", "user-1@email.com")
+	u2 := g.addUser("name-2", "lastname-2", "user-2@email.com")
+	gg := g.addGroup("Group-1", "group-1@email.com", u1, u2)
+
+	a := newFakeAWS()
+	a.addUser(newAWSUser(u2))
+	u3 := aws.NewUser("name-3", "lastname-3", "user-3@email.com", true)
+	u3.ExternalID = externalID("guser-3")
+	au3 := a.addUser(u3)
+	a.addGroup(newAWSGroup("Group-1", gg), au3)
+
+	return New(newTestSyncConfig(), a, g, datastore.NewNullDatastore()).(*syncGSuite), g, a
+}
+
+// newTestSyncConfig returns the configuration used by the test syncs
+func newTestSyncConfig() *config.Config {
+	cfg := config.New()
+	cfg.SCIMEndpoint = "https://scim.example.com/"
+	return cfg
+}
+
+func TestPlan_Print(t *testing.T) {
+	p := &Plan{
+		CreateUsers:  []*aws.User{aws.NewUser("name-2", "lastname-2", "user-2@email.com", true)},
+		DeleteUsers:  []*aws.User{aws.NewUser("name-1", "lastname-1", "user-1@email.com", true)},
+		CreateGroups: []*aws.Group{aws.NewGroup("Group-1")},
+		AddMembers: []*GroupMembership{
+			{
+				Group: aws.NewGroup("Group-1"),
+				Users: []*aws.User{aws.NewUser("name-2", "lastname-2", "user-2@email.com", true)},
+			},
+		},
+	}
+
+	assert.False(t, p.Empty())
+
+	var b bytes.Buffer
+	err := p.Print(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, `- user   user-1@email.com
++ user   user-2@email.com
++ group  Group-1
++ member user-2@email.com -> Group-1
+
+Plan: users: 1 to create, 0 to update, 1 to delete; groups: 1 to create, 0 to update, 0 to delete; members: 1 to add, 0 to remove
+`, b.String())
+}
+
+func TestPlan_PrintEmpty(t *testing.T) {
+	p := &Plan{}
+
+	assert.True(t, p.Empty())
+
+	var b bytes.Buffer
+	err := p.Print(&b)
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "No changes")
+}
+
+func TestPlan_WriteRead(t *testing.T) {
+	s, _, _ := newTestSync()
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+
+	var b bytes.Buffer
+	assert.NoError(t, p.Write(&b))
+
+	got, err := ReadPlan(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, p.Summary(), got.Summary())
+	assert.Equal(t, PlanVersion, got.Version)
+	assert.True(t, p.Created.Equal(got.Created))
+
+	_, err = ReadPlan(bytes.NewBufferString(`{"version": 0}`))
+	assert.True(t, errors.Is(err, ErrPlanVersion))
+}
+
+func TestApplyPlan(t *testing.T) {
+	s, _, a := newTestSync()
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 1 to create, 0 to update, 1 to delete; groups: 0 to create, 0 to update, 0 to delete; members: 2 to add, 1 to remove", p.Summary())
+
+	err = s.ApplyPlan(context.Background(), p)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"DeleteUser user-3@email.com",
+		"CreateUser user-1@email.com",
+		"AddUsersToGroup user-1@email.com,user-2@email.com Group-1",
+		"RemoveUsersFromGroup user-3@email.com Group-1",
+	}, a.calls)
+
+	// the plan was already applied, so every precondition fails
+	a.calls = nil
+	err = s.ApplyPlan(context.Background(), p)
+	assert.True(t, errors.Is(err, ErrStalePlan))
+	assert.Empty(t, a.calls)
+}
+
+func TestApplyPlanStaleUpdates(t *testing.T) {
+	s, g, a := newTestSync()
+
+	// user-2 and Group-1 are renamed in google
+	g.users[1].Name.GivenName = "name-2 renamed"
+	g.groups[0].Name = "Group-1 renamed"
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Len(t, p.UpdateUsers, 1)
+	assert.Len(t, p.UpdateGroups, 1)
+
+	// the previous state is kept in the plan file
+	var b bytes.Buffer
+	assert.NoError(t, p.Write(&b))
+	p, err = ReadPlan(&b)
+	assert.NoError(t, err)
+
+	// the user is changed in AWS SSO after the plan was created, the plan
+	// would overwrite the change
+	au2, _ := a.FindUserByEmail(context.Background(), "user-2@email.com")
+	au2.Name.FamilyName = "lastname-2 changed"
+	err = s.ApplyPlan(context.Background(), p)
+	assert.True(t, errors.Is(err, ErrStalePlan))
+	assert.Empty(t, a.calls)
+
+	// the group is deleted in AWS SSO after the plan was created
+	au2.Name.FamilyName = "lastname-2"
+	ag1, _ := a.FindGroupByDisplayName(context.Background(), "Group-1")
+	assert.NoError(t, a.DeleteGroup(context.Background(), ag1))
+	a.calls = nil
+	err = s.ApplyPlan(context.Background(), p)
+	assert.True(t, errors.Is(err, ErrStalePlan))
+	assert.Empty(t, a.calls)
+}
+
+func TestApplyPlanRenames(t *testing.T) {
+	s, g, a := newTestSync()
+
+	// user-2 changes email and Group-1 is renamed, both keep their members
+	g.users[1].PrimaryEmail = "user-2-new@email.com"
+	g.members[g.groups[0].Id][1].Email = "user-2-new@email.com"
+	g.groups[0].Name = "Group-1 renamed"
+	au2, _ := a.FindUserByEmail(context.Background(), "user-2@email.com")
+	ag1, _ := a.FindGroupByDisplayName(context.Background(), "Group-1")
+	a.members[ag1.ID][au2.ID] = true
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 1 to create, 1 to update, 1 to delete; groups: 0 to create, 1 to update, 0 to delete; members: 1 to add, 1 to remove", p.Summary())
+
+	err = s.ApplyPlan(context.Background(), p)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"DeleteUser user-3@email.com",
+		"UpdateUser user-2-new@email.com",
+		"CreateUser user-1@email.com",
+		"UpdateGroup Group-1 renamed",
+		"AddUsersToGroup user-1@email.com Group-1 renamed",
+		"RemoveUsersFromGroup user-3@email.com Group-1 renamed",
+	}, a.calls)
+
+	u, err := a.FindUserByEmail(context.Background(), "user-2-new@email.com")
+	assert.NoError(t, err)
+	assert.Equal(t, au2.ID, u.ID)
+	assert.True(t, a.members[ag1.ID][au2.ID])
+}
+
+func TestApplyPlanRenamesWithoutExternalIDs(t *testing.T) {
+	g := newFakeGoogle()
+	u1 := g.addUser("name-1", "lastname-1", "user-1@email.com")
+	g.addGroup("Group-1", "group-1@email.com", u1)
+
+	a := newFakeAWS()
+	a.noGroupExternalIDs = true
+	ds := datastore.NewNullDatastore()
+	s := New(newTestSyncConfig(), a, g, ds).(*syncGSuite)
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.NoError(t, s.ApplyPlan(context.Background(), p))
+	ag1, _ := a.FindGroupByDisplayName(context.Background(), "Group-1")
+
+	// the group is found by the id kept in the datastore
+	groupIDs, _ := ds.GetGroupIDs()
+	assert.Equal(t, map[string]string{g.groups[0].Id: ag1.ID}, groupIDs)
+
+	g.groups[0].Name = "Group-1 renamed"
+	a.calls = nil
+
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 0 to create, 0 to update, 0 to delete; groups: 0 to create, 1 to update, 0 to delete; members: 0 to add, 0 to remove", p.Summary())
+	assert.NoError(t, s.ApplyPlan(context.Background(), p))
+	assert.Equal(t, []string{"UpdateGroup Group-1 renamed"}, a.calls)
+
+	// nothing left to do once renamed
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.True(t, p.Empty())
+
+	// deleted groups are forgotten
+	g.groups = nil
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.NoError(t, s.ApplyPlan(context.Background(), p))
+	groupIDs, _ = ds.GetGroupIDs()
+	assert.Empty(t, groupIDs)
+}
+
+func TestApplyPlanOtherEndpoint(t *testing.T) {
+	s, _, a := newTestSync()
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+
+	p.Endpoint = "https://other.example.com/"
+	err = s.ApplyPlan(context.Background(), p)
+	assert.True(t, errors.Is(err, ErrStalePlan))
+	assert.Empty(t, a.calls)
+}
+
+func TestPlan_CheckDeletionLimits(t *testing.T) {
+	users := func(n int) []*aws.User {
+		u := make([]*aws.User, n)
+		for i := range u {
+			u[i] = aws.NewUser("name", "lastname", "user@email.com", true)
+		}
+		return u
+	}
+
+	tests := []struct {
+		name       string
+		plan       *Plan
+		max        int
+		maxPercent int
+		wantErr    bool
+	}{
+		{
+			name: "no limits",
+			plan: &Plan{AWSUsers: 10, DeleteUsers: users(10)},
+		},
+		{
+			name:    "under the count limit",
+			plan:    &Plan{AWSUsers: 10, DeleteUsers: users(2)},
+			max:     2,
+			wantErr: false,
+		},
+		{
+			name:    "over the count limit",
+			plan:    &Plan{AWSUsers: 10, DeleteUsers: users(3)},
+			max:     2,
+			wantErr: true,
+		},
+		{
+			name:       "under the percent limit",
+			plan:       &Plan{AWSUsers: 10, DeleteUsers: users(5)},
+			maxPercent: 50,
+			wantErr:    false,
+		},
+		{
+			name:       "over the percent limit",
+			plan:       &Plan{AWSUsers: 10, DeleteUsers: users(6)},
+			maxPercent: 50,
+			wantErr:    true,
+		},
+		{
+			name: "over the percent limit of group members",
+			plan: &Plan{
+				AWSMembers:    4,
+				RemoveMembers: []*GroupMembership{{Group: aws.NewGroup("Group-1"), Users: users(4)}},
+			},
+			maxPercent: 50,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.plan.CheckDeletionLimits(tt.max, tt.maxPercent)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrDeletionLimit))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSyncGroupsUsersDeletionLimit(t *testing.T) {
+	s, _, a := newTestSync()
+	s.cfg.MaxDeletions = 0
+	s.cfg.MaxDeletionsPercent = 10
+
+	err := s.SyncGroupsUsers(context.Background(), []string{""})
+	assert.True(t, errors.Is(err, ErrDeletionLimit))
+	assert.Empty(t, a.calls)
+
+	s.cfg.Force = true
+	err = s.SyncGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Contains(t, a.calls, "DeleteUser user-3@email.com")
+}
+
+func TestPlanTakeOver(t *testing.T) {
+	s, g, a := newTestSync()
+
+	// user-1 and Group-2 exist in AWS without external id, created by
+	// hand or by another tool
+	a.addUser(aws.NewUser("name-1", "lastname-1", "user-1@email.com", true))
+	g.addGroup("Group-2", "group-2@email.com", g.users[0])
+	a.addGroup(aws.NewGroup("Group-2"))
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 0 to create, 0 to update, 1 to delete; groups: 0 to create, 0 to update, 0 to delete; members: 3 to add, 1 to remove", p.Summary())
+
+	// created by an older version, they are in the datastore and owned
+	ds := datastore.NewNullDatastore()
+	assert.NoError(t, ds.AddUser("user-1@email.com"))
+	assert.NoError(t, ds.AddGroup("Group-2"))
+	s = New(s.cfg, a, g, ds).(*syncGSuite)
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	if assert.Len(t, p.UpdateUsers, 1) {
+		assert.Equal(t, externalID("guser-1"), p.UpdateUsers[0].ExternalID)
+	}
+	if assert.Len(t, p.UpdateGroups, 1) {
+		assert.Equal(t, externalID(g.groups[1].Id), p.UpdateGroups[0].ExternalID)
+	}
+
+	// or taken over with --manage-unowned
+	s = New(s.cfg, a, g, datastore.NewNullDatastore()).(*syncGSuite)
+	s.cfg.ManageUnowned = true
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Len(t, p.UpdateUsers, 1)
+	assert.Len(t, p.UpdateGroups, 1)
+}
+
+func TestPlanOtherIdentity(t *testing.T) {
+	s, g, a := newTestSync()
+
+	// user-1 and Group-2 are taken in AWS by another google user and group
+	au1 := aws.NewUser("name-1", "lastname-1", "user-1@email.com", true)
+	au1.ExternalID = externalID("guser-9")
+	a.addUser(au1)
+	g.addGroup("Group-2", "group-2@email.com", g.users[0])
+	ag2 := aws.NewGroup("Group-2")
+	ag2.ExternalID = externalID("ggroup-9")
+	a.addGroup(ag2)
+
+	// they are not created nor merged, the AWS ones are deleted
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Empty(t, p.CreateUsers)
+	assert.Empty(t, p.CreateGroups)
+	deleted := make([]string, 0)
+	for _, u := range p.DeleteUsers {
+		deleted = append(deleted, u.Username)
+	}
+	assert.ElementsMatch(t, []string{"user-1@email.com", "user-3@email.com"}, deleted)
+	if assert.Len(t, p.DeleteGroups, 1) {
+		assert.Equal(t, "Group-2", p.DeleteGroups[0].DisplayName)
+	}
+	for _, m := range p.AddMembers {
+		for _, u := range m.Users {
+			assert.NotEqual(t, "user-1@email.com", u.Username)
+		}
+	}
+}
+
+func TestPlanUnowned(t *testing.T) {
+	s, _, a := newTestSync()
+
+	// a break-glass user in Group-1 and a group managed by another pipeline
+	admin := a.addUser(aws.NewUser("admin", "lastname", "admin@email.com", true))
+	ag1, _ := a.FindGroupByDisplayName(context.Background(), "Group-1")
+	a.members[ag1.ID][admin.ID] = true
+	other := aws.NewGroup("Other")
+	other.ExternalID = "other-pipeline-id"
+	a.addGroup(other)
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 1 to create, 0 to update, 1 to delete; groups: 0 to create, 0 to update, 0 to delete; members: 2 to add, 1 to remove", p.Summary())
+	assert.Equal(t, "user-3@email.com", p.DeleteUsers[0].Username)
+	assert.Equal(t, "user-3@email.com", p.RemoveMembers[0].Users[0].Username)
+
+	s.cfg.ManageUnowned = true
+	p, err = s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 1 to create, 0 to update, 2 to delete; groups: 0 to create, 0 to update, 1 to delete; members: 2 to add, 2 to remove", p.Summary())
+}
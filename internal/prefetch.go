@@ -0,0 +1,84 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/ssosync/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrPrefetch is returned when the prefetch mode is unknown
+var ErrPrefetch = errors.New("invalid prefetch mode")
+
+// validatePrefetch checks the prefetch mode
+func validatePrefetch(mode string) error {
+	switch mode {
+	case config.PrefetchNone, config.PrefetchUsers, config.PrefetchAll:
+		return nil
+	}
+	return fmt.Errorf("%w: %q, expected %s, %s or %s", ErrPrefetch, mode, config.PrefetchNone, config.PrefetchUsers, config.PrefetchAll)
+}
+
+// prefetch takes a snapshot of the google directory before the members of
+// the groups are resolved, once per run. The users listed fill the user
+// cache, the members missing in it are still requested one by one. With
+// all, the members of every group are also requested once up front, the
+// google client keeps them to resolve the members and the nested groups.
+func (s *syncGSuite) prefetch(ctx context.Context) error {
+	if s.cfg.Prefetch == config.PrefetchNone || s.cfg.Prefetch == "" {
+		return nil
+	}
+
+	s.prefetchOnce.Do(func() {
+		s.prefetchErr = s.prefetchDirectory(ctx)
+	})
+	return s.prefetchErr
+}
+
+func (s *syncGSuite) prefetchDirectory(ctx context.Context) error {
+	log.Info("prefetch google users")
+	users, err := s.google.GetUsers(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		s.googleUsers.add(u)
+	}
+
+	if s.cfg.Prefetch != config.PrefetchAll {
+		log.WithField("users", len(users)).Info("prefetched google directory")
+		return nil
+	}
+
+	log.Info("prefetch google groups and their members")
+	groups, err := s.google.GetGroups(ctx, "")
+	if err != nil {
+		return err
+	}
+	err = forEach(ctx, s.cfg.Concurrency, len(groups), func(i int) error {
+		_, err := s.google.GetGroupMembers(ctx, groups[i])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"users": len(users), "groups": len(groups)}).Info("prefetched google directory")
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestPlanPrefetch(t *testing.T) {
+	// the plan is the same in every mode
+	var summary string
+	for _, mode := range []string{config.PrefetchNone, config.PrefetchUsers, config.PrefetchAll} {
+		s, g, _ := newTestSync()
+		s.cfg.Prefetch = mode
+
+		// a member of another domain is not in the snapshot
+		gg := g.groups[0]
+		g.members[gg.Id] = append(g.members[gg.Id], &admin.Member{Id: "external", Email: "external@other.com", Type: "USER"})
+		g.addGroup("Group-2", "group-2@email.com", g.users[0])
+
+		p, err := s.PlanGroupsUsers(context.Background(), []string{"email=group-1@email.com"})
+		assert.NoError(t, err)
+		if summary == "" {
+			summary = p.Summary()
+		}
+		assert.Equal(t, summary, p.Summary(), mode)
+
+		switch mode {
+		case config.PrefetchNone:
+			assert.Equal(t, map[string]int{"user-1@email.com": 1, "user-2@email.com": 1, "external@other.com": 1}, g.userRequests)
+			assert.Empty(t, g.memberRequests)
+		case config.PrefetchUsers:
+			assert.Equal(t, map[string]int{"external@other.com": 1}, g.userRequests)
+			assert.Empty(t, g.memberRequests)
+		case config.PrefetchAll:
+			assert.Equal(t, map[string]int{"external@other.com": 1}, g.userRequests)
+			assert.Equal(t, map[string]int{"ggroup-1": 1, "ggroup-2": 1}, g.memberRequests)
+		}
+
+		// the snapshot is taken once per run
+		_, err = s.PlanGroupsUsers(context.Background(), []string{"email=group-1@email.com"})
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, g.memberRequests["ggroup-2"], 1)
+	}
+}
+
+func TestValidatePrefetch(t *testing.T) {
+	assert.NoError(t, validatePrefetch(config.PrefetchNone))
+	assert.NoError(t, validatePrefetch(config.PrefetchUsers))
+	assert.NoError(t, validatePrefetch(config.PrefetchAll))
+	assert.True(t, errors.Is(validatePrefetch("groups"), ErrPrefetch))
+}
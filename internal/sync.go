@@ -17,9 +17,13 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/awslabs/ssosync/internal/aws"
 	"github.com/awslabs/ssosync/internal/config"
@@ -29,13 +33,20 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	admin "google.golang.org/api/admin/directory/v1"
+	reports "google.golang.org/api/admin/reports/v1"
 )
 
 // SyncGSuite is the interface for synchronizing users/groups
 type SyncGSuite interface {
-	SyncUsers(string) error
-	SyncGroups([]string) error
-	SyncGroupsUsers([]string) error
+	SyncUsers(context.Context, string) error
+	SyncGroups(context.Context, []string) error
+	SyncGroupsUsers(context.Context, []string) error
+	PlanGroupsUsers(context.Context, []string) (*Plan, error)
+	SyncOrgUnits(context.Context, []string) error
+	PlanOrgUnits(context.Context, []string) (*Plan, error)
+	SyncIncremental(context.Context, []string) error
+	SyncActivities(context.Context, []string, []*reports.Activity) error
+	ApplyPlan(context.Context, *Plan) error
 }
 
 // SyncGSuite is an object type that will synchronize real users and groups
@@ -43,18 +54,123 @@ type syncGSuite struct {
 	aws    aws.Client
 	google google.Client
 	cfg    *config.Config
+	ds     datastore.Datastore
 
+	// attributes are the SCIM attributes synced from the google users
+	attributes userMapping
+
+	// groupNamer gives the AWS names of the google groups
+	groupNamer *groupNamer
+
+	// patterns of the users and groups lists of the config
+	ignoreUsers     patterns
+	ignoreGroups    patterns
+	includeGroups   patterns
+	protectedUsers  patterns
+	protectedGroups patterns
+
+	// filters selecting the google users and groups synced
+	userFilter  *filter
+	groupFilter *filter
+
+	// createdUsers and createdGroups are the users and groups of the
+	// datastore, created by ssosync before it set their external ids
+	createdUsers  map[string]bool
+	createdGroups map[string]bool
+
+	// googleUsers are the google users fetched during the run
+	googleUsers *userCache
+
+	// prefetchOnce takes the snapshot of the google directory of the run
+	prefetchOnce sync.Once
+	prefetchErr  error
+
+	// mu guards users, it is filled by concurrent calls
+	mu    sync.Mutex
 	users map[string]*aws.User
 }
 
-// New will create a new SyncGSuite object
-func New(cfg *config.Config, a aws.Client, g google.Client) SyncGSuite {
+// New will create a new SyncGSuite object, the datastore keeps the
+// AWS ids of the google groups to find them after they are renamed.
+// The user attributes, group naming and patterns of the config must be
+// valid, newSync checks them with validateConfig.
+func New(cfg *config.Config, a aws.Client, g google.Client, ds datastore.Datastore) SyncGSuite {
+	attributes, err := parseUserMapping(cfg.UserAttributes)
+	if err != nil {
+		log.WithError(err).Warn("ignoring user attributes")
+	}
+
+	namer, err := newGroupNamer(cfg.GroupNameTemplate, cfg.GroupNameRewrites)
+	if err != nil {
+		log.WithError(err).Warn("ignoring group name template and rewrites")
+		namer = &groupNamer{}
+	}
+
+	createdUsers, err := ds.GetUsers()
+	if err != nil {
+		log.WithError(err).Warn("ignoring the users created by older versions")
+	}
+	createdGroups, err := ds.GetGroups()
+	if err != nil {
+		log.WithError(err).Warn("ignoring the groups created by older versions")
+	}
+
 	return &syncGSuite{
-		aws:    a,
-		google: g,
-		cfg:    cfg,
-		users:  make(map[string]*aws.User),
+		aws:             a,
+		google:          g,
+		cfg:             cfg,
+		ds:              ds,
+		attributes:      attributes,
+		groupNamer:      namer,
+		ignoreUsers:     lenientPatterns(cfg.IgnoreUsers),
+		ignoreGroups:    lenientPatterns(cfg.IgnoreGroups),
+		includeGroups:   lenientPatterns(cfg.IncludeGroups),
+		protectedUsers:  lenientPatterns(cfg.ProtectedUsers),
+		protectedGroups: lenientPatterns(cfg.ProtectedGroups),
+		userFilter:      lenientFilter(cfg.UserFilter, &admin.User{}),
+		groupFilter:     lenientFilter(cfg.GroupFilter, groupResource(cfg.SyncMethod)),
+		createdUsers:    stringSet(createdUsers),
+		createdGroups:   stringSet(createdGroups),
+		googleUsers:     newUserCache(g),
+		users:           make(map[string]*aws.User),
+	}
+}
+
+// validateConfig checks the parts of the config parsed by New
+func validateConfig(cfg *config.Config) error {
+	if _, err := parseUserMapping(cfg.UserAttributes); err != nil {
+		return err
+	}
+	if _, err := newGroupNamer(cfg.GroupNameTemplate, cfg.GroupNameRewrites); err != nil {
+		return err
+	}
+	for _, list := range [][]string{cfg.IgnoreUsers, cfg.IgnoreGroups, cfg.IncludeGroups, cfg.ProtectedUsers, cfg.ProtectedGroups} {
+		if _, err := compilePatterns(list); err != nil {
+			return err
+		}
+	}
+	if _, err := newFilter(cfg.UserFilter, &admin.User{}); err != nil {
+		return err
+	}
+	if _, err := newFilter(cfg.GroupFilter, groupResource(cfg.SyncMethod)); err != nil {
+		return err
+	}
+	if err := validateNestedGroups(cfg.NestedGroups); err != nil {
+		return err
+	}
+	if err := validatePrefetch(cfg.Prefetch); err != nil {
+		return err
+	}
+	if err := validateIncremental(cfg); err != nil {
+		return err
+	}
+	if err := validateDeprovision(cfg); err != nil {
+		return err
+	}
+	if cfg.SyncMethod == config.SyncMethodOrgUnits {
+		return validateOrgUnits(cfg.OrgUnits)
 	}
+	return nil
 }
 
 // SyncUsers will Sync Google Users to AWS SSO SCIM
@@ -68,20 +184,43 @@ func New(cfg *config.Config, a aws.Client, g google.Client) SyncGSuite {
 //  manager='janesmith@example.com'
 //  orgName=Engineering orgTitle:Manager
 //  EmploymentData.projects:'GeneGnomes'
-func (s *syncGSuite) SyncUsers(query string) error {
+func (s *syncGSuite) SyncUsers(ctx context.Context, query string) error {
+	pending, err := s.ds.GetDeprovisionedUsers()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	// the changes are gathered first, so the deletion limits are checked
+	// before any of them is made
+	var mu sync.Mutex
+	changes := make([]*userChange, 0)
+	change := func(c *userChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, c)
+	}
+	deprovision := func(u *aws.User, state string) {
+		action, since := s.deprovisionAction(u, state, pending, now)
+		if _, known := pending[u.ID]; action != "" || since.IsZero() == known {
+			change(&userChange{action: action, user: u, state: state, since: since})
+		}
+	}
+
 	log.Debug("get deleted users")
-	deletedUsers, err := s.google.GetDeletedUsers()
+	deletedUsers, err := s.google.GetDeletedUsers(ctx)
 	if err != nil {
 		log.Warn("Error Getting Deleted Users")
 		return err
 	}
 
-	for _, u := range deletedUsers {
+	err = forEach(ctx, s.cfg.Concurrency, len(deletedUsers), func(i int) error {
+		u := deletedUsers[i]
 		log.WithFields(log.Fields{
 			"email": u.PrimaryEmail,
-		}).Info("deleting google user")
+		}).Info("deprovisioning deleted google user")
 
-		uu, err := s.aws.FindUserByEmail(u.PrimaryEmail)
+		uu, err := s.aws.FindUserByEmail(ctx, u.PrimaryEmail)
 		if err != aws.ErrUserNotFound && err != nil {
 			log.WithFields(log.Fields{
 				"email": u.PrimaryEmail,
@@ -93,26 +232,29 @@ func (s *syncGSuite) SyncUsers(query string) error {
 			log.WithFields(log.Fields{
 				"email": u.PrimaryEmail,
 			}).Debug("User already deleted")
-			continue
+			return nil
 		}
 
-		if err := s.aws.DeleteUser(uu); err != nil {
-			log.WithFields(log.Fields{
-				"email": u.PrimaryEmail,
-			}).Warn("Error deleting user")
-			return err
-		}
+		deprovision(uu, userDeleted)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	log.Debug("get active google users")
-	googleUsers, err := s.google.GetUsers(query)
+	googleUsers, err := s.google.GetUsers(ctx, query)
 	if err != nil {
 		return err
 	}
 
-	for _, u := range googleUsers {
+	err = forEach(ctx, s.cfg.Concurrency, len(googleUsers), func(i int) error {
+		u := googleUsers[i]
 		if s.ignoreUser(u.PrimaryEmail) {
-			continue
+			return nil
+		}
+		if ok, err := s.selectUser(u); err != nil || !ok {
+			return err
 		}
 
 		ll := log.WithFields(log.Fields{
@@ -120,40 +262,147 @@ func (s *syncGSuite) SyncUsers(query string) error {
 		})
 
 		ll.Debug("finding user")
-		uu, _ := s.aws.FindUserByEmail(u.PrimaryEmail)
+		uu, err := s.findUser(ctx, u)
+		if err != nil {
+			return err
+		}
+
+		// the users suspended or archived in google may be deactivated,
+		// kept active or deleted
+		synced, state := s.syncedGoogleUser(u)
+		if synced == nil {
+			if uu != nil && sameIdentity(uu.ExternalID, u.Id) {
+				deprovision(uu, state)
+			}
+			return nil
+		}
+		u = synced
+
 		if uu != nil {
-			s.users[uu.Username] = uu
-			// Update the user when suspended state is changed
-			if uu.Active == u.Suspended {
-				log.Debug("Mismatch active/suspended, updating user")
-				// create new user object and update the user
-				_, err := s.aws.UpdateUser(aws.UpdateUser(
-					uu.ID,
-					u.Name.GivenName,
-					u.Name.FamilyName,
-					u.PrimaryEmail,
-					!u.Suspended))
-				if err != nil {
-					return err
-				}
+			if !sameIdentity(uu.ExternalID, u.Id) {
+				ll.WithField("id", uu.ID).Warn("skipping user, the AWS user with this email belongs to another google user")
+				return nil
 			}
-			continue
+			if _, ok := pending[uu.ID]; ok {
+				change(&userChange{user: uu})
+			}
+
+			updated := updatedAWSUser(uu.ID, u)
+			s.attributes.apply(updated, u)
+			if err := resolveManager(updated, s.awsUserID(ctx)); err != nil {
+				return err
+			}
+			s.keepProtectedActive(uu, updated)
+			s.keepUnowned(uu, updated)
+
+			// Update the user when suspended state, email, external id, name or a mapped attribute is changed
+			if userChanged(uu, updated, s.attributes) {
+				log.Debug("Mismatch active/suspended, email, external id, name or attributes, updating user")
+				s.addUser(updated)
+				change(&userChange{action: actionUpdate, user: updated})
+				return nil
+			}
+			s.addUser(uu)
+			return nil
 		}
 
-		ll.Info("creating user")
-		uu, err := s.aws.CreateUser(aws.NewUser(
-			u.Name.GivenName,
-			u.Name.FamilyName,
-			u.PrimaryEmail,
-			!u.Suspended))
+		nu := newAWSUser(u)
+		s.attributes.apply(nu, u)
+		if err := resolveManager(nu, s.awsUserID(ctx)); err != nil {
+			return err
+		}
+		change(&userChange{action: actionCreate, user: nu})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.limitUserDeletions(ctx, changes); err != nil {
+		return err
+	}
+
+	return forEach(ctx, s.cfg.Concurrency, len(changes), func(i int) error {
+		return s.applyUserChange(ctx, changes[i], pending)
+	})
+}
+
+// the changes made to the users and groups besides the deprovisioning
+// actions of the config
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+)
+
+// userChange is a change SyncUsers makes to an AWS user: a create, an
+// update or a deprovisioning action. Without action only the time the
+// user was first found to deprovision is recorded, or forgotten when zero.
+type userChange struct {
+	action string
+	user   *aws.User
+	state  string
+	since  time.Time
+}
+
+// limitUserDeletions checks the users SyncUsers deletes against the
+// deletion limits, the AWS users are only listed for --max-deletions-percent
+func (s *syncGSuite) limitUserDeletions(ctx context.Context, changes []*userChange) error {
+	plan := &Plan{}
+	for _, c := range changes {
+		if c.action == config.DeprovisionDelete {
+			plan.DeleteUsers = append(plan.DeleteUsers, c.user)
+		}
+	}
+
+	if s.cfg.MaxDeletionsPercent > 0 && len(plan.DeleteUsers) > 0 {
+		awsUsers, err := s.aws.GetUsers(ctx)
 		if err != nil {
 			return err
 		}
+		plan.AWSUsers = len(awsUsers)
+	}
+
+	return s.limitDeletions(plan)
+}
+
+// applyUserChange makes a change gathered by SyncUsers, it is only logged
+// when running with --dry-run
+func (s *syncGSuite) applyUserChange(ctx context.Context, c *userChange, pending map[string]time.Time) error {
+	ll := log.WithFields(log.Fields{"email": c.user.Username})
+
+	switch c.action {
+	case actionCreate:
+		if s.cfg.DryRun {
+			ll.Info("dry run: would create user")
+			s.addUser(c.user)
+			return nil
+		}
 
-		s.users[uu.Username] = uu
+		ll.Info("creating user")
+		uu, err := s.createUser(ctx, c.user)
+		if err != nil {
+			return err
+		}
+		s.addUser(uu)
+		return nil
+	case actionUpdate:
+		if s.cfg.DryRun {
+			ll.Warn("dry run: would update user")
+			return nil
+		}
+		_, err := s.aws.UpdateUser(ctx, c.user)
+		return err
+	default:
+		return s.deprovisionAWSUser(ctx, c.user, c.state, c.action, c.since, pending)
 	}
+}
 
-	return nil
+// addUser records a user synced by SyncUsers, SyncGroups syncs the
+// membership of these users only
+func (s *syncGSuite) addUser(u *aws.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.Username] = u
 }
 
 // SyncGroups will sync groups from Google -> AWS SSO
@@ -167,87 +416,239 @@ func (s *syncGSuite) SyncUsers(query string) error {
 //  name:contact* email:contact*
 //  name:Admin* email:aws-*
 //  email:aws-*
-func (s *syncGSuite) SyncGroups(queries []string) error {
-	googleGroups, err := s.getGroups(queries)
-	if err != nil {
+func (s *syncGSuite) SyncGroups(ctx context.Context, queries []string) error {
+	if err := s.prefetch(ctx); err != nil {
 		return err
 	}
 
-	correlatedGroups := make(map[string]*aws.Group)
+	googleGroups, err := s.getGroups(ctx, queries)
+	if err != nil {
+		return err
+	}
 
+	groups := make([]*admin.Group, 0, len(googleGroups))
 	for _, g := range googleGroups {
 		if s.ignoreGroup(g.Email) || !s.includeGroup(g.Email) {
 			continue
 		}
+		ok, err := s.selectGroup(g)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		groups = append(groups, g)
+	}
 
-		log := log.WithFields(log.Fields{
-			"group": g.Email,
-		})
+	// without template the groups are named by their email
+	names, err := s.groupNamer.names(groups, func(g *admin.Group) string { return g.Email })
+	if err != nil {
+		return err
+	}
 
-		log.Debug("Check group")
-		var group *aws.Group
+	// the changes are gathered first, so the deletion limits are checked
+	// before any of them is made
+	changes := make([]*groupChange, len(groups))
+	err = forEach(ctx, s.cfg.Concurrency, len(groups), func(i int) error {
+		c, err := s.groupChange(ctx, groups[i], names[i])
+		changes[i] = c
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-		gg, err := s.aws.FindGroupByDisplayName(g.Email)
-		if err != nil && err != aws.ErrGroupNotFound {
-			return err
+	plan := &Plan{}
+	for _, c := range changes {
+		if c == nil {
+			continue
 		}
-
-		if gg != nil {
-			log.Debug("Found group")
-			correlatedGroups[gg.DisplayName] = gg
-			group = gg
-		} else {
-			log.Info("Creating group in AWS")
-			newGroup, err := s.aws.CreateGroup(aws.NewGroup(g.Email))
-			if err != nil {
-				return err
-			}
-			correlatedGroups[newGroup.DisplayName] = newGroup
-			group = newGroup
+		plan.AWSMembers += c.members
+		if len(c.remove) > 0 {
+			plan.RemoveMembers = append(plan.RemoveMembers, &GroupMembership{Group: c.group, Users: c.remove})
 		}
+	}
+	if err := s.limitDeletions(plan); err != nil {
+		return err
+	}
 
-		groupMembers, err := s.google.GetDirectAndIndirectGroupMemberUsers(g)
-		if err != nil {
-			return err
+	return forEach(ctx, s.cfg.Concurrency, len(changes), func(i int) error {
+		if changes[i] == nil {
+			return nil
 		}
+		return s.applyGroupChange(ctx, changes[i])
+	})
+}
 
-		memberList := make(map[string]*admin.Member)
+// groupChange holds the changes SyncGroups makes to an AWS group and its
+// members, the group is created or updated first
+type groupChange struct {
+	google  *admin.Group
+	group   *aws.Group
+	action  string
+	add     []*aws.User
+	remove  []*aws.User
+	members int
+}
 
-		log.Info("Start group user sync")
+// groupChange computes the changes making the AWS group of a google group
+// and its members mirror it, nil when the group is skipped
+func (s *syncGSuite) groupChange(ctx context.Context, g *admin.Group, name string) (*groupChange, error) {
+	log := log.WithFields(log.Fields{
+		"group": g.Email,
+	})
 
-		for _, m := range groupMembers {
-			if _, ok := s.users[m.Email]; ok {
-				memberList[m.Email] = m
-			}
+	log.Debug("Check group")
+	c := &groupChange{google: g}
+
+	gg, err := s.findGroup(ctx, g, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if gg != nil && !sameIdentity(gg.ExternalID, g.Id) {
+		log.WithField("id", gg.ID).Warn("skipping group, the AWS group with this name belongs to another google group")
+		return nil, nil
+	}
+
+	// the groups matched by name and not owned by ssosync are not taken over
+	ext := externalID(g.Id)
+	if gg != nil && gg.ExternalID == "" && gg.DisplayName == name && !s.ownedGroup(gg) {
+		log.Info("not taking over group, the group is not owned by ssosync, use --manage-unowned")
+		ext = ""
+	}
+
+	if gg != nil && (gg.DisplayName != name || gg.ExternalID != ext) {
+		c.group = newAWSGroup(name, g)
+		c.group.ID = gg.ID
+		c.action = actionUpdate
+	} else if gg != nil {
+		log.Debug("Found group")
+		c.group = gg
+	} else {
+		c.group = newAWSGroup(name, g)
+		c.action = actionCreate
+	}
+
+	groupMembers, err := s.groupMembers(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+
+	memberList := make(map[string]*admin.Member)
+
+	log.Info("Start group user sync")
+
+	// the group may not exist yet in AWS
+	var memberIDs map[string]struct{}
+	listed := false
+	if c.group.ID != "" {
+		memberIDs, listed, err = s.getAWSGroupMemberIDs(ctx, c.group)
+		if err != nil {
+			return nil, err
+		}
+		c.members = len(memberIDs)
+	}
+
+	for _, m := range groupMembers {
+		if _, ok := s.users[m.Email]; ok {
+			memberList[m.Email] = m
 		}
+	}
 
-		for _, u := range s.users {
-			log.WithField("user", u.Username).Debug("Checking user is in group already")
-			b, err := s.aws.IsUserInGroup(u, group)
+	for _, u := range s.users {
+		log.WithField("user", u.Username).Debug("Checking user is in group already")
+		b := false
+		// in a dry run the user may not exist yet in AWS
+		if listed {
+			_, b = memberIDs[u.ID]
+		} else if u.ID != "" && c.group.ID != "" {
+			b, err = s.aws.IsUserInGroup(ctx, u, c.group)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			if b {
+				c.members++
+			}
+		}
 
-			if _, ok := memberList[u.Username]; ok {
-				if !b {
-					log.WithField("user", u.Username).Info("Adding user to group")
-					err := s.aws.AddUserToGroup(u, group)
-					if err != nil {
-						return err
-					}
-				}
-			} else {
-				if b {
-					log.WithField("user", u.Username).Warn("Removing user from group")
-					err := s.aws.RemoveUserFromGroup(u, group)
-					if err != nil {
-						return err
-					}
-				}
+		if _, ok := memberList[u.Username]; ok {
+			if !b {
+				c.add = append(c.add, u)
 			}
+		} else if b && s.removableUser(u, "removing user from group") {
+			c.remove = append(c.remove, u)
+		}
+	}
+
+	return c, nil
+}
+
+// applyGroupChange creates or updates the AWS group and then adds and
+// removes its members, the changes are only logged when running with --dry-run
+func (s *syncGSuite) applyGroupChange(ctx context.Context, c *groupChange) error {
+	log := log.WithFields(log.Fields{
+		"group": c.google.Email,
+	})
+
+	switch c.action {
+	case actionUpdate:
+		if s.cfg.DryRun {
+			log.WithField("name", c.group.DisplayName).Info("dry run: would update group in AWS")
+			break
+		}
+		log.WithField("name", c.group.DisplayName).Info("Updating group in AWS")
+		if _, err := s.aws.UpdateGroup(ctx, c.group); err != nil {
+			return err
+		}
+		if err := s.rememberGroupID(c.google.Id, c.group.ID); err != nil {
+			return err
+		}
+	case actionCreate:
+		if s.cfg.DryRun {
+			log.Info("dry run: would create group in AWS")
+			break
+		}
+		log.Info("Creating group in AWS")
+		newGroup, err := s.createGroup(ctx, c.group)
+		if err != nil {
+			return err
+		}
+		if err := s.rememberGroupID(c.google.Id, newGroup.ID); err != nil {
+			return err
+		}
+		c.group = newGroup
+	}
+
+	for _, u := range c.add {
+		if s.cfg.DryRun {
+			log.WithField("user", u.Username).Info("dry run: would add user to group")
+		} else {
+			log.WithField("user", u.Username).Info("Adding user to group")
+		}
+	}
+	for _, u := range c.remove {
+		if s.cfg.DryRun {
+			log.WithField("user", u.Username).Warn("dry run: would remove user from group")
+		} else {
+			log.WithField("user", u.Username).Warn("Removing user from group")
 		}
 	}
+	if s.cfg.DryRun {
+		return nil
+	}
 
+	if len(c.add) > 0 {
+		if err := s.aws.AddUsersToGroup(ctx, c.add, c.group); err != nil {
+			return err
+		}
+	}
+	if len(c.remove) > 0 {
+		if err := s.aws.RemoveUsersFromGroup(ctx, c.remove, c.group); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -263,20 +664,71 @@ func (s *syncGSuite) SyncGroups(queries []string) error {
 //  name:contact* email:contact*
 //  name:Admin* email:aws-*
 //  email:aws-*
-// process workflow:
-//  1) delete users in aws, these were deleted in google
-//  2) update users in aws, these were updated in google
-//  3) add users in aws, these were added in google
-//  4) add groups in aws and add its members, these were added in google
-//  5) validate equals aws an google groups members
-//  6) delete groups in aws, these were deleted in google
-func (s *syncGSuite) SyncGroupsUsers(queries []string) error {
-	googleGroups, err := s.getGroups(queries)
+// When running with --dry-run the changes are only logged.
+func (s *syncGSuite) SyncGroupsUsers(ctx context.Context, queries []string) error {
+	plan, err := s.PlanGroupsUsers(ctx, queries)
 	if err != nil {
 		return err
 	}
 
-	// Filter groups
+	return s.syncPlan(ctx, plan)
+}
+
+// syncPlan applies the plan, it is only logged when running with --dry-run
+func (s *syncGSuite) syncPlan(ctx context.Context, plan *Plan) error {
+	if s.cfg.DryRun {
+		plan.Log()
+	}
+
+	if err := s.limitDeletions(plan); err != nil || s.cfg.DryRun {
+		return err
+	}
+
+	return s.applyPlan(ctx, plan)
+}
+
+// limitDeletions checks the deletions of the plan before they are made,
+// with --dry-run exceeding the limits is only logged
+func (s *syncGSuite) limitDeletions(plan *Plan) error {
+	if s.cfg.DryRun {
+		if err := plan.CheckDeletionLimits(s.cfg.MaxDeletions, s.cfg.MaxDeletionsPercent); err != nil {
+			log.WithError(err).Warn("dry run: the sync would be aborted")
+		}
+		return nil
+	}
+
+	return s.checkDeletionLimits(plan)
+}
+
+// PlanGroupsUsers computes the changes SyncGroupsUsers would make to AWS SSO
+// without applying any of them
+func (s *syncGSuite) PlanGroupsUsers(ctx context.Context, queries []string) (*Plan, error) {
+	if err := s.prefetch(ctx); err != nil {
+		return nil, err
+	}
+
+	googleGroups, err := s.getGroups(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	googleGroups, err = s.filterGroups(googleGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("preparing list of google users and then google groups and their members")
+	googleUsers, googleGroupsUsers, err := s.getGoogleGroupsAndUsers(ctx, googleGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.plan(ctx, googleUsers, googleGroups, googleGroupsUsers, true, nil)
+}
+
+// filterGroups returns the groups not ignored and selected by the group
+// filter, with the name of their AWS group
+func (s *syncGSuite) filterGroups(googleGroups []*admin.Group) ([]*admin.Group, error) {
 	filteredGoogleGroups := []*admin.Group{}
 	for _, g := range googleGroups {
 
@@ -286,188 +738,661 @@ func (s *syncGSuite) SyncGroupsUsers(queries []string) error {
 			continue
 		}
 
+		ok, err := s.selectGroup(g)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
 		filteredGoogleGroups = append(filteredGoogleGroups, g)
 	}
-	googleGroups = filteredGoogleGroups
 
-	log.Debug("preparing list of google users and then google groups and their members")
-	googleUsers, googleGroupsUsers, err := s.getGoogleGroupsAndUsers(googleGroups)
+	// without template the groups are named by their name, the google
+	// groups get the name of their AWS group from here on
+	names, err := s.groupNamer.names(filteredGoogleGroups, func(g *admin.Group) string { return g.Name })
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for i, g := range filteredGoogleGroups {
+		g.Name = names[i]
 	}
 
+	return filteredGoogleGroups, nil
+}
+
+// plan computes the changes making AWS SSO mirror the google users, groups
+// and members of the groups by AWS name. Without syncGroups only the users
+// are synced, the AWS groups and their members are left as they are. With
+// a scope, only the google users and groups given are synced, see scope.
+func (s *syncGSuite) plan(ctx context.Context, googleUsers []*admin.User, googleGroups []*admin.Group, googleGroupsUsers map[string][]*admin.User, syncGroups bool, sc *scope) (*Plan, error) {
 	log.Info("get existing aws groups")
-	awsGroups, err := s.aws.GetGroups()
+	awsGroups, err := s.aws.GetGroups(ctx)
 	if err != nil {
 		log.Error("error getting aws groups")
-		return err
+		return nil, err
+	}
+
+	if err := s.correlateGroups(awsGroups); err != nil {
+		return nil, err
 	}
 
 	log.Info("get existing aws users")
-	awsUsers, err := s.aws.GetUsers()
+	awsUsers, err := s.aws.GetUsers(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Debug("preparing list of aws groups and their members")
-	awsGroupsUsers, err := s.getAWSGroupsAndUsers(awsGroups, awsUsers)
+	// the users suspended or archived in google may be deactivated, kept
+	// active or deleted
+	googleUsers, googleGroupsUsers, states := s.deprovisionGoogleUsers(googleUsers, googleGroupsUsers)
+
+	// create list of changes by operations
+	addAWSUsers, delAWSUsers, updateAWSUsers, _ := getUserOperations(awsUsers, googleUsers, s.attributes)
+	addAWSUsers, otherAWSUsers := skipOtherUsers(awsUsers, addAWSUsers)
+	dp, err := s.newDeprovisioning()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	dp.forgetDeprovisioned(awsUsers, delAWSUsers)
+	if sc != nil {
+		addAWSUsers, delAWSUsers = sc.users(addAWSUsers, delAWSUsers, states)
+	} else if err := s.addDeletedGoogleUsers(ctx, states, delAWSUsers); err != nil {
+		return nil, err
 	}
 
-	// create list of changes by operations
-	addAWSUsers, delAWSUsers, updateAWSUsers, _ := getUserOperations(awsUsers, googleUsers)
-	addAWSGroups, delAWSGroups, equalAWSGroups := getGroupOperations(awsGroups, googleGroups)
+	// only the users owned by ssosync and not protected are deleted or
+	// deactivated, following the policy of their state
+	delAWSUsers, deactivateAWSUsers := s.deprovisionAWSUsers(dp, awsUsers, delAWSUsers, states)
+	updateAWSUsers = append(updateAWSUsers, deactivateAWSUsers...)
+	var addAWSGroups, delAWSGroups, updateAWSGroups, equalAWSGroups []*aws.Group
+	awsGroupsUsers := make(map[string][]*aws.User)
+	if syncGroups {
+		addAWSGroups, delAWSGroups, updateAWSGroups, equalAWSGroups = getGroupOperations(awsGroups, googleGroups)
+		updateAWSGroups, equalAWSGroups = s.keepUnownedGroups(awsGroups, updateAWSGroups, equalAWSGroups)
+		addAWSGroups = skipOtherGroups(awsGroups, addAWSGroups)
+		listedAWSGroups := awsGroups
+		if sc != nil {
+			listedAWSGroups, delAWSGroups = sc.groups(awsGroups, updateAWSGroups, equalAWSGroups)
+		}
 
-	log.Info("syncing changes")
-	// delete aws users (deleted in google)
-	log.Debug("deleting aws users deleted in google")
-	for _, awsUser := range delAWSUsers {
+		log.Debug("preparing list of aws groups and their members")
+		awsGroupsUsers, err = s.getAWSGroupsAndUsers(ctx, listedAWSGroups, awsUsers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	renameMembers(awsGroupsUsers, awsGroups, updateAWSUsers, updateAWSGroups)
+	addUsersToGroup, deleteUsersFromGroup, _ := getGroupUsersOperations(googleGroupsUsers, awsGroupsUsers)
+
+	// only the groups owned by ssosync and not protected are deleted, the
+	// protected users are not deactivated either
+	removableAWSGroups := make([]*aws.Group, 0, len(delAWSGroups))
+	for _, g := range delAWSGroups {
+		if s.removableGroup(g) {
+			removableAWSGroups = append(removableAWSGroups, g)
+		}
+	}
+	delAWSGroups = removableAWSGroups
+
+	awsUsersByID := make(map[string]*aws.User, len(awsUsers))
+	for _, awsUser := range awsUsers {
+		awsUsersByID[awsUser.ID] = awsUser
+	}
+	changedAWSUsers := make([]*aws.User, 0, len(updateAWSUsers))
+	for _, u := range updateAWSUsers {
+		if old := awsUsersByID[u.ID]; old != nil {
+			kept := s.keepProtectedActive(old, u)
+			if s.keepUnowned(old, u) {
+				kept = true
+			}
+			if kept && !userChanged(old, u, s.attributes) {
+				continue
+			}
+		}
+		changedAWSUsers = append(changedAWSUsers, u)
+	}
+	updateAWSUsers = changedAWSUsers
+
+	awsMembers := 0
+	for _, users := range awsGroupsUsers {
+		awsMembers += len(users)
+	}
+
+	plan := &Plan{
+		Version:      PlanVersion,
+		Created:      time.Now().UTC(),
+		Endpoint:     s.cfg.SCIMEndpoint,
+		AWSUsers:     len(awsUsers),
+		AWSGroups:    len(awsGroups),
+		AWSMembers:   awsMembers,
+		CreateUsers:  addAWSUsers,
+		UpdateUsers:  updateAWSUsers,
+		DeleteUsers:  delAWSUsers,
+		CreateGroups: addAWSGroups,
+		UpdateGroups: updateAWSGroups,
+		DeleteGroups: delAWSGroups,
+
+		PreviousUsers:  make(map[string]*aws.User, len(updateAWSUsers)),
+		PreviousGroups: make(map[string]*aws.Group, len(updateAWSGroups)),
+
+		Deprovisioned:       dp.set,
+		ForgetDeprovisioned: dp.forget,
+	}
+	for _, u := range updateAWSUsers {
+		if old := awsUsersByID[u.ID]; old != nil {
+			plan.PreviousUsers[u.ID] = old
+		}
+	}
+	awsGroupsByID := make(map[string]*aws.Group, len(awsGroups))
+	for _, g := range awsGroups {
+		awsGroupsByID[g.ID] = g
+	}
+	for _, g := range updateAWSGroups {
+		if old := awsGroupsByID[g.ID]; old != nil {
+			plan.PreviousGroups[g.ID] = old
+		}
+	}
+
+	awsUsersByName := make(map[string]*aws.User)
+	for _, awsUser := range awsUsers {
+		awsUsersByName[awsUser.Username] = awsUser
+	}
+	for _, awsUser := range updateAWSUsers {
+		awsUsersByName[awsUser.Username] = awsUser
+	}
+
+	// members of the new groups and members missing in the existing groups,
+	// users that don't exist yet in aws are resolved when the plan is applied
+	existingAWSGroups := make([]*aws.Group, 0, len(updateAWSGroups)+len(equalAWSGroups))
+	existingAWSGroups = append(existingAWSGroups, updateAWSGroups...)
+	existingAWSGroups = append(existingAWSGroups, equalAWSGroups...)
+	syncedAWSGroups := append(append([]*aws.Group{}, addAWSGroups...), existingAWSGroups...)
+	for _, awsGroup := range syncedAWSGroups {
+		m := &GroupMembership{Group: awsGroup}
+		for _, googleUser := range addUsersToGroup[awsGroup.DisplayName] {
+			if _, ok := otherAWSUsers[googleUser.PrimaryEmail]; ok {
+				continue
+			}
+			if awsUser, ok := awsUsersByName[googleUser.PrimaryEmail]; ok {
+				m.Users = append(m.Users, awsUser)
+			} else {
+				m.Users = append(m.Users, newAWSUser(googleUser))
+			}
+		}
+		if len(m.Users) > 0 {
+			plan.AddMembers = append(plan.AddMembers, m)
+		}
+	}
+
+	// members of groups that are going to be deleted are not removed one by one
+	for _, awsGroup := range existingAWSGroups {
+		users := s.removableUsers(deleteUsersFromGroup[awsGroup.DisplayName], "removing user from group "+awsGroup.DisplayName)
+		if len(users) > 0 {
+			plan.RemoveMembers = append(plan.RemoveMembers, &GroupMembership{Group: awsGroup, Users: users})
+		}
+	}
+
+	plan.sort()
+	log.Info("plan: " + plan.Summary())
+
+	return plan, nil
+}
+
+// ApplyPlan checks that AWS SSO is still in the state the plan was computed
+// from and then makes the changes of the plan, a stale plan is refused with
+// ErrStalePlan before any change is made
+func (s *syncGSuite) ApplyPlan(ctx context.Context, plan *Plan) error {
+	if plan.Version != PlanVersion {
+		return fmt.Errorf("%w: %d, expected %d", ErrPlanVersion, plan.Version, PlanVersion)
+	}
+
+	if plan.Endpoint != s.cfg.SCIMEndpoint {
+		return fmt.Errorf("%w: plan was created for endpoint %s", ErrStalePlan, plan.Endpoint)
+	}
+
+	if err := s.checkDeletionLimits(plan); err != nil {
+		return err
+	}
+
+	log.WithField("created", plan.Created).Info("validating plan")
+	if err := s.validatePlan(ctx, plan); err != nil {
+		return err
+	}
+
+	return s.applyPlan(ctx, plan)
+}
+
+// checkDeletionLimits refuses plans deleting more than allowed by
+// --max-deletions and --max-deletions-percent, unless --force is used
+func (s *syncGSuite) checkDeletionLimits(plan *Plan) error {
+	err := plan.CheckDeletionLimits(s.cfg.MaxDeletions, s.cfg.MaxDeletionsPercent)
+	if err != nil && s.cfg.Force {
+		log.WithError(err).Warn("deletion limit exceeded, applying anyway because of --force")
+		return nil
+	}
+	return err
+}
+
+// validatePlan checks the preconditions of every change in the plan
+// against the current state of AWS SSO
+func (s *syncGSuite) validatePlan(ctx context.Context, plan *Plan) error {
+	var mu sync.Mutex
+	failed := 0
+	stale := func(fields log.Fields, msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		log.WithFields(fields).Error("stale plan: " + msg)
+		failed++
+	}
+
+	// users to create must not exist yet
+	err := forEach(ctx, s.cfg.Concurrency, len(plan.CreateUsers), func(i int) error {
+		awsUser := plan.CreateUsers[i]
+		_, err := s.aws.FindUserByEmail(ctx, awsUser.Username)
+		if err == nil {
+			stale(log.Fields{"user": awsUser.Username}, "user to create already exists")
+		} else if err != aws.ErrUserNotFound {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// users to update must still exist and be as they were when the plan
+	// was created, they are looked up by id as their email may change
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.UpdateUsers), func(i int) error {
+		awsUser := plan.UpdateUsers[i]
+		if awsUser.ID == "" {
+			return nil
+		}
+		awsUserFull, err := s.aws.FindUserByID(ctx, awsUser.ID)
+		if err == aws.ErrUserNotFound {
+			stale(log.Fields{"user": awsUser.Username, "id": awsUser.ID}, "user does not exist anymore")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		previous, ok := plan.PreviousUsers[awsUser.ID]
+		if !ok || userChanged(previous, awsUserFull, s.attributes) {
+			stale(log.Fields{"user": awsUser.Username, "id": awsUser.ID}, "user has been changed")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// users to delete must still be the same users
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.DeleteUsers), func(i int) error {
+		awsUser := plan.DeleteUsers[i]
+		awsUserFull, err := s.aws.FindUserByEmail(ctx, awsUser.Username)
+		if err == aws.ErrUserNotFound {
+			stale(log.Fields{"user": awsUser.Username}, "user does not exist anymore")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if awsUser.ID != "" && awsUserFull.ID != awsUser.ID {
+			stale(log.Fields{"user": awsUser.Username, "id": awsUserFull.ID}, "user has been replaced")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// groups to create must not exist yet
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.CreateGroups), func(i int) error {
+		awsGroup := plan.CreateGroups[i]
+		_, err := s.aws.FindGroupByDisplayName(ctx, awsGroup.DisplayName)
+		if err == nil {
+			stale(log.Fields{"group": awsGroup.DisplayName}, "group to create already exists")
+		} else if err != aws.ErrGroupNotFound {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// groups to update must still exist and be as they were when the plan
+	// was created, their new name must not be used by another group
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.UpdateGroups), func(i int) error {
+		awsGroup := plan.UpdateGroups[i]
+		awsGroupFull, err := s.aws.FindGroupByID(ctx, awsGroup.ID)
+		if err == aws.ErrGroupNotFound {
+			stale(log.Fields{"group": awsGroup.DisplayName, "id": awsGroup.ID}, "group does not exist anymore")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		previous, ok := plan.PreviousGroups[awsGroup.ID]
+		if !ok || groupChanged(previous, awsGroupFull) {
+			stale(log.Fields{"group": awsGroup.DisplayName, "id": awsGroup.ID}, "group has been changed")
+			return nil
+		}
+
+		awsGroupFull, err = s.aws.FindGroupByDisplayName(ctx, awsGroup.DisplayName)
+		if err == aws.ErrGroupNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if awsGroupFull.ID != awsGroup.ID {
+			stale(log.Fields{"group": awsGroup.DisplayName, "id": awsGroupFull.ID}, "group name is used by another group")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// groups to delete must still be the same groups
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.DeleteGroups), func(i int) error {
+		awsGroup := plan.DeleteGroups[i]
+		awsGroupFull, err := s.aws.FindGroupByDisplayName(ctx, awsGroup.DisplayName)
+		if err == aws.ErrGroupNotFound {
+			stale(log.Fields{"group": awsGroup.DisplayName}, "group does not exist anymore")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if awsGroup.ID != "" && awsGroupFull.ID != awsGroup.ID {
+			stale(log.Fields{"group": awsGroup.DisplayName, "id": awsGroupFull.ID}, "group has been replaced")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// members to add must not be members yet, only users and groups
+	// that already exist can be checked
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.AddMembers), func(i int) error {
+		m := plan.AddMembers[i]
+		if m.Group.ID == "" {
+			return nil
+		}
+		for _, awsUser := range m.Users {
+			if awsUser.ID == "" {
+				continue
+			}
+			found, err := s.aws.IsUserInGroup(ctx, awsUser, m.Group)
+			if err != nil {
+				return err
+			}
+			if found {
+				stale(log.Fields{"group": m.Group.DisplayName, "user": awsUser.Username}, "user is already a member of the group")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// members to remove must still be members
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.RemoveMembers), func(i int) error {
+		m := plan.RemoveMembers[i]
+		for _, awsUser := range m.Users {
+			found, err := s.aws.IsUserInGroup(ctx, awsUser, m.Group)
+			if err != nil {
+				return err
+			}
+			if !found {
+				stale(log.Fields{"group": m.Group.DisplayName, "user": awsUser.Username}, "user is not a member of the group anymore")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d precondition(s) failed", ErrStalePlan, failed)
+	}
+
+	return nil
+}
+
+// applyPlan makes the changes of the plan in AWS SSO, the changes of each
+// step are made concurrently but a step only starts when the previous one
+// is done, so users exist before they are added to groups
+// process workflow:
+//  1) delete users in aws, these were deleted in google
+//  2) update users in aws, these were updated in google
+//  3) add users in aws, these were added in google
+//  4) update groups in aws, these were renamed in google
+//  5) add groups in aws, these were added in google
+//  6) add and remove group members, so aws and google groups members are equals
+//  7) delete groups in aws, these were deleted in google
+func (s *syncGSuite) applyPlan(ctx context.Context, plan *Plan) error {
+	// the grace periods start once the plan is applied
+	if err := s.recordDeprovisioned(plan.Deprovisioned, plan.ForgetDeprovisioned); err != nil {
+		return err
+	}
+
+	log.Info("syncing changes")
+	// delete aws users (deleted in google)
+	log.Debug("deleting aws users deleted in google")
+	err := forEach(ctx, s.cfg.Concurrency, len(plan.DeleteUsers), func(i int) error {
+		awsUser := plan.DeleteUsers[i]
 
 		log := log.WithFields(log.Fields{"user": awsUser.Username})
 
 		log.Debug("finding user")
-		awsUserFull, err := s.aws.FindUserByEmail(awsUser.Username)
+		awsUserFull, err := s.aws.FindUserByEmail(ctx, awsUser.Username)
 		if err != nil {
 			return err
 		}
 
 		log.Warn("deleting user")
-		if err := s.aws.DeleteUser(awsUserFull); err != nil {
+		if err := s.deleteUser(ctx, awsUserFull); err != nil {
 			log.Error("error deleting user")
 			return err
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// update aws users (updated in google)
 	log.Debug("updating aws users updated in google")
-	for _, awsUser := range updateAWSUsers {
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.UpdateUsers), func(i int) error {
+		awsUser := plan.UpdateUsers[i]
 
 		log := log.WithFields(log.Fields{"user": awsUser.Username})
 
-		log.Debug("finding user")
-		awsUserFull, err := s.aws.FindUserByEmail(awsUser.Username)
-		if err != nil {
-			return err
+		if awsUser.ID == "" {
+			log.Debug("finding user")
+			awsUserFull, err := s.aws.FindUserByEmail(ctx, awsUser.Username)
+			if err != nil {
+				return err
+			}
+			awsUser.ID = awsUserFull.ID
 		}
 
 		log.Warn("updating user")
-		_, err = s.aws.UpdateUser(awsUserFull)
+		_, err := s.aws.UpdateUser(ctx, awsUser)
 		if err != nil {
 			log.Error("error updating user")
 			return err
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// add aws users (added in google)
 	log.Debug("creating aws users added in google")
-	for _, awsUser := range addAWSUsers {
+	newUsers := make([]*aws.User, len(plan.CreateUsers))
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.CreateUsers), func(i int) error {
+		awsUser := plan.CreateUsers[i]
 		// Due to limits in users listing, the user may already exists
 		// see https://docs.aws.amazon.com/singlesignon/latest/developerguide/listusers.html
-		user, _ := s.aws.FindUserByEmail(awsUser.Username)
+		user, _ := s.aws.FindUserByEmail(ctx, awsUser.Username)
 		if user == nil {
 			log := log.WithFields(log.Fields{"user": awsUser.Username})
 
 			log.Info("creating user")
-			_, err := s.aws.CreateUser(awsUser)
+			newUser, err := s.createUser(ctx, awsUser)
 			if err != nil {
 				log.Error("error creating user")
 				return err
 			}
+			user = newUser
+		}
+		newUsers[i] = user
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// users created in this run, needed to add them to their groups
+	createdUsers := make(map[string]*aws.User)
+	for _, user := range newUsers {
+		createdUsers[user.Username] = user
+	}
+
+	// update aws groups (renamed in google)
+	log.Debug("updating aws groups renamed in google")
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.UpdateGroups), func(i int) error {
+		awsGroup := plan.UpdateGroups[i]
+
+		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
+
+		log.Info("updating group")
+		if _, err := s.aws.UpdateGroup(ctx, awsGroup); err != nil {
+			log.Error("error updating group")
+			return err
 		}
+		return s.rememberGroupID(googleID(awsGroup.ExternalID), awsGroup.ID)
+	})
+	if err != nil {
+		return err
 	}
 
 	// add aws groups (added in google)
 	log.Debug("creating aws groups added in google")
-	for _, awsGroup := range addAWSGroups {
+	newGroups := make([]*aws.Group, len(plan.CreateGroups))
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.CreateGroups), func(i int) error {
+		awsGroup := plan.CreateGroups[i]
 
 		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
 
 		log.Info("creating group")
-		awsGroupFull, err := s.aws.CreateGroup(awsGroup)
+		awsGroupFull, err := s.createGroup(ctx, awsGroup)
 		if err != nil {
 			log.Error("creating group")
 			return err
 		}
-
-		// add members of the new group
-		for _, googleUser := range googleGroupsUsers[awsGroup.DisplayName] {
-
-			// equivalent aws user of google user on the fly
-			log.Debug("finding user")
-			awsUserFull, err := s.aws.FindUserByEmail(googleUser.PrimaryEmail)
-			if err != nil {
-				return err
-			}
-
-			log.WithField("user", awsUserFull.Username).Info("adding user to group")
-			err = s.aws.AddUserToGroup(awsUserFull, awsGroupFull)
-			if err != nil {
-				return err
-			}
-		}
+		newGroups[i] = awsGroupFull
+		return s.rememberGroupID(googleID(awsGroup.ExternalID), awsGroupFull.ID)
+	})
+	if err != nil {
+		return err
 	}
 
-	// list of users to to be removed in aws groups
-	deleteUsersFromGroup, _ := getGroupUsersOperations(googleGroupsUsers, awsGroupsUsers)
+	// groups created in this run, needed to add their members
+	createdGroups := make(map[string]*aws.Group)
+	for _, group := range newGroups {
+		createdGroups[group.DisplayName] = group
+	}
 
 	// validate groups members are equal in aws and google
 	log.Debug("validating groups members, equals in aws and google")
-	for _, awsGroup := range equalAWSGroups {
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.AddMembers), func(i int) error {
+		m := plan.AddMembers[i]
+
+		awsGroup := m.Group
+		if awsGroup.ID == "" {
+			awsGroup = createdGroups[m.Group.DisplayName]
+		}
+		if awsGroup == nil {
+			return fmt.Errorf("group %s was not created", m.Group.DisplayName)
+		}
 
-		// add members of the new group
 		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
 
-		for _, googleUser := range googleGroupsUsers[awsGroup.DisplayName] {
+		users := make([]*aws.User, 0, len(m.Users))
+		for _, awsUser := range m.Users {
 
-			log.WithField("user", googleUser.PrimaryEmail).Debug("finding user")
-			awsUserFull, err := s.aws.FindUserByEmail(googleUser.PrimaryEmail)
-			if err != nil {
-				return err
+			awsUserFull := awsUser
+			if awsUserFull.ID == "" {
+				awsUserFull = createdUsers[awsUser.Username]
 			}
-
-			log.WithField("user", awsUserFull.Username).Debug("checking user is in group already")
-			b, err := s.aws.IsUserInGroup(awsUserFull, awsGroup)
-			if err != nil {
-				return err
-			}
-
-			if !b {
-				log.WithField("user", awsUserFull.Username).Info("adding user to group")
-				err := s.aws.AddUserToGroup(awsUserFull, awsGroup)
+			if awsUserFull == nil {
+				// equivalent aws user of google user on the fly
+				log.WithField("user", awsUser.Username).Debug("finding user")
+				u, err := s.aws.FindUserByEmail(ctx, awsUser.Username)
 				if err != nil {
 					return err
 				}
+				awsUserFull = u
 			}
+
+			log.WithField("user", awsUserFull.Username).Info("adding user to group")
+			users = append(users, awsUserFull)
 		}
 
-		for _, awsUser := range deleteUsersFromGroup[awsGroup.DisplayName] {
+		return s.aws.AddUsersToGroup(ctx, users, awsGroup)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.RemoveMembers), func(i int) error {
+		m := plan.RemoveMembers[i]
+
+		log := log.WithFields(log.Fields{"group": m.Group.DisplayName})
+
+		for _, awsUser := range m.Users {
 			log.WithField("user", awsUser.Username).Warn("removing user from group")
-			err := s.aws.RemoveUserFromGroup(awsUser, awsGroup)
-			if err != nil {
-				return err
-			}
 		}
+
+		return s.aws.RemoveUsersFromGroup(ctx, m.Users, m.Group)
+	})
+	if err != nil {
+		return err
 	}
 
 	// delete aws groups (deleted in google)
 	log.Debug("delete aws groups deleted in google")
-	for _, awsGroup := range delAWSGroups {
+	err = forEach(ctx, s.cfg.Concurrency, len(plan.DeleteGroups), func(i int) error {
+		awsGroup := plan.DeleteGroups[i]
 
 		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
 
 		log.Debug("finding group")
-		awsGroupFull, err := s.aws.FindGroupByDisplayName(awsGroup.DisplayName)
+		awsGroupFull, err := s.aws.FindGroupByDisplayName(ctx, awsGroup.DisplayName)
 		if err != nil {
 			return err
 		}
 
 		log.Warn("deleting group")
-		err = s.aws.DeleteGroup(awsGroupFull)
+		err = s.deleteGroup(ctx, awsGroupFull)
 		if err != nil {
 			log.Error("deleting group")
 			return err
 		}
+		return s.forgetGroupID(awsGroupFull.ID)
+	})
+	if err != nil {
+		return err
 	}
 
 	log.Info("sync completed")
@@ -477,60 +1402,101 @@ func (s *syncGSuite) SyncGroupsUsers(queries []string) error {
 
 // getGoogleGroupsAndUsers return a list of google users members of googleGroups
 // and a map of google groups and its users' list
-func (s *syncGSuite) getGoogleGroupsAndUsers(googleGroups []*admin.Group) ([]*admin.User, map[string][]*admin.User, error) {
-	gUsers := make([]*admin.User, 0)
-	gGroupsUsers := make(map[string][]*admin.User)
-
-	gUniqUsers := make(map[string]*admin.User)
-
+func (s *syncGSuite) getGoogleGroupsAndUsers(ctx context.Context, googleGroups []*admin.Group) ([]*admin.User, map[string][]*admin.User, error) {
+	groups := make([]*admin.Group, 0, len(googleGroups))
 	for _, g := range googleGroups {
-
-		log := log.WithFields(log.Fields{"group": g.Name})
-
 		if s.ignoreGroup(g.Email) {
-			log.Debug("ignoring group")
+			log.WithField("group", g.Name).Debug("ignoring group")
 			continue
 		}
+		groups = append(groups, g)
+	}
 
-		log.Debug("get group members from google")
-		groupMembers, err := s.google.GetDirectAndIndirectGroupMemberUsers(g)
+	groupsMembers := make([][]*admin.Member, len(groups))
+	err := forEach(ctx, s.cfg.Concurrency, len(groups), func(i int) error {
+		log.WithField("group", groups[i].Name).Debug("get group members from google")
+		groupMembers, err := s.groupMembers(ctx, groups[i])
 		if err != nil {
-			return nil, nil, err
+			return err
 		}
+		groupsMembers[i] = groupMembers
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		log.Debug("get users")
-		membersUsers := make([]*admin.User, 0)
-
+	// each member is looked up once, even when it is in many groups
+	emails := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, groupMembers := range groupsMembers {
 		for _, m := range groupMembers {
-
 			if s.ignoreUser(m.Email) {
 				log.WithField("id", m.Email).Debug("ignoring user")
 				continue
 			}
-
-			log.WithField("id", m.Email).Debug("get user")
-			q := fmt.Sprintf("email:%s", m.Email)
-			u, err := s.google.GetUsers(q) // TODO: implement GetUser(m.Email)
-			if err != nil {
-				return nil, nil, err
+			if _, ok := seen[m.Email]; !ok {
+				seen[m.Email] = struct{}{}
+				emails = append(emails, m.Email)
 			}
+		}
+	}
 
-			if len(u) != 0 {
-				membersUsers = append(membersUsers, u[0])
-				_, ok := gUniqUsers[m.Email]
-				if !ok {
-					gUniqUsers[m.Email] = u[0]
-				}
-			} else {
+	log.Debug("get users")
+	users := make([]*admin.User, len(emails))
+	err = forEach(ctx, s.cfg.Concurrency, len(emails), func(i int) error {
+		log.WithField("id", emails[i]).Debug("get user")
+		u, err := s.googleUsers.get(ctx, emails[i])
+		if err != nil {
+			return err
+		}
+		users[i] = u
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gUniqUsers := make(map[string]*admin.User)
+	filtered := make(map[string]struct{})
+	for i, email := range emails {
+		if users[i] == nil {
+			continue
+		}
+		ok, err := s.selectUser(users[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			filtered[email] = struct{}{}
+			continue
+		}
+		gUniqUsers[email] = users[i]
+	}
+
+	gGroupsUsers := make(map[string][]*admin.User)
+	for i, g := range groups {
+		log := log.WithFields(log.Fields{"group": g.Name})
+
+		membersUsers := make([]*admin.User, 0)
+		for _, m := range groupsMembers[i] {
+			if s.ignoreUser(m.Email) {
+				continue
+			}
+			if u, ok := gUniqUsers[m.Email]; ok {
+				membersUsers = append(membersUsers, u)
+			} else if _, ok := filtered[m.Email]; !ok {
 				log.WithField("member", m.Email).Warn("ignoring group member because it is not a user, looks like a group inside the group")
 			}
-
 		}
 		gGroupsUsers[g.Name] = membersUsers
 	}
 
-	for _, user := range gUniqUsers {
-		gUsers = append(gUsers, user)
+	gUsers := make([]*admin.User, 0, len(gUniqUsers))
+	for _, email := range emails {
+		if u, ok := gUniqUsers[email]; ok {
+			gUsers = append(gUsers, u)
+		}
 	}
 
 	return gUsers, gGroupsUsers, nil
@@ -538,39 +1504,91 @@ func (s *syncGSuite) getGoogleGroupsAndUsers(googleGroups []*admin.Group) ([]*ad
 
 // getAWSGroupsAndUsers return a list of google users members of googleGroups
 // and a map of google groups and its users' list
-func (s *syncGSuite) getAWSGroupsAndUsers(awsGroups []*aws.Group, awsUsers []*aws.User) (map[string][]*aws.User, error) {
-	awsGroupsUsers := make(map[string][]*aws.User)
+func (s *syncGSuite) getAWSGroupsAndUsers(ctx context.Context, awsGroups []*aws.Group, awsUsers []*aws.User) (map[string][]*aws.User, error) {
+	groupsUsers := make([][]*aws.User, len(awsGroups))
 
-	for _, awsGroup := range awsGroups {
+	err := forEach(ctx, s.cfg.Concurrency, len(awsGroups), func(i int) error {
+		awsGroup := awsGroups[i]
 
 		users := make([]*aws.User, 0)
 
 		log.WithFields(log.Fields{"group": awsGroup.DisplayName}).Debug("get group members from aws")
-		// NOTE: AWS has not implemented yet some method to get the groups members https://docs.aws.amazon.com/singlesignon/latest/developerguide/listgroups.html
-		// so, we need to check each user in each group which are too many unnecessary API calls
+		memberIDs, listed, err := s.getAWSGroupMemberIDs(ctx, awsGroup)
+		if err != nil {
+			return err
+		}
+
 		for _, user := range awsUsers {
+			if listed {
+				if _, ok := memberIDs[user.ID]; ok {
+					users = append(users, user)
+				}
+				continue
+			}
+
 			log.WithFields(log.Fields{"group": awsGroup.DisplayName, "user": user.Username}).Debug("checking if user is member of")
-			found, err := s.aws.IsUserInGroup(user, awsGroup)
+			found, err := s.aws.IsUserInGroup(ctx, user, awsGroup)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			if found {
 				users = append(users, user)
 			}
 		}
 
-		awsGroupsUsers[awsGroup.DisplayName] = users
+		groupsUsers[i] = users
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	awsGroupsUsers := make(map[string][]*aws.User)
+	for i, awsGroup := range awsGroups {
+		awsGroupsUsers[awsGroup.DisplayName] = groupsUsers[i]
 	}
 	return awsGroupsUsers, nil
 }
 
-// getGroups returns Google Groups from multiple queries.
-func (s *syncGSuite) getGroups(queries []string) ([]*admin.Group, error) {
+// getAWSGroupMemberIDs returns the ids of the members of the AWS group with a
+// single request. listed is false when the endpoint does not return the members,
+// then they must be checked one by one with IsUserInGroup.
+func (s *syncGSuite) getAWSGroupMemberIDs(ctx context.Context, awsGroup *aws.Group) (ids map[string]struct{}, listed bool, err error) {
+	memberIDs, err := s.aws.GetGroupMemberIDs(ctx, awsGroup)
+	if errors.Is(err, aws.ErrMembersNotListed) {
+		log.WithField("group", awsGroup.DisplayName).Debug("group members not listed, checking each user")
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	ids = make(map[string]struct{}, len(memberIDs))
+	for _, id := range memberIDs {
+		ids[id] = struct{}{}
+	}
+
+	return ids, true, nil
+}
+
+// getGroups returns Google Groups from multiple queries, with the groups
+// nested in them when the nesting is mirrored.
+func (s *syncGSuite) getGroups(ctx context.Context, queries []string) ([]*admin.Group, error) {
+	groups, err := s.listGroups(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.withNestedGroups(ctx, groups)
+}
+
+// listGroups returns Google Groups from multiple queries, once each.
+func (s *syncGSuite) listGroups(ctx context.Context, queries []string) ([]*admin.Group, error) {
 	uniqueGroups := map[string]*admin.Group{}
 
 	for _, query := range queries {
 		log.WithField("query", query).Debug("get google groups")
-		googleGroups, err := s.google.GetGroups(query)
+		googleGroups, err := s.google.GetGroups(ctx, query)
 		if err != nil {
 			return nil, err
 		}
@@ -580,95 +1598,240 @@ func (s *syncGSuite) getGroups(queries []string) ([]*admin.Group, error) {
 		}
 	}
 
-	groups := make([]*admin.Group, len(uniqueGroups))
-	var i int
+	groups := make([]*admin.Group, 0, len(uniqueGroups))
 	for _, group := range uniqueGroups {
-		// TODO: remove this when aws fixes this: aws request parsing bug: rename groups containg " and "
-		// - These cause AWS to mis-parse the request and return a 400 status code
-		group.Name = strings.ReplaceAll(group.Name, " and ", " & ")
-		groups[i] = group
-		i++
+		groups = append(groups, group)
 	}
 
 	return groups, nil
 }
 
-// getGroupOperations returns the groups of AWS that must be added, deleted and are equals
-func getGroupOperations(awsGroups []*aws.Group, googleGroups []*admin.Group) (add []*aws.Group, delete []*aws.Group, equals []*aws.Group) {
+// getGroupOperations returns the groups of AWS that must be added, deleted,
+// updated and are equals. Groups are matched by their external id first, the
+// id of the google group, so a renamed group is updated instead of replaced.
+func getGroupOperations(awsGroups []*aws.Group, googleGroups []*admin.Group) (add []*aws.Group, delete []*aws.Group, update []*aws.Group, equals []*aws.Group) {
 
+	awsByExternalID := make(map[string]*aws.Group)
 	awsMap := make(map[string]*aws.Group)
-	googleMap := make(map[string]struct{})
+	matched := make(map[*aws.Group]struct{})
+
+	for _, awsGroup := range awsGroups {
+		if awsGroup.ExternalID != "" {
+			awsByExternalID[awsGroup.ExternalID] = awsGroup
+		}
+		awsMap[awsGroup.DisplayName] = awsGroup
+	}
+
+	// AWS Groups found and not found in google
+	for _, gGroup := range googleGroups {
+		awsGroup, found := awsByExternalID[externalID(gGroup.Id)]
+		if !found || gGroup.Id == "" {
+			awsGroup, found = awsMap[gGroup.Name]
+			if found && !sameIdentity(awsGroup.ExternalID, gGroup.Id) {
+				found = false
+			}
+		}
+		if _, taken := matched[awsGroup]; found && taken {
+			found = false
+		}
+
+		if !found {
+			add = append(add, newAWSGroup(gGroup.Name, gGroup))
+			continue
+		}
+
+		matched[awsGroup] = struct{}{}
+		if awsGroup.DisplayName != gGroup.Name || awsGroup.ExternalID != externalID(gGroup.Id) {
+			g := newAWSGroup(gGroup.Name, gGroup)
+			g.ID = awsGroup.ID
+			update = append(update, g)
+		} else {
+			equals = append(equals, awsGroup)
+		}
+	}
+
+	// Google Groups founds and not in aws
+	for _, awsGroup := range awsGroups {
+		if _, found := matched[awsGroup]; !found {
+			g := aws.NewGroup(awsGroup.DisplayName)
+			g.ID = awsGroup.ID
+			g.ExternalID = awsGroup.ExternalID
+			delete = append(delete, g)
+		}
+	}
+
+	return add, delete, update, equals
+}
+
+// getUserOperations returns the users of AWS that must be added, deleted, updated and are equals.
+// Users are matched by their external id first, the id of the google user, so a user whose
+// email changed is updated instead of replaced. The attributes mapped are compared too.
+func getUserOperations(awsUsers []*aws.User, googleUsers []*admin.User, attributes userMapping) (add []*aws.User, delete []*aws.User, update []*aws.User, equals []*aws.User) {
+
+	awsByExternalID := make(map[string]*aws.User)
+	awsMap := make(map[string]*aws.User)
+	matched := make(map[*aws.User]struct{})
+
+	for _, awsUser := range awsUsers {
+		if awsUser.ExternalID != "" {
+			awsByExternalID[awsUser.ExternalID] = awsUser
+		}
+		awsMap[awsUser.Username] = awsUser
+	}
+
+	// managers are referenced by the id of their AWS user
+	managerID := func(email string) (string, error) {
+		if awsUser, ok := awsMap[email]; ok {
+			return awsUser.ID, nil
+		}
+		return "", nil
+	}
+
+	// AWS Users found and not found in google
+	for _, gUser := range googleUsers {
+		awsUser, found := awsByExternalID[externalID(gUser.Id)]
+		if !found || gUser.Id == "" {
+			awsUser, found = awsMap[gUser.PrimaryEmail]
+			if found && !sameIdentity(awsUser.ExternalID, gUser.Id) {
+				found = false
+			}
+		}
+		if _, taken := matched[awsUser]; found && taken {
+			found = false
+		}
+
+		if !found {
+			nu := newAWSUser(gUser)
+			attributes.apply(nu, gUser)
+			_ = resolveManager(nu, managerID)
+			add = append(add, nu)
+			continue
+		}
+
+		matched[awsUser] = struct{}{}
+		updated := updatedAWSUser(awsUser.ID, gUser)
+		attributes.apply(updated, gUser)
+		_ = resolveManager(updated, managerID)
+		if userChanged(awsUser, updated, attributes) {
+			update = append(update, updated)
+		} else {
+			equals = append(equals, awsUser)
+		}
+	}
+
+	// Google Users founds and not in aws
+	for _, awsUser := range awsUsers {
+		if _, found := matched[awsUser]; !found {
+			u := aws.UpdateUser(awsUser.ID, awsUser.Name.GivenName, awsUser.Name.FamilyName, awsUser.Username, awsUser.Active)
+			u.ExternalID = awsUser.ExternalID
+			delete = append(delete, u)
+		}
+	}
+
+	return add, delete, update, equals
+}
 
-	for _, awsGroup := range awsGroups {
-		awsMap[awsGroup.DisplayName] = awsGroup
+// skipOtherUsers drops the users to create whose email is taken by the AWS
+// user of another google user, creating them would conflict. It returns
+// the users kept and the emails of the users dropped.
+func skipOtherUsers(awsUsers []*aws.User, add []*aws.User) ([]*aws.User, map[string]struct{}) {
+	taken := make(map[string]string, len(awsUsers))
+	for _, u := range awsUsers {
+		if u.ExternalID != "" {
+			taken[u.Username] = u.ExternalID
+		}
 	}
 
-	for _, gGroup := range googleGroups {
-		googleMap[gGroup.Name] = struct{}{}
+	kept := make([]*aws.User, 0, len(add))
+	skipped := make(map[string]struct{})
+	for _, u := range add {
+		if ext, ok := taken[u.Username]; ok && ext != u.ExternalID {
+			log.WithField("user", u.Username).Warn("skipping user, the AWS user with this email belongs to another google user")
+			skipped[u.Username] = struct{}{}
+			continue
+		}
+		kept = append(kept, u)
 	}
+	return kept, skipped
+}
 
-	// AWS Groups found and not found in google
-	for _, gGroup := range googleGroups {
-		if _, found := awsMap[gGroup.Name]; found {
-			equals = append(equals, awsMap[gGroup.Name])
-		} else {
-			add = append(add, aws.NewGroup(gGroup.Name))
+// skipOtherGroups drops the groups to create whose name is taken by the AWS
+// group of another google group, creating them would conflict
+func skipOtherGroups(awsGroups []*aws.Group, add []*aws.Group) []*aws.Group {
+	taken := make(map[string]string, len(awsGroups))
+	for _, g := range awsGroups {
+		if g.ExternalID != "" {
+			taken[g.DisplayName] = g.ExternalID
 		}
 	}
 
-	// Google Groups founds and not in aws
-	for _, awsGroup := range awsGroups {
-		if _, found := googleMap[awsGroup.DisplayName]; !found {
-			delete = append(delete, aws.NewGroup(awsGroup.DisplayName))
+	kept := make([]*aws.Group, 0, len(add))
+	for _, g := range add {
+		if ext, ok := taken[g.DisplayName]; ok && ext != g.ExternalID {
+			log.WithField("group", g.DisplayName).Warn("skipping group, the AWS group with this name belongs to another google group")
+			continue
 		}
+		kept = append(kept, g)
 	}
-
-	return add, delete, equals
+	return kept
 }
 
-// getUserOperations returns the users of AWS that must be added, deleted, updated and are equals
-func getUserOperations(awsUsers []*aws.User, googleUsers []*admin.User) (add []*aws.User, delete []*aws.User, update []*aws.User, equals []*aws.User) {
-
-	awsMap := make(map[string]*aws.User)
-	googleMap := make(map[string]struct{})
+// userChanged reports whether the AWS user must be updated to become the
+// updated user, built from its google user
+func userChanged(awsUser *aws.User, updated *aws.User, attributes userMapping) bool {
+	return awsUser.Active != updated.Active ||
+		awsUser.Username != updated.Username ||
+		awsUser.ExternalID != updated.ExternalID ||
+		awsUser.Name.GivenName != updated.Name.GivenName ||
+		awsUser.Name.FamilyName != updated.Name.FamilyName ||
+		attributes.changed(awsUser, updated)
+}
 
-	for _, awsUser := range awsUsers {
-		awsMap[awsUser.Username] = awsUser
-	}
+// groupChanged reports whether the AWS group differs in the attributes
+// synced from google
+func groupChanged(awsGroup *aws.Group, updated *aws.Group) bool {
+	return awsGroup.DisplayName != updated.DisplayName ||
+		awsGroup.ExternalID != updated.ExternalID
+}
 
-	for _, gUser := range googleUsers {
-		googleMap[gUser.PrimaryEmail] = struct{}{}
+// renameMembers moves the members of the AWS groups to the new name of the
+// updated groups and users, so the members are compared with the ones of
+// the google groups under the names they have in google
+func renameMembers(awsGroupsUsers map[string][]*aws.User, awsGroups []*aws.Group, updateUsers []*aws.User, updateGroups []*aws.Group) {
+	updatedUsers := make(map[string]*aws.User)
+	for _, u := range updateUsers {
+		updatedUsers[u.ID] = u
 	}
-
-	// AWS Users found and not found in google
-	for _, gUser := range googleUsers {
-		if awsUser, found := awsMap[gUser.PrimaryEmail]; found {
-			if awsUser.Active == gUser.Suspended ||
-				awsUser.Name.GivenName != gUser.Name.GivenName ||
-				awsUser.Name.FamilyName != gUser.Name.FamilyName {
-				update = append(update, aws.NewUser(gUser.Name.GivenName, gUser.Name.FamilyName, gUser.PrimaryEmail, !gUser.Suspended))
-			} else {
-				equals = append(equals, awsUser)
+	for _, users := range awsGroupsUsers {
+		for i, u := range users {
+			if updated, ok := updatedUsers[u.ID]; ok {
+				users[i] = updated
 			}
-		} else {
-			add = append(add, aws.NewUser(gUser.Name.GivenName, gUser.Name.FamilyName, gUser.PrimaryEmail, !gUser.Suspended))
 		}
 	}
 
-	// Google Users founds and not in aws
-	for _, awsUser := range awsUsers {
-		if _, found := googleMap[awsUser.Username]; !found {
-			delete = append(delete, aws.NewUser(awsUser.Name.GivenName, awsUser.Name.FamilyName, awsUser.Username, awsUser.Active))
+	names := make(map[string]string)
+	for _, g := range awsGroups {
+		names[g.ID] = g.DisplayName
+	}
+	renamed := make(map[string][]*aws.User)
+	for _, g := range updateGroups {
+		if oldName := names[g.ID]; oldName != g.DisplayName {
+			renamed[g.DisplayName] = awsGroupsUsers[oldName]
+			delete(awsGroupsUsers, oldName)
 		}
 	}
-
-	return add, delete, update, equals
+	for name, users := range renamed {
+		awsGroupsUsers[name] = users
+	}
 }
 
-// groupUsersOperations returns the groups and its users of AWS that must be delete from these groups and what are equals
-func getGroupUsersOperations(gGroupsUsers map[string][]*admin.User, awsGroupsUsers map[string][]*aws.User) (delete map[string][]*aws.User, equals map[string][]*aws.User) {
+// getGroupUsersOperations returns the users of google that must be added to the AWS groups,
+// and the groups and its users of AWS that must be delete from these groups and what are equals
+func getGroupUsersOperations(gGroupsUsers map[string][]*admin.User, awsGroupsUsers map[string][]*aws.User) (add map[string][]*admin.User, delete map[string][]*aws.User, equals map[string][]*aws.User) {
 
 	mbG := make(map[string]map[string]struct{})
+	mbA := make(map[string]map[string]struct{})
 
 	// get user in google groups that are in aws groups and
 	// users in aws groups that aren't in google groups
@@ -679,6 +1842,23 @@ func getGroupUsersOperations(gGroupsUsers map[string][]*admin.User, awsGroupsUse
 		}
 	}
 
+	for awsGroupName, awsGroupUsers := range awsGroupsUsers {
+		mbA[awsGroupName] = make(map[string]struct{})
+		for _, awsUser := range awsGroupUsers {
+			mbA[awsGroupName][awsUser.Username] = struct{}{}
+		}
+	}
+
+	add = make(map[string][]*admin.User)
+	for gGroupName, gGroupUsers := range gGroupsUsers {
+		for _, gUser := range gGroupUsers {
+			// users that exist in google groups but doesn't in aws groups
+			if _, found := mbA[gGroupName][gUser.PrimaryEmail]; !found {
+				add[gGroupName] = append(add[gGroupName], gUser)
+			}
+		}
+	}
+
 	delete = make(map[string][]*aws.User)
 	equals = make(map[string][]*aws.User)
 	for awsGroupName, awsGroupUsers := range awsGroupsUsers {
@@ -700,16 +1880,157 @@ func getGroupUsersOperations(gGroupsUsers map[string][]*admin.User, awsGroupsUse
 func DoSync(ctx context.Context, cfg *config.Config) error {
 	log.Info("Syncing AWS users and groups from Google Workspace SAML Application")
 
+	c, ds, err := newSync(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		log.Warn("dry run enabled, no changes will be made in AWS SSO")
+	}
+
+	log.WithField("sync_method", cfg.SyncMethod).Info("syncing")
+	switch cfg.SyncMethod {
+	case config.DefaultSyncMethod:
+		if incremental(cfg) {
+			err = c.SyncIncremental(ctx, cfg.GroupMatch)
+		} else {
+			err = c.SyncGroupsUsers(ctx, cfg.GroupMatch)
+		}
+	case config.SyncMethodOrgUnits:
+		err = c.SyncOrgUnits(ctx, cfg.OrgUnits)
+	default:
+		err = c.SyncUsers(ctx, cfg.UserMatch)
+		if err == nil {
+			err = c.SyncGroups(ctx, cfg.GroupMatch)
+		}
+	}
+
+	if cfg.DryRun {
+		log.Info("dry run, datastore not persisted")
+		return err
+	}
+
+	// the changes made before a failure or a cancellation are kept
+	return storeDatastore(ds, err)
+}
+
+// storeDatastore persists the datastore, also when the sync failed with
+// syncErr, and returns the first error
+func storeDatastore(ds datastore.Datastore, syncErr error) error {
+	if syncErr != nil {
+		log.WithError(syncErr).Warn("sync failed, persisting the datastore")
+	}
+
+	if err := ds.Store(); err != nil {
+		if syncErr != nil {
+			log.WithError(err).Error("failed to persist the datastore")
+			return syncErr
+		}
+		return err
+	}
+
+	return syncErr
+}
+
+// DoPlan computes the changes a sync would make in AWS SSO without
+// applying them nor persisting the datastore.
+func DoPlan(ctx context.Context, cfg *config.Config) (*Plan, error) {
+	log.Info("Planning sync of AWS users and groups from Google Workspace SAML Application")
+
+	if cfg.SyncMethod != config.DefaultSyncMethod && cfg.SyncMethod != config.SyncMethodOrgUnits {
+		return nil, fmt.Errorf("plan is only supported with sync methods '%s' and '%s', use --dry-run instead", config.DefaultSyncMethod, config.SyncMethodOrgUnits)
+	}
+
+	c, _, err := newSync(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan *Plan
+	if cfg.SyncMethod == config.SyncMethodOrgUnits {
+		plan, err = c.PlanOrgUnits(ctx, cfg.OrgUnits)
+	} else {
+		plan, err = c.PlanGroupsUsers(ctx, cfg.GroupMatch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := plan.CheckDeletionLimits(cfg.MaxDeletions, cfg.MaxDeletionsPercent); err != nil {
+		log.WithError(err).Warn("this plan will be refused unless --force is used")
+	}
+
+	return plan, nil
+}
+
+// DoApply applies a plan created by DoPlan, refusing it when AWS SSO changed
+// since the plan was computed.
+func DoApply(ctx context.Context, cfg *config.Config, plan *Plan) error {
+	log.Info("Applying plan to AWS SSO")
+
+	awsClient, ds, err := newAWSClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	c := New(cfg, awsClient, nil, ds)
+
+	err = c.ApplyPlan(ctx, plan)
+
+	return storeDatastore(ds, err)
+}
+
+// newSync creates the google and aws clients, loads the datastore and
+// returns a SyncGSuite ready to be used
+func newSync(ctx context.Context, cfg *config.Config) (SyncGSuite, datastore.Datastore, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	googleClient, err := newGoogleClient(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	awsClient, ds, err := newAWSClient(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return New(cfg, awsClient, googleClient, ds), ds, nil
+}
+
+// newGoogleClient creates the google client with the scopes needed by the
+// config
+func newGoogleClient(ctx context.Context, cfg *config.Config) (google.Client, error) {
+	attributes, _ := parseUserMapping(cfg.UserAttributes)
+
 	creds := []byte(cfg.GoogleCredentials)
 
 	if !cfg.IsLambda {
 		b, err := ioutil.ReadFile(cfg.GoogleCredentials)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		creds = b
 	}
 
+	// the org units are only listed to sync them as groups, the admin
+	// activities to sync the changes
+	var scopes []string
+	if cfg.SyncMethod == config.SyncMethodOrgUnits && cfg.OrgUnitGroups {
+		scopes = append(scopes, admin.AdminDirectoryOrgunitReadonlyScope)
+	}
+	if incremental(cfg) {
+		scopes = append(scopes, reports.AdminReportsAuditReadonlyScope)
+	}
+
+	return google.NewClient(ctx, cfg.GoogleAdmin, creds, attributes.customSchemas(), scopes...)
+}
+
+// newAWSClient creates the aws client with its datastore already loaded
+func newAWSClient(cfg *config.Config) (aws.Client, datastore.Datastore, error) {
 	// create a http client with retry and backoff capabilities
 	retryClient := retryablehttp.NewClient()
 
@@ -720,16 +2041,20 @@ func DoSync(ctx context.Context, cfg *config.Config) error {
 		retryClient.Logger = nil
 	}
 
-	httpClient := retryClient.StandardClient()
-
-	googleClient, err := google.NewClient(ctx, cfg.GoogleAdmin, creds)
-	if err != nil {
-		return err
+	// throttled requests are retried by the aws client, which honors
+	// Retry-After and slows down every request, not only the throttled one
+	retryClient.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+			return false, nil
+		}
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
 	}
 
+	httpClient := retryClient.StandardClient()
+
 	ds, err := datastore.NewDatastore(cfg)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	awsClient, err := aws.NewClient(
@@ -737,70 +2062,411 @@ func DoSync(ctx context.Context, cfg *config.Config) error {
 		&aws.Config{
 			Endpoint: cfg.SCIMEndpoint,
 			Token:    cfg.SCIMAccessToken,
+			PageSize: cfg.SCIMPageSize,
+
+			RequestsPerSecond: cfg.SCIMRequestsPerSecond,
+			Burst:             cfg.SCIMBurst,
 		}, ds)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	err = ds.Load()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	c := New(cfg, awsClient, googleClient)
+	return awsClient, ds, nil
+}
 
-	log.WithField("sync_method", cfg.SyncMethod).Info("syncing")
-	if cfg.SyncMethod == config.DefaultSyncMethod {
-		err = c.SyncGroupsUsers(cfg.GroupMatch)
-		if err != nil {
-			return err
+// createUser creates the user in AWS SSO, a user that already exists
+// is a conflict and is looked up instead, unless it belongs to another
+// google user
+func (s *syncGSuite) createUser(ctx context.Context, u *aws.User) (*aws.User, error) {
+	newUser, err := s.aws.CreateUser(ctx, u)
+	if !errors.Is(err, aws.ErrConflict) {
+		return newUser, err
+	}
+
+	log.WithField("user", u.Username).Warn("user already exists")
+	existing, err := s.aws.FindUserByEmail(ctx, u.Username)
+	if err != nil {
+		return nil, err
+	}
+	if existing.ExternalID != "" && existing.ExternalID != u.ExternalID {
+		return nil, fmt.Errorf("%w: the AWS user %s belongs to another google user", aws.ErrConflict, u.Username)
+	}
+	return existing, nil
+}
+
+// deleteUser deletes the user from AWS SSO, a user already deleted is not an error
+func (s *syncGSuite) deleteUser(ctx context.Context, u *aws.User) error {
+	err := s.aws.DeleteUser(ctx, u)
+	if errors.Is(err, aws.ErrNotFound) {
+		log.WithField("user", u.Username).Warn("user already deleted")
+		return nil
+	}
+	return err
+}
+
+// createGroup creates the group in AWS SSO, a group that already exists
+// is a conflict and is looked up instead, unless it belongs to another
+// google group
+func (s *syncGSuite) createGroup(ctx context.Context, g *aws.Group) (*aws.Group, error) {
+	newGroup, err := s.aws.CreateGroup(ctx, g)
+	if !errors.Is(err, aws.ErrConflict) {
+		return newGroup, err
+	}
+
+	log.WithField("group", g.DisplayName).Warn("group already exists")
+	existing, err := s.aws.FindGroupByDisplayName(ctx, g.DisplayName)
+	if err != nil {
+		return nil, err
+	}
+	if existing.ExternalID != "" && existing.ExternalID != g.ExternalID {
+		return nil, fmt.Errorf("%w: the AWS group %s belongs to another google group", aws.ErrConflict, g.DisplayName)
+	}
+	return existing, nil
+}
+
+// deleteGroup deletes the group from AWS SSO, a group already deleted is not an error
+func (s *syncGSuite) deleteGroup(ctx context.Context, g *aws.Group) error {
+	err := s.aws.DeleteGroup(ctx, g)
+	if errors.Is(err, aws.ErrNotFound) {
+		log.WithField("group", g.DisplayName).Warn("group already deleted")
+		return nil
+	}
+	return err
+}
+
+func (s *syncGSuite) ignoreUser(name string) bool {
+	return s.ignoreUsers.match(name)
+}
+
+func (s *syncGSuite) ignoreGroup(name string) bool {
+	return s.ignoreGroups.match(name)
+}
+
+func (s *syncGSuite) includeGroup(name string) bool {
+	return s.includeGroups.match(name)
+}
+
+// selectUser reports whether the user filter selects the google user, the
+// sync method is the syncMethod variable of the expression
+func (s *syncGSuite) selectUser(u *admin.User) (bool, error) {
+	ok, err := s.userFilter.match(u, map[string]interface{}{"syncMethod": s.cfg.SyncMethod})
+	if err == nil && !ok {
+		log.WithField("email", u.PrimaryEmail).Debug("ignoring user, using --user-filter")
+	}
+	return ok, err
+}
+
+// selectGroup reports whether the group filter selects the google group
+func (s *syncGSuite) selectGroup(g *admin.Group) (bool, error) {
+	ok, err := s.groupFilter.match(g, map[string]interface{}{"syncMethod": s.cfg.SyncMethod})
+	if err == nil && !ok {
+		log.WithField("group", g.Email).Debug("ignoring group, using --group-filter")
+	}
+	return ok, err
+}
+
+// protectedUser reports whether the user is synced but never deleted,
+// deactivated or removed from groups
+func (s *syncGSuite) protectedUser(name string) bool {
+	return s.protectedUsers.match(name)
+}
+
+// protectedGroup reports whether the group is synced but never deleted
+func (s *syncGSuite) protectedGroup(name string) bool {
+	return s.protectedGroups.match(name)
+}
+
+// newAWSUser returns the AWS user of a google user, correlated with it
+// by the id of the google user
+func newAWSUser(u *admin.User) *aws.User {
+	awsUser := aws.NewUser(u.Name.GivenName, u.Name.FamilyName, u.PrimaryEmail, !u.Suspended)
+	awsUser.ExternalID = externalID(u.Id)
+	return awsUser
+}
+
+// updatedAWSUser returns the AWS user with the id given and the attributes
+// of the google user
+func updatedAWSUser(id string, u *admin.User) *aws.User {
+	awsUser := aws.UpdateUser(id, u.Name.GivenName, u.Name.FamilyName, u.PrimaryEmail, !u.Suspended)
+	awsUser.ExternalID = externalID(u.Id)
+	return awsUser
+}
+
+// awsUserID returns a function looking up the id of the AWS user with the
+// email given, empty when there is none
+func (s *syncGSuite) awsUserID(ctx context.Context) func(email string) (string, error) {
+	return func(email string) (string, error) {
+		u, err := s.aws.FindUserByEmail(ctx, email)
+		if err == aws.ErrUserNotFound {
+			return "", nil
 		}
-	} else {
-		err = c.SyncUsers(cfg.UserMatch)
 		if err != nil {
-			return err
+			return "", err
 		}
+		return u.ID, nil
+	}
+}
 
-		err = c.SyncGroups(cfg.GroupMatch)
-		if err != nil {
-			return err
+// newAWSGroup returns the AWS group of a google group with the name given,
+// correlated with it by the id of the google group
+func newAWSGroup(name string, g *admin.Group) *aws.Group {
+	awsGroup := aws.NewGroup(name)
+	awsGroup.ExternalID = externalID(g.Id)
+	return awsGroup
+}
+
+// sameIdentity reports whether an AWS object with the external id given
+// can be the object of the google id, objects created before external
+// ids were set have none
+func sameIdentity(ext string, id string) bool {
+	return ext == "" || ext == externalID(id)
+}
+
+// ownerPrefix starts the external ids of the AWS users and groups owned by
+// ssosync, it is followed by the id of their google user or group
+const ownerPrefix = "ssosync:"
+
+// externalID returns the external id of the AWS object of the google id,
+// which marks it as owned by ssosync
+func externalID(id string) string {
+	if id == "" {
+		return ""
+	}
+	return ownerPrefix + id
+}
+
+// googleID returns the google id of the external id of an owned AWS
+// object, it is empty for the other objects
+func googleID(ext string) string {
+	if !strings.HasPrefix(ext, ownerPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(ext, ownerPrefix)
+}
+
+// owned reports whether ssosync can delete the AWS object with the
+// external id given or remove it from groups, only the objects it owns
+// unless --manage-unowned is set
+func (s *syncGSuite) owned(ext string) bool {
+	return s.cfg.ManageUnowned || googleID(ext) != ""
+}
+
+// ownedUser reports whether ssosync owns the AWS user, see owned. The users
+// without external id kept in the datastore were created by older versions.
+func (s *syncGSuite) ownedUser(u *aws.User) bool {
+	return s.owned(u.ExternalID) || (u.ExternalID == "" && s.createdUsers[u.Username])
+}
+
+// ownedGroup reports whether ssosync owns the AWS group, see ownedUser
+func (s *syncGSuite) ownedGroup(g *aws.Group) bool {
+	return s.owned(g.ExternalID) || (g.ExternalID == "" && s.createdGroups[g.DisplayName])
+}
+
+// stringSet returns the set of the strings given
+func stringSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, e := range list {
+		set[e] = true
+	}
+	return set
+}
+
+// removableUser reports whether ssosync can delete the user or remove it
+// from groups, it must be owned by ssosync and not protected. The reason
+// the action is not done is logged.
+func (s *syncGSuite) removableUser(u *aws.User, action string) bool {
+	log := log.WithField("user", u.Username)
+	if s.protectedUser(u.Username) {
+		log.Warn("not " + action + ", the user is protected")
+		return false
+	}
+	if !s.ownedUser(u) {
+		log.Warn("not " + action + ", the user is not owned by ssosync, use --manage-unowned")
+		return false
+	}
+	return true
+}
+
+// removableUsers returns the users ssosync can delete or remove from groups
+func (s *syncGSuite) removableUsers(users []*aws.User, action string) []*aws.User {
+	removable := make([]*aws.User, 0, len(users))
+	for _, u := range users {
+		if s.removableUser(u, action) {
+			removable = append(removable, u)
 		}
 	}
+	return removable
+}
 
-	err = ds.Store()
-	if err != nil {
-		return err
+// removableGroup reports whether ssosync can delete the group, it must be
+// owned by ssosync and not protected
+func (s *syncGSuite) removableGroup(g *aws.Group) bool {
+	log := log.WithField("group", g.DisplayName)
+	if s.protectedGroup(g.DisplayName) {
+		log.Warn("not deleting group, the group is protected")
+		return false
+	}
+	if !s.ownedGroup(g) {
+		log.Warn("not deleting group, the group is not owned by ssosync, use --manage-unowned")
+		return false
 	}
+	return true
+}
 
-	return nil
+// keepProtectedActive keeps active the updated user of a protected active
+// user suspended in google, it reports whether the user was kept active
+func (s *syncGSuite) keepProtectedActive(awsUser *aws.User, updated *aws.User) bool {
+	if !awsUser.Active || updated.Active || !s.protectedUser(updated.Username) {
+		return false
+	}
+	log.WithField("user", updated.Username).Warn("not deactivating user, the user is protected")
+	updated.Active = true
+	return true
 }
 
-func (s *syncGSuite) ignoreUser(name string) bool {
-	for _, u := range s.cfg.IgnoreUsers {
-		if u == name {
-			return true
+// keepUnowned keeps the external id of the updated user of an AWS user
+// matched by email and not owned by ssosync, so the user isn't taken over
+// unless --manage-unowned is set. It reports whether the id was kept.
+func (s *syncGSuite) keepUnowned(awsUser *aws.User, updated *aws.User) bool {
+	if awsUser.ExternalID == updated.ExternalID || s.ownedUser(awsUser) {
+		return false
+	}
+	log.WithField("user", updated.Username).Info("not taking over user, the user is not owned by ssosync, use --manage-unowned")
+	updated.ExternalID = awsUser.ExternalID
+	return true
+}
+
+// keepUnownedGroups keeps the AWS groups matched by name and not owned by
+// ssosync as they are, so they aren't taken over unless --manage-unowned
+// is set. Only their external id would change, they are equal instead.
+func (s *syncGSuite) keepUnownedGroups(awsGroups []*aws.Group, update []*aws.Group, equals []*aws.Group) ([]*aws.Group, []*aws.Group) {
+	awsGroupsByID := make(map[string]*aws.Group, len(awsGroups))
+	for _, g := range awsGroups {
+		awsGroupsByID[g.ID] = g
+	}
+
+	changed := make([]*aws.Group, 0, len(update))
+	for _, g := range update {
+		old := awsGroupsByID[g.ID]
+		if old == nil || old.DisplayName != g.DisplayName || old.ExternalID == g.ExternalID || s.ownedGroup(old) {
+			changed = append(changed, g)
+			continue
+		}
+		log.WithField("group", g.DisplayName).Info("not taking over group, the group is not owned by ssosync, use --manage-unowned")
+		equals = append(equals, old)
+	}
+	return changed, equals
+}
+
+// findUser returns the AWS user of the google user, or nil when there is
+// none. It is looked up by external id first, so it is still found after
+// its email changed.
+func (s *syncGSuite) findUser(ctx context.Context, u *admin.User) (*aws.User, error) {
+	if u.Id != "" {
+		uu, err := s.aws.FindUserByExternalID(ctx, externalID(u.Id))
+		if err == nil {
+			return uu, nil
+		}
+		if err != aws.ErrUserNotFound {
+			return nil, err
 		}
 	}
 
-	return false
+	uu, err := s.aws.FindUserByEmail(ctx, u.PrimaryEmail)
+	if err == aws.ErrUserNotFound {
+		return nil, nil
+	}
+	return uu, err
 }
 
-func (s *syncGSuite) ignoreGroup(name string) bool {
-	for _, g := range s.cfg.IgnoreGroups {
-		if g == name {
-			return true
+// findGroup returns the AWS group of the google group, or nil when there is
+// none. It is looked up by external id first, so it is still found after
+// it was renamed.
+func (s *syncGSuite) findGroup(ctx context.Context, g *admin.Group, name string) (*aws.Group, error) {
+	if g.Id != "" {
+		gg, err := s.aws.FindGroupByExternalID(ctx, externalID(g.Id))
+		if err == nil {
+			return gg, nil
+		}
+		if err != aws.ErrGroupNotFound {
+			return nil, err
 		}
 	}
 
-	return false
+	// the endpoint may not keep the external id, the id of the group is
+	// then found in the datastore
+	groupIDs, err := s.ds.GetGroupIDs()
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := groupIDs[g.Id]; ok && g.Id != "" {
+		gg, err := s.aws.FindGroupByID(ctx, id)
+		if err == nil {
+			gg.ExternalID = externalID(g.Id)
+			return gg, nil
+		}
+		if err != aws.ErrGroupNotFound {
+			return nil, err
+		}
+	}
+
+	gg, err := s.aws.FindGroupByDisplayName(ctx, name)
+	if err == aws.ErrGroupNotFound {
+		return nil, nil
+	}
+	return gg, err
 }
 
-func (s *syncGSuite) includeGroup(name string) bool {
-	for _, g := range s.cfg.IncludeGroups {
-		if g == name {
-			return true
+// correlateGroups sets the external id of the AWS groups returned without
+// it, from the ids of the google groups kept in the datastore when the
+// groups were created or updated, so a renamed group is still matched
+func (s *syncGSuite) correlateGroups(awsGroups []*aws.Group) error {
+	groupIDs, err := s.ds.GetGroupIDs()
+	if err != nil {
+		return err
+	}
+
+	googleIDs := make(map[string]string, len(groupIDs))
+	for googleID, awsID := range groupIDs {
+		googleIDs[awsID] = googleID
+	}
+
+	for _, awsGroup := range awsGroups {
+		if googleID, ok := googleIDs[awsGroup.ID]; ok && awsGroup.ExternalID == "" {
+			awsGroup.ExternalID = externalID(googleID)
 		}
 	}
 
-	return false
+	return nil
+}
+
+// rememberGroupID keeps the id of the AWS group of a google group in the datastore
+func (s *syncGSuite) rememberGroupID(googleID string, awsID string) error {
+	if googleID == "" {
+		return nil
+	}
+	return s.ds.SetGroupID(googleID, awsID)
+}
+
+// forgetGroupID removes the deleted AWS group from the ids kept in the datastore
+func (s *syncGSuite) forgetGroupID(awsID string) error {
+	groupIDs, err := s.ds.GetGroupIDs()
+	if err != nil {
+		return err
+	}
+
+	for googleID, id := range groupIDs {
+		if id == awsID {
+			if err := s.ds.DeleteGroupID(googleID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
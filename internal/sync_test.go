@@ -16,8 +16,11 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/awslabs/ssosync/internal/aws"
@@ -44,6 +47,7 @@ func Test_getGroupOperations(t *testing.T) {
 		args       args
 		wantAdd    []*aws.Group
 		wantDelete []*aws.Group
+		wantUpdate []*aws.Group
 		wantEquals []*aws.Group
 	}{
 		{
@@ -118,16 +122,45 @@ func Test_getGroupOperations(t *testing.T) {
 				aws.NewGroup("Group-2"),
 			},
 		},
+		{
+			name: "renamed group and group with an external id",
+			args: args{
+				awsGroups: []*aws.Group{
+					withGroupIDs(aws.NewGroup("Group-1"), "group-1", "ggroup-1"),
+					withGroupIDs(aws.NewGroup("Group-2"), "group-2", ""),
+					withGroupIDs(aws.NewGroup("Group-3"), "group-3", "ggroup-4"),
+				},
+				googleGroups: []*admin.Group{
+					{Id: "ggroup-1", Name: "Group-1 renamed"},
+					{Id: "ggroup-2", Name: "Group-2"},
+					{Id: "ggroup-3", Name: "Group-3"},
+				},
+			},
+			wantAdd: []*aws.Group{
+				withGroupIDs(aws.NewGroup("Group-3"), "", "ggroup-3"),
+			},
+			wantDelete: []*aws.Group{
+				withGroupIDs(aws.NewGroup("Group-3"), "group-3", "ggroup-4"),
+			},
+			wantUpdate: []*aws.Group{
+				withGroupIDs(aws.NewGroup("Group-1 renamed"), "group-1", "ggroup-1"),
+				withGroupIDs(aws.NewGroup("Group-2"), "group-2", "ggroup-2"),
+			},
+			wantEquals: nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotAdd, gotDelete, gotEquals := getGroupOperations(tt.args.awsGroups, tt.args.googleGroups)
+			gotAdd, gotDelete, gotUpdate, gotEquals := getGroupOperations(tt.args.awsGroups, tt.args.googleGroups)
 			if !reflect.DeepEqual(gotAdd, tt.wantAdd) {
 				t.Errorf("getGroupOperations() gotAdd = %s, want %s", toJSON(gotAdd), toJSON(tt.wantAdd))
 			}
 			if !reflect.DeepEqual(gotDelete, tt.wantDelete) {
 				t.Errorf("getGroupOperations() gotDelete = %s, want %s", toJSON(gotDelete), toJSON(tt.wantDelete))
 			}
+			if !reflect.DeepEqual(gotUpdate, tt.wantUpdate) {
+				t.Errorf("getGroupOperations() gotUpdate = %s, want %s", toJSON(gotUpdate), toJSON(tt.wantUpdate))
+			}
 			if !reflect.DeepEqual(gotEquals, tt.wantEquals) {
 				t.Errorf("getGroupOperations() gotEquals = %s, want %s", toJSON(gotEquals), toJSON(tt.wantEquals))
 			}
@@ -135,6 +168,22 @@ func Test_getGroupOperations(t *testing.T) {
 	}
 }
 
+// withGroupIDs sets the id of the group and its external id from the id of
+// its google group
+func withGroupIDs(g *aws.Group, id, googleID string) *aws.Group {
+	g.ID = id
+	g.ExternalID = externalID(googleID)
+	return g
+}
+
+// withUserIDs sets the id of the user and its external id from the id of
+// its google user
+func withUserIDs(u *aws.User, id, googleID string) *aws.User {
+	u.ID = id
+	u.ExternalID = externalID(googleID)
+	return u
+}
+
 func Test_getUserOperations(t *testing.T) {
 	type args struct {
 		awsUsers    []*aws.User
@@ -274,10 +323,48 @@ func Test_getUserOperations(t *testing.T) {
 				aws.NewUser("name-2", "lastname-2", "user-2@email.com", true),
 			},
 		},
+		{
+			name: "user with a new email and user with an external id",
+			args: args{
+				awsUsers: []*aws.User{
+					withUserIDs(aws.NewUser("name-1", "lastname-1", "old-1@email.com", true), "user-1", "guser-1"),
+					withUserIDs(aws.NewUser("name-2", "lastname-2", "user-2@email.com", true), "user-2", ""),
+					withUserIDs(aws.NewUser("name-3", "lastname-3", "user-3@email.com", true), "user-3", "guser-4"),
+				},
+				googleUsers: []*admin.User{
+					{
+						Id:           "guser-1",
+						Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+						PrimaryEmail: "user-1@email.com",
+					},
+					{
+						Id:           "guser-2",
+						Name:         &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"},
+						PrimaryEmail: "user-2@email.com",
+					},
+					{
+						Id:           "guser-3",
+						Name:         &admin.UserName{GivenName: "name-3", FamilyName: "lastname-3"},
+						PrimaryEmail: "user-3@email.com",
+					},
+				},
+			},
+			wantAdd: []*aws.User{
+				withUserIDs(aws.NewUser("name-3", "lastname-3", "user-3@email.com", true), "", "guser-3"),
+			},
+			wantDelete: []*aws.User{
+				withUserIDs(aws.NewUser("name-3", "lastname-3", "user-3@email.com", true), "user-3", "guser-4"),
+			},
+			wantUpdate: []*aws.User{
+				withUserIDs(aws.NewUser("name-1", "lastname-1", "user-1@email.com", true), "user-1", "guser-1"),
+				withUserIDs(aws.NewUser("name-2", "lastname-2", "user-2@email.com", true), "user-2", "guser-2"),
+			},
+			wantEquals: nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotAdd, gotDelete, gotUpdate, gotEquals := getUserOperations(tt.args.awsUsers, tt.args.googleUsers)
+			gotAdd, gotDelete, gotUpdate, gotEquals := getUserOperations(tt.args.awsUsers, tt.args.googleUsers, nil)
 			if !reflect.DeepEqual(gotAdd, tt.wantAdd) {
 				t.Errorf("getUserOperations() gotAdd = %s, want %s", toJSON(gotAdd), toJSON(tt.wantAdd))
 			}
@@ -302,6 +389,7 @@ func Test_getGroupUsersOperations(t *testing.T) {
 	tests := []struct {
 		name       string
 		args       args
+		wantAdd    map[string][]*admin.User
 		wantDelete map[string][]*aws.User
 		wantEquals map[string][]*aws.User
 	}{
@@ -318,6 +406,14 @@ func Test_getGroupUsersOperations(t *testing.T) {
 							Suspended:    false,
 							PrimaryEmail: "user-1@email.com",
 						},
+						{
+							Name: &admin.UserName{
+								GivenName:  "name-3",
+								FamilyName: "lastname-3",
+							},
+							Suspended:    false,
+							PrimaryEmail: "user-3@email.com",
+						},
 					},
 				},
 				awsGroupsUsers: map[string][]*aws.User{
@@ -327,6 +423,18 @@ func Test_getGroupUsersOperations(t *testing.T) {
 					},
 				},
 			},
+			wantAdd: map[string][]*admin.User{
+				"group-1": {
+					{
+						Name: &admin.UserName{
+							GivenName:  "name-3",
+							FamilyName: "lastname-3",
+						},
+						Suspended:    false,
+						PrimaryEmail: "user-3@email.com",
+					},
+				},
+			},
 			wantDelete: map[string][]*aws.User{
 				"group-1": {
 					aws.NewUser("name-2", "lastname-2", "user-2@email.com", true),
@@ -341,7 +449,10 @@ func Test_getGroupUsersOperations(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotDelete, gotEquals := getGroupUsersOperations(tt.args.gGroupsUsers, tt.args.awsGroupsUsers)
+			gotAdd, gotDelete, gotEquals := getGroupUsersOperations(tt.args.gGroupsUsers, tt.args.awsGroupsUsers)
+			if !reflect.DeepEqual(gotAdd, tt.wantAdd) {
+				t.Errorf("getGroupUsersOperations() gotAdd = %s, want %s", toJSON(gotAdd), toJSON(tt.wantAdd))
+			}
 			if !reflect.DeepEqual(gotDelete, tt.wantDelete) {
 				t.Errorf("getGroupUsersOperations() gotDelete = %s, want %s", toJSON(gotDelete), toJSON(tt.wantDelete))
 			}
@@ -351,3 +462,206 @@ func Test_getGroupUsersOperations(t *testing.T) {
 		})
 	}
 }
+
+func Test_SyncUsersAndGroupsDeletionLimit(t *testing.T) {
+	s, g, a := newTestSync()
+	s.cfg.MaxDeletionsPercent = 10
+	s.includeGroups, _ = compilePatterns([]string{"group-1@email.com"})
+
+	// deleting user-3 deletes half of the users, nothing is changed
+	g.deleted = []*admin.User{{Id: "guser-3", PrimaryEmail: "user-3@email.com"}}
+	err := s.SyncUsers(context.Background(), "")
+	if !errors.Is(err, ErrDeletionLimit) {
+		t.Fatalf("SyncUsers() error = %v, want %v", err, ErrDeletionLimit)
+	}
+	if len(a.calls) > 0 {
+		t.Errorf("SyncUsers() calls = %v, want none", a.calls)
+	}
+
+	// removing user-3 from Group-1 removes all its members
+	g.deleted = nil
+	if err := s.SyncUsers(context.Background(), ""); err != nil {
+		t.Fatalf("SyncUsers() error = %v", err)
+	}
+	au3, _ := a.FindUserByEmail(context.Background(), "user-3@email.com")
+	s.addUser(au3)
+	a.calls = nil
+	err = s.SyncGroups(context.Background(), []string{""})
+	if !errors.Is(err, ErrDeletionLimit) {
+		t.Fatalf("SyncGroups() error = %v, want %v", err, ErrDeletionLimit)
+	}
+	if len(a.calls) > 0 {
+		t.Errorf("SyncGroups() calls = %v, want none", a.calls)
+	}
+
+	s.cfg.Force = true
+	if err := s.SyncGroups(context.Background(), []string{""}); err != nil {
+		t.Fatalf("SyncGroups() error = %v", err)
+	}
+	ag1, _ := a.FindGroupByDisplayName(context.Background(), "group-1@email.com")
+	if ag1 == nil || a.members[ag1.ID][au3.ID] {
+		t.Errorf("user %s not removed from group %v with --force", au3.ID, ag1)
+	}
+}
+
+func Test_getAWSGroupsAndUsers(t *testing.T) {
+	for _, listed := range []bool{true, false} {
+		s, _, a := newTestSync()
+		a.membersNotListed = !listed
+
+		awsGroups, _ := a.GetGroups(context.Background())
+		awsUsers, _ := a.GetUsers(context.Background())
+
+		got, err := s.getAWSGroupsAndUsers(context.Background(), awsGroups, awsUsers)
+		if err != nil {
+			t.Fatalf("getAWSGroupsAndUsers() listed = %v, error = %v", listed, err)
+		}
+
+		if len(got["Group-1"]) != 1 || got["Group-1"][0].Username != "user-3@email.com" {
+			t.Errorf("getAWSGroupsAndUsers() listed = %v, got = %s", listed, toJSON(got))
+		}
+
+		// the members are read with one request per group, users are
+		// only probed one by one when the endpoint does not list them
+		wantProbes := 0
+		if !listed {
+			wantProbes = len(awsUsers)
+		}
+		if a.probes != wantProbes {
+			t.Errorf("getAWSGroupsAndUsers() listed = %v, probes = %d, want %d", listed, a.probes, wantProbes)
+		}
+	}
+}
+
+func Test_createAndDeleteConflicts(t *testing.T) {
+	s, _, a := newTestSync()
+
+	// the user and the group already exist, they are looked up
+	existing, _ := a.FindUserByEmail(context.Background(), "user-2@email.com")
+	nu := aws.NewUser("name-2", "lastname-2", "user-2@email.com", true)
+	nu.ExternalID = existing.ExternalID
+	u, err := s.createUser(context.Background(), nu)
+	if err != nil || u.ID != existing.ID {
+		t.Errorf("createUser() = %v, error = %v, want %v", u, err, existing)
+	}
+
+	existingGroup, _ := a.FindGroupByDisplayName(context.Background(), "Group-1")
+	ng := aws.NewGroup("Group-1")
+	ng.ExternalID = existingGroup.ExternalID
+	g, err := s.createGroup(context.Background(), ng)
+	if err != nil || g.ID != existingGroup.ID {
+		t.Errorf("createGroup() = %v, error = %v, want %v", g, err, existingGroup)
+	}
+
+	// unless they belong to another google user or group
+	nu.ExternalID = externalID("guser-9")
+	if u, err := s.createUser(context.Background(), nu); !errors.Is(err, aws.ErrConflict) {
+		t.Errorf("createUser() = %v, error = %v, want %v", u, err, aws.ErrConflict)
+	}
+	ng.ExternalID = externalID("ggroup-9")
+	if g, err := s.createGroup(context.Background(), ng); !errors.Is(err, aws.ErrConflict) {
+		t.Errorf("createGroup() = %v, error = %v, want %v", g, err, aws.ErrConflict)
+	}
+
+	// already deleted
+	if err := s.deleteUser(context.Background(), aws.NewUser("name-9", "lastname-9", "user-9@email.com", true)); err != nil {
+		t.Errorf("deleteUser() error = %v", err)
+	}
+	if err := s.deleteGroup(context.Background(), aws.NewGroup("Group-9")); err != nil {
+		t.Errorf("deleteGroup() error = %v", err)
+	}
+}
+
+func Test_SyncUsersAndGroupsRenames(t *testing.T) {
+	s, g, a := newTestSync()
+
+	// user-2 changes email, the AWS group is named after the google
+	// group email by SyncGroups
+	g.users[1].PrimaryEmail = "user-2-new@email.com"
+	g.members[g.groups[0].Id][1].Email = "user-2-new@email.com"
+	au2, _ := a.FindUserByEmail(context.Background(), "user-2@email.com")
+	ag1, _ := a.FindGroupByDisplayName(context.Background(), "Group-1")
+	s.includeGroups, _ = compilePatterns([]string{"group-1@email.com"})
+
+	if err := s.SyncUsers(context.Background(), ""); err != nil {
+		t.Fatalf("SyncUsers() error = %v", err)
+	}
+	if err := s.SyncGroups(context.Background(), []string{""}); err != nil {
+		t.Fatalf("SyncGroups() error = %v", err)
+	}
+
+	u, err := a.FindUserByEmail(context.Background(), "user-2-new@email.com")
+	if err != nil || u.ID != au2.ID {
+		t.Errorf("FindUserByEmail() = %v, error = %v, want id %s", u, err, au2.ID)
+	}
+	gg, err := a.FindGroupByDisplayName(context.Background(), "group-1@email.com")
+	if err != nil || gg.ID != ag1.ID {
+		t.Errorf("FindGroupByDisplayName() = %v, error = %v, want id %s", gg, err, ag1.ID)
+	}
+	if !a.members[ag1.ID][au2.ID] {
+		t.Errorf("user %s is not a member of group %s", au2.ID, ag1.ID)
+	}
+	for _, call := range a.calls {
+		if strings.HasPrefix(call, "Delete") {
+			t.Errorf("unexpected call %q", call)
+		}
+	}
+}
+
+func Test_SyncGroupsUnowned(t *testing.T) {
+	s, _, a := newTestSync()
+	s.includeGroups, _ = compilePatterns([]string{"group-1@email.com"})
+
+	// a break-glass user in the group, SyncGroups only manages the
+	// membership of the users synced by SyncUsers
+	admin := a.addUser(aws.NewUser("admin", "lastname", "admin@email.com", true))
+	ag1, _ := a.FindGroupByDisplayName(context.Background(), "Group-1")
+	a.members[ag1.ID][admin.ID] = true
+	if err := s.SyncUsers(context.Background(), ""); err != nil {
+		t.Fatalf("SyncUsers() error = %v", err)
+	}
+	s.addUser(admin)
+	if err := s.SyncGroups(context.Background(), []string{""}); err != nil {
+		t.Fatalf("SyncGroups() error = %v", err)
+	}
+	if !a.members[ag1.ID][admin.ID] {
+		t.Errorf("unowned user %s removed from group %s", admin.ID, ag1.ID)
+	}
+
+	s.cfg.ManageUnowned = true
+	if err := s.SyncGroups(context.Background(), []string{""}); err != nil {
+		t.Fatalf("SyncGroups() error = %v", err)
+	}
+	if a.members[ag1.ID][admin.ID] {
+		t.Errorf("unowned user %s not removed from group %s with --manage-unowned", admin.ID, ag1.ID)
+	}
+}
+
+func Test_SyncUsersAndGroupsTakeOver(t *testing.T) {
+	s, g, a := newTestSync()
+	s.includeGroups, _ = compilePatterns([]string{"group-2@email.com"})
+
+	// user-1 and group-2 exist in AWS without external id, created by hand
+	// or by another tool, their external id is not set
+	au1 := a.addUser(aws.NewUser("name-1", "lastname-1", "user-1@email.com", true))
+	g.addGroup("Group-2", "group-2@email.com", g.users[0])
+	ag2 := a.addGroup(aws.NewGroup("group-2@email.com"))
+
+	if err := s.SyncUsers(context.Background(), ""); err != nil {
+		t.Fatalf("SyncUsers() error = %v", err)
+	}
+	if err := s.SyncGroups(context.Background(), []string{""}); err != nil {
+		t.Fatalf("SyncGroups() error = %v", err)
+	}
+	for _, call := range a.calls {
+		if strings.HasPrefix(call, "Update") {
+			t.Errorf("unexpected call %q", call)
+		}
+	}
+	if au1.ExternalID != "" || ag2.ExternalID != "" {
+		t.Errorf("external ids set to %q and %q", au1.ExternalID, ag2.ExternalID)
+	}
+	if !a.members[ag2.ID][au1.ID] {
+		t.Errorf("user %s is not a member of group %s", au1.ID, ag2.ID)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/awslabs/ssosync/internal/google"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// userCache keeps the google users fetched during a run, each user is
+// requested by one call at a time, the calls waiting for it share its
+// result. Only the users found or missing are kept, a failed request is
+// retried by the next call. A sync is created for each run, so is its
+// cache.
+type userCache struct {
+	google google.Client
+
+	mu    sync.Mutex
+	users map[string]*userEntry
+}
+
+// userEntry is a user requested by one call at a time, holding lock
+type userEntry struct {
+	lock chan struct{}
+	done bool
+	user *admin.User
+}
+
+func newUserCache(g google.Client) *userCache {
+	return &userCache{
+		google: g,
+		users:  make(map[string]*userEntry),
+	}
+}
+
+// entry returns the entry of the email or id, created when missing
+func (c *userCache) entry(key string) *userEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key = strings.ToLower(key)
+	e, ok := c.users[key]
+	if !ok {
+		e = &userEntry{lock: make(chan struct{}, 1)}
+		c.users[key] = e
+	}
+	return e
+}
+
+// get returns the google user with the email or id given, nil when it
+// does not exist
+func (c *userCache) get(ctx context.Context, key string) (*admin.User, error) {
+	e := c.entry(key)
+	select {
+	case e.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if !e.done {
+		u, err := c.google.GetUser(ctx, key)
+		if err != nil && err != google.ErrUserNotFound {
+			<-e.lock
+			return nil, err
+		}
+		e.user, e.done = u, true
+		if err != nil {
+			e.user = nil
+		}
+	}
+	u := e.user
+	<-e.lock
+
+	// the user is also found by its other key, once the entry is
+	// unlocked as it can be the same
+	if u != nil {
+		c.add(u)
+	}
+	return u, nil
+}
+
+// add records a google user fetched otherwise, by its email and id
+func (c *userCache) add(u *admin.User) {
+	for _, key := range []string{u.PrimaryEmail, u.Id} {
+		if key == "" {
+			continue
+		}
+		e := c.entry(key)
+		e.lock <- struct{}{}
+		if !e.done {
+			e.user, e.done = u, true
+		}
+		<-e.lock
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserCache(t *testing.T) {
+	g := newFakeGoogle()
+	u1 := g.addUser("name-1", "lastname-1", "user-1@email.com")
+	c := newUserCache(g)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := c.get(context.Background(), "user-1@email.com")
+			assert.NoError(t, err)
+			assert.Equal(t, u1, u)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, map[string]int{"user-1@email.com": 1}, g.userRequests)
+
+	// the user is also cached by id and emails are not case sensitive
+	u, err := c.get(context.Background(), u1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, u1, u)
+	u, err = c.get(context.Background(), "User-1@Email.com")
+	assert.NoError(t, err)
+	assert.Equal(t, u1, u)
+
+	// members that are not users are requested once too
+	for i := 0; i < 2; i++ {
+		u, err = c.get(context.Background(), "group-1@email.com")
+		assert.NoError(t, err)
+		assert.Nil(t, u)
+	}
+	assert.Equal(t, map[string]int{"user-1@email.com": 1, "group-1@email.com": 1}, g.userRequests)
+}
+
+func TestUserCacheRetries(t *testing.T) {
+	g := newFakeGoogle()
+	u1 := g.addUser("name-1", "lastname-1", "user-1@email.com")
+	c := newUserCache(g)
+
+	// a failed request is not cached, the next call retries it
+	g.userFailures["user-1@email.com"] = 1
+	_, err := c.get(context.Background(), "user-1@email.com")
+	assert.Error(t, err)
+	u, err := c.get(context.Background(), "user-1@email.com")
+	assert.NoError(t, err)
+	assert.Equal(t, u1, u)
+	assert.Equal(t, map[string]int{"user-1@email.com": 2}, g.userRequests)
+
+	// a canceled call does not fail the others
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.get(ctx, u1.Id+"-other")
+	assert.ErrorIs(t, err, context.Canceled)
+	u, err = c.get(context.Background(), u1.Id+"-other")
+	assert.NoError(t, err)
+	assert.Nil(t, u)
+}
+
+func TestPlanGetsUsersOnce(t *testing.T) {
+	s, g, _ := newTestSync()
+	s.cfg.Concurrency = 4
+
+	// user-1 and user-2 are also in Group-2
+	g.addGroup("Group-2", "group-2@email.com", g.users...)
+
+	_, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"user-1@email.com": 1, "user-2@email.com": 1}, g.userRequests)
+}
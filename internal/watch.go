@@ -0,0 +1,260 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/awslabs/ssosync/internal/google"
+	log "github.com/sirupsen/logrus"
+	reports "google.golang.org/api/admin/reports/v1"
+)
+
+// maxNotificationSize is the maximum size of a notification body
+const maxNotificationSize = 1 << 20
+
+// DoWatch syncs the changes every cfg.IncrementalInterval until the
+// context is cancelled. With push, the admin activities are pushed by
+// google to cfg.NotificationAddress, which must reach cfg.ListenAddress,
+// and the channel is renewed before it expires. The first sync and the
+// ones following a failure poll the activities since the checkpoint, so
+// no change is missed.
+func DoWatch(ctx context.Context, cfg *config.Config) error {
+	if !incremental(cfg) {
+		return fmt.Errorf("%w: watch needs --incremental %s or %s", ErrIncremental, config.IncrementalPoll, config.IncrementalPush)
+	}
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+
+	if cfg.Incremental == config.IncrementalPush {
+		if cfg.NotificationAddress == "" {
+			return fmt.Errorf("%w: push needs --notification-address", ErrIncremental)
+		}
+
+		token, err := randomID()
+		if err != nil {
+			return err
+		}
+		notifications := newNotificationHandler(token)
+
+		googleClient, err := newGoogleClient(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		// the sync stops when the activities can't be received
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		server := &http.Server{Addr: cfg.ListenAddress, Handler: notifications}
+		failed := make(chan error, 1)
+		go func() {
+			log.WithField("address", cfg.ListenAddress).Info("receiving google admin activities")
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+				cancel()
+			}
+		}()
+		defer server.Close()
+
+		w := &watcher{google: googleClient, address: cfg.NotificationAddress, token: token}
+		defer w.stop()
+
+		err = watch(ctx, cfg, func() error { return w.renew(ctx, 2*cfg.IncrementalInterval) }, notifications.drain)
+		select {
+		case serveErr := <-failed:
+			return fmt.Errorf("failed to receive google admin activities: %w", serveErr)
+		default:
+			return err
+		}
+	}
+
+	return watch(ctx, cfg, func() error { return nil }, nil)
+}
+
+// watch runs a sync every cfg.IncrementalInterval, renew is called before
+// each sync. Without drain, or when the channel can't be renewed, the
+// activities are polled.
+func watch(ctx context.Context, cfg *config.Config, renew func() error, drain func() []*reports.Activity) error {
+	ticker := time.NewTicker(cfg.IncrementalInterval)
+	defer ticker.Stop()
+
+	poll := true
+	for {
+		if err := renew(); err != nil {
+			log.WithError(err).Error("failed to watch google admin activities, polling them")
+			poll = true
+		}
+
+		err := watchSync(ctx, cfg, func(c SyncGSuite) error {
+			if poll || drain == nil {
+				return c.SyncIncremental(ctx, cfg.GroupMatch)
+			}
+			return c.SyncActivities(ctx, cfg.GroupMatch, drain())
+		})
+		if err != nil {
+			log.WithError(err).Error("sync failed, polling the activities since the checkpoint next time")
+		}
+		poll = err != nil
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchSync runs a sync with new clients, so the google users and groups
+// are not cached between syncs, and persists the datastore. The sync is
+// cancelled after cfg.Timeout.
+func watchSync(ctx context.Context, cfg *config.Config, sync func(SyncGSuite) error) error {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	c, ds, err := newSync(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	err = sync(c)
+
+	if cfg.DryRun {
+		log.Info("dry run, datastore not persisted")
+		return err
+	}
+	return storeDatastore(ds, err)
+}
+
+// watcher keeps a channel subscribed to the admin activities
+type watcher struct {
+	google  google.Client
+	address string
+	token   string
+
+	channel *reports.Channel
+}
+
+// renew subscribes a new channel when there is none or when the current
+// one expires within the margin given, then stops the current one
+func (w *watcher) renew(ctx context.Context, margin time.Duration) error {
+	if w.channel != nil && time.Until(time.Unix(0, w.channel.Expiration*int64(time.Millisecond))) > margin {
+		return nil
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return err
+	}
+	channel, err := w.google.WatchActivities(ctx, &reports.Channel{
+		Id:      id,
+		Type:    "web_hook",
+		Address: w.address,
+		Token:   w.token,
+	})
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"channel": channel.Id, "expiration": time.Unix(0, channel.Expiration*int64(time.Millisecond))}).Info("watching google admin activities")
+
+	w.stop()
+	w.channel = channel
+	return nil
+}
+
+// stop stops the current channel
+func (w *watcher) stop() {
+	if w.channel == nil {
+		return
+	}
+	if err := w.google.StopWatching(context.Background(), w.channel); err != nil {
+		log.WithField("channel", w.channel.Id).WithError(err).Warn("failed to stop watching google admin activities")
+	}
+	w.channel = nil
+}
+
+// notificationHandler receives the admin activities google pushes to the
+// channels subscribed with the token given, they are kept until drained
+type notificationHandler struct {
+	token string
+
+	mu         sync.Mutex
+	activities []*reports.Activity
+}
+
+func newNotificationHandler(token string) *notificationHandler {
+	return &notificationHandler{token: token}
+}
+
+func (h *notificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Goog-Channel-Token")), []byte(h.token)) != 1 {
+		log.WithField("channel", r.Header.Get("X-Goog-Channel-Id")).Warn("ignoring notification with an invalid token")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	// google confirms the channel with a sync notification without activity
+	if r.Header.Get("X-Goog-Resource-State") == "sync" {
+		return
+	}
+
+	var a reports.Activity
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxNotificationSize)).Decode(&a); err != nil {
+		log.WithError(err).Warn("ignoring invalid notification")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.activities = append(h.activities, &a)
+}
+
+// drain returns the activities received since the last call
+func (h *notificationHandler) drain() []*reports.Activity {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	activities := h.activities
+	h.activities = nil
+	return activities
+}
+
+// randomID returns a random id for the channels and their token
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+	reports "google.golang.org/api/admin/reports/v1"
+)
+
+// notify posts the activity to the notification server like google does
+func notify(t *testing.T, url string, token string, state string, a *reports.Activity) int {
+	t.Helper()
+	body, err := json.Marshal(a)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("X-Goog-Channel-Id", "channel-1")
+	req.Header.Set("X-Goog-Channel-Token", token)
+	req.Header.Set("X-Goog-Resource-State", state)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestNotificationHandler(t *testing.T) {
+	s, g, a := newTestSync()
+	s.cfg.Incremental = config.IncrementalPush
+	h := newNotificationHandler("token")
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	// the first sync is a full sync
+	assert.NoError(t, s.SyncActivities(context.Background(), []string{""}, h.drain()))
+	a.calls = nil
+
+	u4 := g.addUser("name-4", "lastname-4", "user-4@email.com")
+	u4.Id = "guser-4"
+	g.members["ggroup-1"] = append(g.members["ggroup-1"], &admin.Member{Id: u4.Id, Email: u4.PrimaryEmail, Type: "USER"})
+	activity := g.addActivity(time.Now(), "GROUP_SETTINGS", "ADD_GROUP_MEMBER", "GROUP_EMAIL", "group-1@email.com", "USER_EMAIL", "user-4@email.com")
+
+	assert.Equal(t, http.StatusOK, notify(t, server.URL, "token", "sync", &reports.Activity{}))
+	assert.Equal(t, http.StatusForbidden, notify(t, server.URL, "other", "event", activity))
+	assert.Equal(t, http.StatusOK, notify(t, server.URL, "token", "event", activity))
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// only the activity pushed with the token is synced, once
+	activities := h.drain()
+	assert.Len(t, activities, 1)
+	assert.Empty(t, h.drain())
+	assert.NoError(t, s.SyncActivities(context.Background(), []string{""}, activities))
+	assert.Equal(t, []string{
+		"CreateUser user-4@email.com",
+		"AddUsersToGroup user-4@email.com Group-1",
+	}, a.calls)
+}
+
+func TestWatcherRenew(t *testing.T) {
+	g := newFakeGoogle()
+	w := &watcher{google: g, address: "https://ssosync.example.com/", token: "token"}
+
+	assert.NoError(t, w.renew(context.Background(), time.Minute))
+	if assert.Len(t, g.channels, 1) {
+		assert.Equal(t, "web_hook", g.channels[0].Type)
+		assert.Equal(t, "https://ssosync.example.com/", g.channels[0].Address)
+		assert.Equal(t, "token", g.channels[0].Token)
+	}
+	first := w.channel.Id
+
+	// the channel is only renewed when it expires within the margin
+	assert.NoError(t, w.renew(context.Background(), time.Minute))
+	assert.Equal(t, first, w.channel.Id)
+	assert.NoError(t, w.renew(context.Background(), 2*time.Hour))
+	assert.NotEqual(t, first, w.channel.Id)
+	assert.Len(t, g.channels, 1)
+
+	w.stop()
+	assert.Empty(t, g.channels)
+	assert.Nil(t, w.channel)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/datastore"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestForEach(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	done := make([]bool, 20)
+
+	errNotFound := errors.New("not found")
+	err := forEach(context.Background(), 3, len(done), func(i int) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		done[i] = true
+		mu.Unlock()
+
+		if i%5 == 4 {
+			return fmt.Errorf("item %d: %w", i, errNotFound)
+		}
+		return nil
+	})
+
+	assert.LessOrEqual(t, maxRunning, 3)
+	for i := range done {
+		assert.True(t, done[i], "item %d not processed", i)
+	}
+
+	// every failure is reported, in the order of the items
+	assert.EqualError(t, err, "4 errors occurred: item 4: not found; item 9: not found; item 14: not found; item 19: not found")
+	assert.True(t, errors.Is(err, errNotFound))
+
+	// a single failure is returned as is
+	err = forEach(context.Background(), 3, 5, func(i int) error {
+		if i == 2 {
+			return errNotFound
+		}
+		return nil
+	})
+	assert.Equal(t, errNotFound, err)
+
+	assert.NoError(t, forEach(context.Background(), 3, 0, func(i int) error { return errNotFound }))
+}
+
+func TestForEachCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	err := forEach(ctx, 1, 10, func(i int) error {
+		calls++
+		if i == 2 {
+			cancel()
+		}
+		return nil
+	})
+
+	// the call running when the context is cancelled ends, no other starts
+	assert.Equal(t, context.Canceled, err)
+	assert.LessOrEqual(t, calls, 4)
+}
+
+func TestApplyPlanConcurrently(t *testing.T) {
+	g := newFakeGoogle()
+	a := newFakeAWS()
+
+	var users []*admin.User
+	for i := 0; i < 20; i++ {
+		users = append(users, g.addUser(fmt.Sprintf("name-%d", i), "lastname", fmt.Sprintf("user-%d@email.com", i)))
+	}
+	for i := 0; i < 5; i++ {
+		g.addGroup(fmt.Sprintf("Group-%d", i), fmt.Sprintf("group-%d@email.com", i), users[i*4:i*4+4]...)
+	}
+	for i := 0; i < 10; i++ {
+		u := aws.NewUser("old", "lastname", fmt.Sprintf("old-%d@email.com", i), true)
+		u.ExternalID = externalID(fmt.Sprintf("gold-%d", i))
+		g := aws.NewGroup(fmt.Sprintf("Old-%d", i))
+		g.ExternalID = externalID(fmt.Sprintf("gold-group-%d", i))
+		a.addGroup(g, a.addUser(u))
+	}
+
+	cfg := newTestSyncConfig()
+	cfg.Concurrency = 8
+	s := New(cfg, a, g, datastore.NewNullDatastore()).(*syncGSuite)
+
+	p, err := s.PlanGroupsUsers(context.Background(), []string{""})
+	assert.NoError(t, err)
+	assert.Equal(t, "users: 20 to create, 0 to update, 10 to delete; groups: 5 to create, 0 to update, 10 to delete; members: 20 to add, 0 to remove", p.Summary())
+
+	err = s.ApplyPlan(context.Background(), p)
+	assert.NoError(t, err)
+
+	// the calls of a step are made in any order, but the steps keep theirs
+	steps := []string{"DeleteUser", "CreateUser", "CreateGroup", "AddUsersToGroup", "DeleteGroup"}
+	step := 0
+	for _, call := range a.calls {
+		for !strings.HasPrefix(call, steps[step]+" ") {
+			step++
+			if step == len(steps) {
+				t.Fatalf("call %q out of order in %v", call, a.calls)
+			}
+		}
+	}
+	assert.Len(t, a.calls, 10+20+5+5+10)
+}